@@ -0,0 +1,177 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"io"
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultBlobReadChunkSize is the default size of chunks read from a Blob.
+const DefaultBlobReadChunkSize = 64 * 1024
+
+// Blob is a js Blob or File object.
+//
+// Blobs can be stored in IndexedDB directly with Put or Add, and are encoded
+// by EncodeValue and decoded by DecodeValue. The contents remain in js memory
+// until they are read.
+//
+// Reading a Blob waits for a Promise, which yields to the event loop and
+// commits any active transaction: read the blob after the transaction.
+type Blob struct {
+	val js.Value
+}
+
+// NewBlob wraps a js Blob or File object.
+//
+// Returns an error if the value is not a Blob.
+func NewBlob(val js.Value) (*Blob, error) {
+	if val.Type() != js.TypeObject || !val.InstanceOf(js.Global().Get("Blob")) {
+		return nil, errors.Errorf("expected Blob but got %s", val.Type().String())
+	}
+	return &Blob{val: val}, nil
+}
+
+// NewBlobFromReader creates a Blob by reading r until EOF.
+//
+// The data is copied to js in chunks: the full contents are never held in Go memory.
+func NewBlobFromReader(r io.Reader, contentType string) (*Blob, error) {
+	parts, err := readBlobParts(r)
+	if err != nil {
+		return nil, err
+	}
+	opts := js.Global().Get("Object").New()
+	opts.Set("type", contentType)
+	return &Blob{val: js.Global().Get("Blob").New(parts, opts)}, nil
+}
+
+// NewFileFromReader creates a File by reading r until EOF.
+func NewFileFromReader(r io.Reader, name, contentType string) (*Blob, error) {
+	parts, err := readBlobParts(r)
+	if err != nil {
+		return nil, err
+	}
+	opts := js.Global().Get("Object").New()
+	opts.Set("type", contentType)
+	return &Blob{val: js.Global().Get("File").New(parts, name, opts)}, nil
+}
+
+// readBlobParts reads r into an array holding a single Blob part.
+//
+// Each chunk is folded into the Blob as it is read, so the chunks are not
+// held in js memory alongside the Blob.
+func readBlobParts(r io.Reader) (js.Value, error) {
+	blobCtor, arrayCtor := js.Global().Get("Blob"), js.Global().Get("Array")
+	blob := blobCtor.New(arrayCtor.New())
+	buf := make([]byte, DefaultBlobReadChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n != 0 {
+			blob = blobCtor.New(arrayCtor.New(blob, CopyByteSliceToJs(buf[:n])))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return arrayCtor.New(blob), nil
+		}
+		if err != nil {
+			return js.Undefined(), err
+		}
+	}
+}
+
+// GetJsValue returns the js Blob object.
+func (b *Blob) GetJsValue() js.Value {
+	return b.val
+}
+
+// Size returns the size of the blob in bytes.
+func (b *Blob) Size() int64 {
+	return int64(b.val.Get("size").Float())
+}
+
+// Type returns the MIME type of the blob, if known.
+func (b *Blob) Type() string {
+	return b.val.Get("type").String()
+}
+
+// IsFile checks if the blob is a File.
+func (b *Blob) IsFile() bool {
+	return b.val.InstanceOf(js.Global().Get("File"))
+}
+
+// Name returns the name of the File, empty if the blob is not a File.
+func (b *Blob) Name() string {
+	if !b.IsFile() {
+		return ""
+	}
+	return b.val.Get("name").String()
+}
+
+// Slice returns a blob with the bytes from start up to end.
+func (b *Blob) Slice(start, end int64) *Blob {
+	return &Blob{val: b.val.Call("slice", float64(start), float64(end))}
+}
+
+// ReadAt reads len(p) bytes from the blob starting at off.
+func (b *Blob) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	size := b.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > size {
+		end = size
+	}
+	buf, err := awaitPromise(b.Slice(off, end).val.Call("arrayBuffer"))
+	if err != nil {
+		return 0, err
+	}
+	n := js.CopyBytesToGo(p, jsUint8Array.New(buf))
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// NewReader returns a reader over the blob contents.
+//
+// Each Read copies directly into the caller's buffer.
+func (b *Blob) NewReader() *io.SectionReader {
+	return io.NewSectionReader(b, 0, b.Size())
+}
+
+// ReadChunks reads the blob in chunks, calling cb with each chunk.
+//
+// The chunk buffer is reused between calls: it is only valid during the
+// callback and must be copied to be retained. If chunkSize is zero, uses
+// DefaultBlobReadChunkSize.
+func (b *Blob) ReadChunks(chunkSize int, cb func(chunk []byte) error) error {
+	if chunkSize <= 0 {
+		chunkSize = DefaultBlobReadChunkSize
+	}
+	buf := make([]byte, chunkSize)
+	var off int64
+	for {
+		n, err := b.ReadAt(buf, off)
+		if n != 0 {
+			if cerr := cb(buf[:n]); cerr != nil {
+				return cerr
+			}
+			off += int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// _ is a type assertion
+var _ io.ReaderAt = ((*Blob)(nil))
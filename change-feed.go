@@ -0,0 +1,332 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"bytes"
+	"sync"
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// changeFeedChannelPrefix is the prefix of the BroadcastChannel name.
+const changeFeedChannelPrefix = "indexeddb-changes/"
+
+// ChangeEvent describes changes to an object store committed by a DurableTransaction.
+type ChangeEvent struct {
+	// Store is the object store name.
+	Store string
+	// Keys are the changed keys matching the watched prefix.
+	// Binary keys are returned as Uint8Array. May contain duplicates.
+	Keys []js.Value
+	// AllKeys indicates the store was cleared or a key range was deleted.
+	// Any key may have changed.
+	AllKeys bool
+	// Local indicates the change was committed with this Database handle.
+	Local bool
+}
+
+// watcherQueueLimit is the number of events queued for a Watcher before
+// further events are coalesced.
+const watcherQueueLimit = 256
+
+// changeFeed publishes and receives changes over a BroadcastChannel.
+type changeFeed struct {
+	channel   js.Value
+	onMessage js.Func
+
+	mtx      sync.Mutex
+	watchers map[*Watcher]struct{}
+	// readCache is invalidated by changes from other tabs, if set.
+	readCache *readCache
+}
+
+// EnableChangeFeed publishes changes committed by durable transactions.
+//
+// After each successful DurableTransaction.Commit, the changed store names and
+// keys are published over a BroadcastChannel named after the database, and
+// delivered to any Watchers in this and other tabs. Every tab writing to the
+// database must enable the change feed for Watch to observe its changes.
+func (d *Database) EnableChangeFeed() error {
+	if d.feed != nil {
+		return nil
+	}
+	bc := js.Global().Get("BroadcastChannel")
+	if !bc.Truthy() {
+		return errors.New("BroadcastChannel is not available")
+	}
+	f := &changeFeed{
+		channel:  bc.New(changeFeedChannelPrefix + d.GetName()),
+		watchers: make(map[*Watcher]struct{}),
+	}
+	f.onMessage = js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+		f.dispatch(dats[0].Get("data"), false)
+		return nil
+	})
+	f.channel.Set("onmessage", f.onMessage)
+	d.feed = f
+	return nil
+}
+
+// DisableChangeFeed stops publishing changes and closes any Watchers.
+//
+// Also disables the read cache, which relies on the change feed.
+func (d *Database) DisableChangeFeed() {
+	f := d.feed
+	if f == nil {
+		return
+	}
+	d.DisableReadCache()
+	d.feed = nil
+	f.channel.Call("close")
+	f.onMessage.Release()
+	f.mtx.Lock()
+	watchers := f.watchers
+	f.watchers = nil
+	f.mtx.Unlock()
+	for w := range watchers {
+		w.close()
+	}
+}
+
+// Watch watches an object store for committed changes to keys with a prefix.
+//
+// The prefix matches binary and string keys. An empty prefix matches all keys.
+// Enables the change feed if not already enabled. Call Close on the Watcher
+// to stop watching.
+//
+// Events are queued until received. If the queue is full, further events are
+// coalesced into a single AllKeys event: re-read the store when one arrives.
+func (d *Database) Watch(store string, prefix []byte) (*Watcher, error) {
+	if err := d.EnableChangeFeed(); err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		feed:   d.feed,
+		store:  store,
+		prefix: append([]byte(nil), prefix...),
+		ch:     make(chan *ChangeEvent),
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	d.feed.mtx.Lock()
+	d.feed.watchers[w] = struct{}{}
+	d.feed.mtx.Unlock()
+	go w.deliver()
+	return w, nil
+}
+
+// publish publishes the changes from a committed transaction.
+func (f *changeFeed) publish(changes []durableChange) {
+	msg := buildChangeMessage(changes)
+	if msg.Length() == 0 {
+		return
+	}
+	f.channel.Call("postMessage", msg)
+	f.dispatch(msg, true)
+}
+
+// dispatch delivers a change message to the matching watchers.
+func (f *changeFeed) dispatch(msg js.Value, local bool) {
+	if !js.Global().Get("Array").Call("isArray", msg).Bool() {
+		return
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for i := 0; i < msg.Length(); i++ {
+		ent := msg.Index(i)
+		store := ent.Get("store").String()
+		allKeys := ent.Get("all").Truthy()
+		keys := jsArrayToSlice(ent.Get("keys"))
+		for j, key := range keys {
+			keys[j] = cursorKey(key)
+		}
+		if f.readCache != nil && !local {
+			f.readCache.invalidate(store, keys, allKeys)
+		}
+		for w := range f.watchers {
+			if w.store != store {
+				continue
+			}
+			matched := w.filterKeys(keys)
+			if len(matched) == 0 && !allKeys {
+				continue
+			}
+			w.push(&ChangeEvent{
+				Store:   store,
+				Keys:    matched,
+				AllKeys: allKeys,
+				Local:   local,
+			})
+		}
+	}
+}
+
+// durableChange is a write op committed by a durable transaction.
+type durableChange struct {
+	store string
+	op    *durableOp
+}
+
+// buildChangeMessage builds the message with the changes grouped by store.
+//
+// The message is an array of {store, keys, all} objects.
+func buildChangeMessage(changes []durableChange) js.Value {
+	global := js.Global()
+	keyRange := global.Get("IDBKeyRange")
+	msg := global.Get("Array").New()
+	byStore := make(map[string]js.Value)
+	for _, change := range changes {
+		ent, ok := byStore[change.store]
+		if !ok {
+			ent = global.Get("Object").New()
+			ent.Set("store", change.store)
+			ent.Set("keys", global.Get("Array").New())
+			ent.Set("all", false)
+			byStore[change.store] = ent
+			msg.Call("push", ent)
+		}
+
+		op := change.op
+		var key js.Value
+		switch op.kind {
+		case durableOpClear:
+			ent.Set("all", true)
+			continue
+		case durableOpDelete:
+			if k, ok := op.key.(js.Value); ok {
+				key = k
+			} else {
+				key = js.ValueOf(op.key)
+			}
+			if key.Type() == js.TypeObject && key.InstanceOf(keyRange) {
+				ent.Set("all", true)
+				continue
+			}
+		default:
+			// the key may have been generated or extracted from the value.
+			var err error
+			key, err = op.result.Key()
+			if err != nil {
+				continue
+			}
+		}
+		if key.Type() == js.TypeUndefined || key.Type() == js.TypeNull {
+			continue
+		}
+		ent.Get("keys").Call("push", key)
+	}
+	return msg
+}
+
+// Watcher receives change events for an object store.
+type Watcher struct {
+	feed   *changeFeed
+	store  string
+	prefix []byte
+	ch     chan *ChangeEvent
+
+	mtx   sync.Mutex
+	queue []*ChangeEvent
+	// overflow is the queued AllKeys event coalescing events past the limit.
+	overflow *ChangeEvent
+	wake     chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// Events returns the channel of change events.
+// The channel is closed when the Watcher is closed.
+func (w *Watcher) Events() <-chan *ChangeEvent {
+	return w.ch
+}
+
+// Close stops watching for changes.
+func (w *Watcher) Close() {
+	w.feed.mtx.Lock()
+	if w.feed.watchers != nil {
+		delete(w.feed.watchers, w)
+	}
+	w.feed.mtx.Unlock()
+	w.close()
+}
+
+// close closes the closed channel.
+func (w *Watcher) close() {
+	w.once.Do(func() {
+		close(w.closed)
+	})
+}
+
+// filterKeys returns the keys matching the prefix.
+func (w *Watcher) filterKeys(keys []js.Value) []js.Value {
+	if len(w.prefix) == 0 {
+		return keys
+	}
+	var out []js.Value
+	for _, key := range keys {
+		switch {
+		case key.Type() == js.TypeString:
+			if bytes.HasPrefix([]byte(key.String()), w.prefix) {
+				out = append(out, key)
+			}
+		case key.Type() == js.TypeObject && key.InstanceOf(jsUint8Array):
+			if bytes.HasPrefix(CopyByteSliceFromJs(key), w.prefix) {
+				out = append(out, key)
+			}
+		}
+	}
+	return out
+}
+
+// push queues an event without blocking.
+//
+// If watcherQueueLimit events are queued, the last queued event is replaced
+// with an AllKeys event, which absorbs any further events.
+func (w *Watcher) push(ev *ChangeEvent) {
+	w.mtx.Lock()
+	switch n := len(w.queue); {
+	case w.overflow != nil:
+		w.overflow.Local = w.overflow.Local && ev.Local
+	case n >= watcherQueueLimit:
+		last := w.queue[n-1]
+		w.overflow = &ChangeEvent{
+			Store:   w.store,
+			AllKeys: true,
+			Local:   last.Local && ev.Local,
+		}
+		w.queue[n-1] = w.overflow
+	default:
+		w.queue = append(w.queue, ev)
+	}
+	w.mtx.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver delivers queued events to the events channel.
+func (w *Watcher) deliver() {
+	defer close(w.ch)
+	for {
+		w.mtx.Lock()
+		queue := w.queue
+		w.queue, w.overflow = nil, nil
+		w.mtx.Unlock()
+		for _, ev := range queue {
+			select {
+			case <-w.closed:
+				return
+			case w.ch <- ev:
+			}
+		}
+		select {
+		case <-w.closed:
+			return
+		case <-w.wake:
+		}
+	}
+}
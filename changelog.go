@@ -0,0 +1,222 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"syscall/js"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ChangelogObjectStoreID is the id of the object store used for the changelog.
+//
+// Create it during an upgrade with DatabaseUpdate.CreateChangelogObjectStore.
+const ChangelogObjectStoreID = "indexeddb-changelog"
+
+// changelogEntryProp is the request property holding the pending changelog entry.
+const changelogEntryProp = "__indexeddbChangelogEntry"
+
+// ChangelogEntry is a write recorded in the changelog.
+type ChangelogEntry struct {
+	// Seq is the sequence number of the entry, increasing with each write.
+	Seq int
+	// Store is the object store id.
+	Store string
+	// Op is the operation: "put", "add", "delete", or "clear".
+	Op string
+	// Key is the key of the written or deleted record.
+	// Undefined for clear and for deletes of a key range.
+	Key js.Value
+	// KeyRange is the IDBKeyRange of a delete, undefined otherwise.
+	KeyRange js.Value
+	// Value is the written value, if values are included in the changelog.
+	Value js.Value
+	// Time is the time the write was applied.
+	Time time.Time
+}
+
+// ChangelogOptions are the options for recording the writes of a durable
+// transaction in the changelog.
+type ChangelogOptions struct {
+	// IncludeValues includes the written value in put and add entries.
+	IncludeValues bool
+}
+
+// changelog contains the changelog settings for a durable transaction.
+type changelog struct {
+	// values indicates values are included in put and add entries.
+	values bool
+}
+
+// newChangelog builds the changelog settings from the options.
+// Returns nil if opts is nil.
+func newChangelog(opts *ChangelogOptions) *changelog {
+	if opts == nil {
+		return nil
+	}
+	return &changelog{values: opts.IncludeValues}
+}
+
+// WithChangelog records the writes of a READWRITE DurableTransaction in the
+// ChangelogObjectStoreID object store. Ignored for READONLY transactions.
+func WithChangelog(opts *ChangelogOptions) DurableTransactionOption {
+	return func(t *DurableTransaction) {
+		t.changelog = newChangelog(opts)
+	}
+}
+
+// CreateChangelogObjectStore creates the object store for the changelog.
+// Does nothing if it already exists.
+func (d *DatabaseUpdate) CreateChangelogObjectStore() error {
+	if d.ContainsObjectStore(ChangelogObjectStoreID) {
+		return nil
+	}
+	return d.CreateObjectStore(ChangelogObjectStoreID, NewCreateObjectStoreOpts("", true))
+}
+
+// checkChangelogObjectStore checks the changelog object store exists.
+func (d *Database) checkChangelogObjectStore() error {
+	if !d.ContainsObjectStore(ChangelogObjectStoreID) {
+		return errors.Errorf("object store %s not found", ChangelogObjectStoreID)
+	}
+	return nil
+}
+
+// changelogListener appends the pending changelog entry when a write request succeeds.
+//
+// Runs in the request success event, so the entry is added in the same transaction.
+// Does nothing if the changelog object store is not in the transaction scope.
+var changelogListener = js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+	req := dats[0].Get("target")
+	ent := req.Get(changelogEntryProp)
+	if ent.Type() != js.TypeObject {
+		return nil
+	}
+	req.Delete(changelogEntryProp)
+	txn := req.Get("transaction")
+	if !txn.Get("objectStoreNames").Call("contains", ChangelogObjectStoreID).Bool() {
+		return nil
+	}
+	switch durableOpKind(ent.Get("op").String()) {
+	case durableOpPut, durableOpAdd:
+		// the key may have been generated or extracted from the value.
+		ent.Set("key", req.Get("result"))
+	}
+	ent.Set("time", js.Global().Get("Date").Call("now"))
+	txn.Call("objectStore", ChangelogObjectStoreID).Call("add", ent)
+	return nil
+})
+
+// logRequest records the op in the changelog when the request succeeds.
+func (c *changelog) logRequest(req js.Value, storeID string, op *durableOp) {
+	ent := js.Global().Get("Object").New()
+	ent.Set("store", storeID)
+	ent.Set("op", string(op.kind))
+	switch op.kind {
+	case durableOpPut, durableOpAdd:
+		if c.values {
+			ent.Set("value", op.value)
+		}
+	case durableOpDelete:
+		key := js.ValueOf(op.key)
+		if key.Type() == js.TypeObject && key.InstanceOf(js.Global().Get("IDBKeyRange")) {
+			// IDBKeyRange cannot be cloned: store the bounds.
+			rng := js.Global().Get("Object").New()
+			for _, field := range []string{"lower", "upper", "lowerOpen", "upperOpen"} {
+				rng.Set(field, key.Get(field))
+			}
+			ent.Set("range", rng)
+		} else {
+			ent.Set("key", key)
+		}
+	}
+	req.Set(changelogEntryProp, ent)
+	req.Call("addEventListener", "success", changelogListener)
+}
+
+// changelogEntryFromJs converts a stored changelog entry.
+func changelogEntryFromJs(seq int, val js.Value) *ChangelogEntry {
+	ent := &ChangelogEntry{
+		Seq:      seq,
+		Store:    val.Get("store").String(),
+		Op:       val.Get("op").String(),
+		Key:      cursorKey(val.Get("key")),
+		KeyRange: js.Undefined(),
+		Value:    val.Get("value"),
+		Time:     time.UnixMilli(int64(val.Get("time").Float())).UTC(),
+	}
+	if rng := val.Get("range"); rng.Type() == js.TypeObject {
+		lower, upper := rng.Get("lower"), rng.Get("upper")
+		lowerOpen, upperOpen := rng.Get("lowerOpen").Bool(), rng.Get("upperOpen").Bool()
+		switch {
+		case lower.IsUndefined():
+			ent.KeyRange = UpperBound(upper, upperOpen)
+		case upper.IsUndefined():
+			ent.KeyRange = LowerBound(lower, lowerOpen)
+		default:
+			ent.KeyRange = Bound(lower, upper, lowerOpen, upperOpen)
+		}
+	}
+	return ent
+}
+
+// ReadChangelog reads up to limit changelog entries with a sequence number after after.
+//
+// Pass the last read sequence number to continue, zero to read from the
+// start. A limit of zero reads all entries.
+func (d *Database) ReadChangelog(after int, limit int) (entries []*ChangelogEntry, e error) {
+	txn, err := d.Transaction([]string{ChangelogObjectStoreID}, READONLY)
+	if err != nil {
+		return nil, err
+	}
+	store, err := txn.GetObjectStore(ChangelogObjectStoreID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+
+	// issue both requests before waiting so they read the same snapshot.
+	krv := LowerBound(after, true)
+	keysReq := store.val.Call("getAllKeys", countArgs(krv, limit)...)
+	valsReq := store.val.Call("getAll", countArgs(krv, limit)...)
+	keys, err := WaitRequest(keysReq)
+	if err != nil {
+		return nil, err
+	}
+	vals, err := WaitRequest(valsReq)
+	if err != nil {
+		return nil, err
+	}
+	entries = make([]*ChangelogEntry, keys.Length())
+	for i := range entries {
+		entries[i] = changelogEntryFromJs(keys.Index(i).Int(), vals.Index(i))
+	}
+	return entries, nil
+}
+
+// TruncateChangelog removes changelog entries with a sequence number up to and including through.
+//
+// Call after the entries have been acknowledged by the consumer.
+func (d *Database) TruncateChangelog(through int) (e error) {
+	txn, err := d.Transaction([]string{ChangelogObjectStoreID}, READWRITE)
+	if err != nil {
+		return err
+	}
+	store, err := txn.GetObjectStore(ChangelogObjectStoreID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	store.val.Call("delete", UpperBound(through, false))
+	return txn.WaitComplete()
+}
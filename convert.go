@@ -2,7 +2,18 @@
 
 package indexeddb
 
-import "syscall/js"
+import (
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// jsUint8Array is the Uint8Array constructor.
+	jsUint8Array = js.Global().Get("Uint8Array")
+	// jsArrayBuffer is the ArrayBuffer constructor.
+	jsArrayBuffer = js.Global().Get("ArrayBuffer")
+)
 
 // Bound builds a new IDBKeyRange with the range.
 func Bound(lower, upper interface{}, lowerOpen, upperOpen bool) js.Value {
@@ -17,25 +28,161 @@ func Bound(lower, upper interface{}, lowerOpen, upperOpen bool) js.Value {
 		)
 }
 
+// LowerBound builds a new IDBKeyRange with only a lower bound.
+func LowerBound(lower interface{}, open bool) js.Value {
+	return js.Global().
+		Get("IDBKeyRange").
+		Call("lowerBound", MaybeConvertValueToJs(lower), open)
+}
+
+// UpperBound builds a new IDBKeyRange with only an upper bound.
+func UpperBound(upper interface{}, open bool) js.Value {
+	return js.Global().
+		Get("IDBKeyRange").
+		Call("upperBound", MaybeConvertValueToJs(upper), open)
+}
+
 // MaybeConvertValueToJs conditionally converts val to javascript.
+//
+// Uses ConvertValueToJs, returning val unchanged if it cannot be converted.
 func MaybeConvertValueToJs(val interface{}) interface{} {
+	// fast path for common keys and values
 	switch vb := val.(type) {
 	case []byte:
 		return CopyByteSliceToJs(vb)
+	case js.Value, string, int, int64, float64:
+		return val
 	}
-	return val
+	out, err := ConvertValueToJs(val)
+	if err != nil {
+		return val
+	}
+	return out
 }
 
 // CopyByteSliceToJS copies a byte slice to javascript.
 func CopyByteSliceToJs(vb []byte) js.Value {
-	vba := js.Global().Get("Uint8Array").New(len(vb))
+	vba := jsUint8Array.New(len(vb))
 	js.CopyBytesToJS(vba, vb)
 	return vba
 }
 
 // CopyByteSliceFromJS copies a byte slice from javascript.
 func CopyByteSliceFromJs(vb js.Value) []byte {
-	b := make([]byte, vb.Length())
-	js.CopyBytesToGo(b, vb)
-	return b
+	return CopyByteSliceFromJsInto(nil, vb)
+}
+
+// CopyByteSliceFromJsInto copies a byte slice from javascript into dst.
+//
+// Reuses the capacity of dst if possible, otherwise allocates a new slice.
+// Returns the slice containing the copied data.
+func CopyByteSliceFromJsInto(dst []byte, vb js.Value) []byte {
+	n := vb.Length()
+	if cap(dst) < n {
+		dst = make([]byte, n)
+	} else {
+		dst = dst[:n]
+	}
+	js.CopyBytesToGo(dst, vb)
+	return dst
+}
+
+// CopyBinaryFromJs copies an ArrayBuffer or ArrayBufferView to a byte slice.
+//
+// Binary keys are returned by IndexedDB as ArrayBuffer objects.
+// Returns an error if the value is not binary.
+func CopyBinaryFromJs(val js.Value) ([]byte, error) {
+	if val.Type() != js.TypeObject {
+		return nil, errors.Errorf("expected binary value but got %s", val.Type().String())
+	}
+	switch {
+	case val.InstanceOf(jsUint8Array):
+		return CopyByteSliceFromJs(val), nil
+	case val.InstanceOf(jsArrayBuffer):
+		return CopyByteSliceFromJs(jsUint8Array.New(val)), nil
+	case jsArrayBuffer.Call("isView", val).Bool():
+		return CopyByteSliceFromJs(jsUint8Array.New(
+			val.Get("buffer"),
+			val.Get("byteOffset"),
+			val.Get("byteLength"),
+		)), nil
+	}
+	return nil, errors.New("expected binary value but got non-binary object")
+}
+
+// jsArrayToSlice converts a js array to a slice of values.
+func jsArrayToSlice(arr js.Value) []js.Value {
+	out := make([]js.Value, arr.Length())
+	for i := range out {
+		out[i] = arr.Index(i)
+	}
+	return out
+}
+
+// bytesSliceFromJs copies a list of binary values to byte slices.
+func bytesSliceFromJs(vals []js.Value) ([][]byte, error) {
+	out := make([][]byte, len(vals))
+	for i, val := range vals {
+		var err error
+		out[i], err = CopyBinaryFromJs(val)
+		if err != nil {
+			return nil, errors.Wrapf(err, "index %d", i)
+		}
+	}
+	return out, nil
+}
+
+// countArgs builds the arguments for getAll or getAllKeys.
+// Omits the count if it is zero.
+func countArgs(query interface{}, count int) []interface{} {
+	if count <= 0 {
+		return []interface{}{query}
+	}
+	if query == nil {
+		query = js.Undefined()
+	}
+	return []interface{}{query, count}
+}
+
+// scanPages iterates over a key range in pages using getPage.
+//
+// Each page after the first starts after the last key of the previous page.
+func scanPages(
+	krv js.Value,
+	pageSize int,
+	getPage func(krv js.Value, count int) (keys, vals []js.Value, err error),
+	cb func(keys, vals []js.Value) error,
+) error {
+	if pageSize <= 0 {
+		return errors.New("page size must be greater than zero")
+	}
+	keyRange := js.Global().Get("IDBKeyRange")
+	unbounded := krv.IsUndefined() || krv.IsNull()
+	isRange := krv.Type() == js.TypeObject && krv.InstanceOf(keyRange)
+	hasUpper := isRange && !krv.Get("upper").IsUndefined()
+	for {
+		keys, vals, err := getPage(krv, pageSize)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if err := cb(keys, vals); err != nil {
+			return err
+		}
+		if len(keys) < pageSize {
+			return nil
+		}
+		if !unbounded && !isRange {
+			// krv is a single key
+			return nil
+		}
+		lastKey := keys[len(keys)-1]
+		if hasUpper {
+			krv = keyRange.Call("bound", lastKey, krv.Get("upper"), true, krv.Get("upperOpen"))
+		} else {
+			krv = keyRange.Call("lowerBound", lastKey, true)
+		}
+	}
 }
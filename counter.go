@@ -0,0 +1,150 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"sync"
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// MaxCounterValue is the largest counter value which can be stored exactly.
+//
+// Counters are stored as js numbers, which represent integers exactly up to 2^53-1.
+const MaxCounterValue = 1<<53 - 1
+
+// DefaultSequenceBatchSize is the default number of ids reserved at a time by a Sequence.
+const DefaultSequenceBatchSize = 100
+
+// Counter is an integer counter stored in a record of an object store.
+//
+// Updates are atomic across transactions and tabs, and are committed or
+// aborted with the transaction.
+type Counter struct {
+	store *DurableObjectStore
+	key   interface{}
+}
+
+// NewCounter constructs a Counter stored at a key in an object store.
+//
+// The store must use out-of-line keys.
+func NewCounter(store *DurableObjectStore, key interface{}) *Counter {
+	return &Counter{store: store, key: key}
+}
+
+// counterValue converts a stored counter value.
+// Returns zero if the value is undefined.
+func counterValue(val js.Value) (int64, error) {
+	switch val.Type() {
+	case js.TypeUndefined:
+		return 0, nil
+	case js.TypeNumber:
+		return int64(val.Float()), nil
+	default:
+		return 0, errors.Errorf("counter value is not a number: %s", val.Type().String())
+	}
+}
+
+// Get returns the current value of the counter.
+func (c *Counter) Get() (int64, error) {
+	val, err := c.store.Get(c.key)
+	if err != nil {
+		return 0, err
+	}
+	return counterValue(val)
+}
+
+// Add atomically adds delta to the counter and returns the new value.
+func (c *Counter) Add(delta int64) (int64, error) {
+	var next int64
+	_, err := c.store.UpdateAtomic(c.key, func(cur js.Value) (js.Value, error) {
+		val, err := counterValue(cur)
+		if err != nil {
+			return js.Undefined(), err
+		}
+		next = val + delta
+		if next > MaxCounterValue || next < -MaxCounterValue {
+			return js.Undefined(), errors.Errorf("counter value out of range: %d", next)
+		}
+		return js.ValueOf(float64(next)), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+// Sequence generates unique increasing ids.
+//
+// Ids are reserved from a Counter in batches, each in its own transaction,
+// and handed out from memory. The counter stores the high-water mark: ids are
+// unique across tabs and reloads, but ids reserved and not used are skipped.
+// Safe for concurrent use.
+type Sequence struct {
+	db        *Database
+	storeID   string
+	key       interface{}
+	batchSize int
+
+	mtx sync.Mutex
+	// next is the next id to hand out.
+	next int64
+	// limit is the last reserved id.
+	limit int64
+}
+
+// NewSequence constructs a Sequence backed by a counter at a key in an object store.
+//
+// The store must use out-of-line keys. A batchSize of zero uses DefaultSequenceBatchSize.
+// The first id is 1.
+func NewSequence(db *Database, storeID string, key interface{}, batchSize int) *Sequence {
+	if batchSize <= 0 {
+		batchSize = DefaultSequenceBatchSize
+	}
+	return &Sequence{
+		db:        db,
+		storeID:   storeID,
+		key:       key,
+		batchSize: batchSize,
+	}
+}
+
+// Next returns the next id, reserving a new batch if needed.
+func (s *Sequence) Next() (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.next == 0 || s.next > s.limit {
+		limit, err := s.reserve()
+		if err != nil {
+			return 0, err
+		}
+		s.next, s.limit = limit-int64(s.batchSize)+1, limit
+	}
+	id := s.next
+	s.next++
+	return id, nil
+}
+
+// reserve reserves a batch of ids, returning the high-water mark.
+func (s *Sequence) reserve() (int64, error) {
+	durTx, err := NewDurableTransaction(s.db, []string{s.storeID}, READWRITE)
+	if err != nil {
+		return 0, err
+	}
+	store, err := durTx.GetObjectStore(s.storeID)
+	if err != nil {
+		durTx.Abort()
+		return 0, err
+	}
+	limit, err := NewCounter(store, s.key).Add(int64(s.batchSize))
+	if err != nil {
+		durTx.Abort()
+		return 0, err
+	}
+	if err := durTx.Commit(); err != nil {
+		return 0, err
+	}
+	return limit, nil
+}
@@ -11,34 +11,55 @@ type Cursor struct {
 	val        js.Value
 	lastCursor js.Value
 	nextCh     chan *CursorValue
+	// storeCursor indicates the source is an object store.
+	// in this case the primary key is the same as the key.
+	storeCursor bool
 }
 
 // CursorValue is a object store cursor value.
+//
+// Binary keys are returned as Uint8Array.
 type CursorValue struct {
-	Key   js.Value
+	// Key is the key, or the index key for index cursors.
+	Key js.Value
+	// PrimaryKey is the record key, the same as Key for object store cursors.
+	PrimaryKey js.Value
+	// Value is the record value, undefined for key cursors.
 	Value js.Value
 }
 
+// cursorKey wraps ArrayBuffer keys in a Uint8Array view.
+// Other keys are returned as-is.
+func cursorKey(key js.Value) js.Value {
+	if key.Type() == js.TypeObject && key.InstanceOf(jsArrayBuffer) {
+		return jsUint8Array.New(key)
+	}
+	return key
+}
+
 // NewCursor builds a new cursor and registers the onsuccess handler.
 func NewCursor(val js.Value) *Cursor {
 	c := &Cursor{val: val}
+	c.storeCursor = val.Get("source").InstanceOf(js.Global().Get("IDBObjectStore"))
+	// at most one value is pending: the next value is requested by ContinueCursor.
 	c.nextCh = make(chan *CursorValue, 1)
 	val.Set("onsuccess", js.FuncOf(
 		func(th js.Value, dats []js.Value) interface{} {
-			cursor := dats[0].Get("target").Get("result")
-			global := js.Global()
+			cursor := val.Get("result")
 			c.lastCursor = cursor
 			if !cursor.Truthy() {
 				close(c.nextCh)
 			} else {
-				global.Set("resultDebugCursor", cursor)
 				cv := &CursorValue{
-					Key:   global.Get("Uint8Array").New(cursor.Get("key")),
+					Key:   cursorKey(cursor.Get("key")),
 					Value: cursor.Get("value"),
 				}
-				go func() {
-					c.nextCh <- cv
-				}()
+				if c.storeCursor {
+					cv.PrimaryKey = cv.Key
+				} else {
+					cv.PrimaryKey = cursorKey(cursor.Get("primaryKey"))
+				}
+				c.nextCh <- cv
 			}
 			return nil
 		},
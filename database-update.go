@@ -11,6 +11,15 @@ import (
 // DatabaseUpdate is a database during the updateneeded callback.
 type DatabaseUpdate struct {
 	*Database
+	// txn is the versionchange transaction
+	txn *Transaction
+}
+
+// GetTransaction returns the VERSIONCHANGE transaction for the upgrade.
+//
+// Use this to access existing object stores during the upgrade.
+func (d *DatabaseUpdate) GetTransaction() *Transaction {
+	return d.txn
 }
 
 // CreateObjectStoreOpts are the options for creating an object store.
@@ -18,13 +27,28 @@ type CreateObjectStoreOpts struct {
 	val js.Value
 
 	// keyPath is the key path.
-	keyPath string
+	// if nil, the store uses out-of-line keys.
+	keyPath *KeyPath
 	// AutoIncrement if set
 	autoIncrement bool
 }
 
 // NewCreateObjectStoreOpts constructs the options for CreateObjectStore.
+//
+// An empty keyPath creates a store with out-of-line keys.
 func NewCreateObjectStoreOpts(keyPath string, autoIncrement bool) *CreateObjectStoreOpts {
+	var kp *KeyPath
+	if keyPath != "" {
+		kp = NewKeyPath(keyPath)
+	}
+	return NewCreateObjectStoreOptsWithKeyPath(kp, autoIncrement)
+}
+
+// NewCreateObjectStoreOptsWithKeyPath constructs the options for CreateObjectStore.
+//
+// Use NewCompoundKeyPath for a compound key path.
+// A nil keyPath creates a store with out-of-line keys.
+func NewCreateObjectStoreOptsWithKeyPath(keyPath *KeyPath, autoIncrement bool) *CreateObjectStoreOpts {
 	return &CreateObjectStoreOpts{
 		keyPath:       keyPath,
 		autoIncrement: autoIncrement,
@@ -34,7 +58,9 @@ func NewCreateObjectStoreOpts(keyPath string, autoIncrement bool) *CreateObjectS
 // ToJSValue converts the object to a js value.
 func (o *CreateObjectStoreOpts) ToJSValue() js.Value {
 	val := js.Global().Get("Object").New()
-	val.Set("keyPath", o.keyPath)
+	if o.keyPath != nil {
+		val.Set("keyPath", o.keyPath.ToJSValue())
+	}
 	val.Set("autoIncrement", o.autoIncrement)
 	return val
 }
@@ -49,7 +75,7 @@ func (d *DatabaseUpdate) CreateObjectStore(
 		if rerr := recover(); rerr != nil {
 			if err == nil {
 				var ok bool
-				err, ok = rerr.(error)
+				err, ok = recoveredError(rerr)
 				if !ok {
 					err = errors.New("create object store paniced")
 				}
@@ -64,3 +90,17 @@ func (d *DatabaseUpdate) CreateObjectStore(
 	d.Database.val.Call("createObjectStore", args...)
 	return nil
 }
+
+// CreateIndex creates an index on an object store during the upgrade.
+// opts is optional
+func (d *DatabaseUpdate) CreateIndex(
+	storeID, name string,
+	keyPath *KeyPath,
+	opts *CreateIndexOpts,
+) (*Index, error) {
+	store, err := d.txn.GetObjectStore(storeID)
+	if err != nil {
+		return nil, err
+	}
+	return store.CreateIndex(name, keyPath, opts)
+}
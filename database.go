@@ -10,6 +10,14 @@ import (
 // Database contains object stores, which contain data.
 type Database struct {
 	val js.Value
+	// wal is the persistent write-ahead-log, if enabled.
+	wal writeAheadLog
+	// walRelease releases the Web Lock held while the wal is enabled, if any.
+	walRelease func()
+	// feed is the change feed, if enabled.
+	feed *changeFeed
+	// readCache is the in-memory read cache, if enabled.
+	readCache *readCache
 }
 
 // NewDatabase constructs a database with a js object.
@@ -32,6 +40,11 @@ func (d *Database) ContainsObjectStore(id string) bool {
 	return d.val.Get("objectStoreNames").Call("contains", id).Bool()
 }
 
+// ObjectStoreNames returns the names of the object stores sorted by name.
+func (d *Database) ObjectStoreNames() []string {
+	return stringListFromJs(d.val.Get("objectStoreNames"))
+}
+
 // TransactionMode is a transaction mode
 type TransactionMode string
 
@@ -40,28 +53,101 @@ var (
 	READONLY TransactionMode = "readonly"
 	// READWRITE is the read-write transaction mode
 	READWRITE TransactionMode = "readwrite"
+	// VERSIONCHANGE is the mode of the transaction during an upgrade.
+	// Use DatabaseUpdate.GetTransaction to access it.
+	VERSIONCHANGE TransactionMode = "versionchange"
+)
+
+// TransactionDurability is a durability hint for a transaction.
+type TransactionDurability string
+
+var (
+	// DurabilityDefault uses the browser default durability.
+	DurabilityDefault TransactionDurability = "default"
+	// DurabilityStrict waits for changes to be flushed to disk before completing.
+	DurabilityStrict TransactionDurability = "strict"
+	// DurabilityRelaxed completes once changes are written to the OS.
+	DurabilityRelaxed TransactionDurability = "relaxed"
 )
 
+// TransactionOptions are the options for starting a transaction.
+type TransactionOptions struct {
+	// Mode is the transaction mode.
+	// Defaults to READONLY.
+	Mode TransactionMode
+	// Durability is the durability hint.
+	// Defaults to DurabilityDefault.
+	// Ignored by browsers which do not support durability hints.
+	Durability TransactionDurability
+}
+
+// Validate checks the transaction options.
+func (o *TransactionOptions) Validate() error {
+	switch o.Mode {
+	case "", READONLY, READWRITE:
+	case VERSIONCHANGE:
+		return errors.Wrap(ErrInvalidTransactionMode, "versionchange transactions are only available during an upgrade")
+	default:
+		return errors.Wrap(ErrInvalidTransactionMode, string(o.Mode))
+	}
+	switch o.Durability {
+	case "", DurabilityDefault, DurabilityStrict, DurabilityRelaxed:
+	default:
+		return errors.Wrap(ErrInvalidDurability, string(o.Durability))
+	}
+	return nil
+}
+
+// GetMode returns the mode, defaulting to READONLY.
+func (o *TransactionOptions) GetMode() TransactionMode {
+	if o.Mode == "" {
+		return READONLY
+	}
+	return o.Mode
+}
+
+// ToJSValue converts the options to the js transaction options object.
+func (o *TransactionOptions) ToJSValue() js.Value {
+	val := js.Global().Get("Object").New()
+	if o.Durability != "" {
+		val.Set("durability", string(o.Durability))
+	}
+	return val
+}
+
 // Transaction gets a transaction with a object store name or names.
 // Mode defaults to READONLY.
+// Returns an error if the mode is invalid.
 func (d *Database) Transaction(scope []string, mode TransactionMode) (t *Transaction, e error) {
-	switch mode {
-	case READONLY:
-	case READWRITE:
-	default:
-		mode = READONLY
+	return d.TransactionWithOptions(scope, &TransactionOptions{Mode: mode})
+}
+
+// TransactionWithOptions gets a transaction with a object store name or names.
+// Returns an error if the options are invalid.
+func (d *Database) TransactionWithOptions(scope []string, opts *TransactionOptions) (t *Transaction, e error) {
+	if opts == nil {
+		opts = &TransactionOptions{}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
 
+	mode := opts.GetMode()
 	scopeArg := make([]interface{}, len(scope))
 	for i, x := range scope {
 		scopeArg[i] = x
 	}
-	val := d.val.Call("transaction", scopeArg, string(mode))
+	val := d.val.Call("transaction", scopeArg, string(mode), opts.ToJSValue())
 	if !val.Truthy() {
 		return nil, errors.Errorf("transaction(%v, %v): returned null", scope, mode)
 	}
 
-	return &Transaction{val: val}, nil
+	return NewTransaction(val), nil
 }
 
 // GetJsValue returns the underlying js database handle.
@@ -70,6 +156,9 @@ func (d *Database) GetJsValue() js.Value {
 }
 
 // Close closes the database.
+//
+// Disables the write-ahead-log, if enabled.
 func (d *Database) Close() {
+	d.DisableWAL()
 	d.val.Call("close")
 }
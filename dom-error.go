@@ -0,0 +1,63 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"syscall/js"
+)
+
+// DOMError is an error reported by the browser as a DOMException.
+//
+// Use errors.Is to match well-known exceptions, for example ErrQuotaExceeded.
+type DOMError struct {
+	// Name is the exception name, for example "QuotaExceededError".
+	Name string
+	// Message is the exception message.
+	Message string
+}
+
+// domErrorNames maps DOMException names to the matching error.
+var domErrorNames = map[string]error{
+	"QuotaExceededError": ErrQuotaExceeded,
+}
+
+// Error returns the error message.
+func (e *DOMError) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Message
+}
+
+// Is checks if the exception matches the target error.
+func (e *DOMError) Is(target error) bool {
+	known, ok := domErrorNames[e.Name]
+	return ok && known == target
+}
+
+// domError converts a DOMException or other js error object to an error.
+func domError(val js.Value) error {
+	msg := val.Get("message")
+	if msg.Type() != js.TypeString {
+		msg = val.Call("toString")
+	}
+	name := val.Get("name")
+	if name.Type() != js.TypeString {
+		name = js.ValueOf("")
+	}
+	return &DOMError{Name: name.String(), Message: msg.String()}
+}
+
+// recoveredError converts a recovered panic value to an error.
+//
+// Javascript exceptions are converted with domError, so that exceptions such
+// as QuotaExceededError match the well-known errors. Returns false if the
+// value is not an error.
+func recoveredError(rerr interface{}) (error, bool) {
+	if jsErr, ok := rerr.(js.Error); ok {
+		return domError(jsErr.Value), true
+	}
+	err, ok := rerr.(error)
+	return err, ok
+}
@@ -0,0 +1,154 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// atomicWriter issues writes from the success events of an atomic request chain.
+type atomicWriter struct {
+	s    *DurableObjectStore
+	stor *ObjectStore
+	reqs []js.Value
+	ops  []*durableOp
+	// changes are the issued writes.
+	changes []durableChange
+}
+
+// write issues a write op to an object store in the transaction.
+func (w *atomicWriter) write(storeID string, op *durableOp) error {
+	stor := w.stor
+	if storeID != w.s.id {
+		stor = &ObjectStore{val: w.stor.val.Get("transaction").Call("objectStore", storeID)}
+	}
+	req, err := w.s.tx.issueOp(stor, op)
+	if err != nil {
+		return err
+	}
+	w.reqs = append(w.reqs, req)
+	w.ops = append(w.ops, op)
+	w.changes = append(w.changes, durableChange{store: storeID, op: op})
+	return nil
+}
+
+// runAtomic issues a request and calls onSuccess from each of its success events.
+//
+// Writes issued with the atomicWriter from onSuccess are applied before any
+// other request in any transaction can observe the read. onSuccess returns
+// true to wait for another success event, for example after continuing a
+// cursor. Pending writes are applied first. If the transaction is inactive,
+// it is restarted and the request chain is retried.
+//
+// If an error is returned after writes were issued, abort the transaction.
+func (s *DurableObjectStore) runAtomic(
+	start func(stor *ObjectStore) js.Value,
+	onSuccess func(req js.Value, w *atomicWriter) (more bool, err error),
+) error {
+	if s.tx.mode != READWRITE {
+		return ErrInvalidTransactionMode
+	}
+	var w *atomicWriter
+	_, err := s.durableRead(func(stor *ObjectStore) (_ js.Value, e error) {
+		defer func() {
+			if err := recover(); err != nil {
+				e, _ = recoveredError(err)
+			}
+		}()
+		w = &atomicWriter{s: s, stor: stor}
+		done := make(chan error, 1)
+		var req js.Value
+		cb := js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+			if dats[0].Get("type").String() == "error" {
+				done <- domError(req.Get("error"))
+				return nil
+			}
+			more, err := callAtomic(onSuccess, req, w)
+			if err != nil || !more {
+				done <- err
+			}
+			return nil
+		})
+		defer cb.Release()
+		req = start(stor)
+		req.Call("addEventListener", "success", cb)
+		req.Call("addEventListener", "error", cb)
+		if err := <-done; err != nil {
+			return js.Undefined(), err
+		}
+		for i, wreq := range w.reqs {
+			res, err := WaitRequest(wreq)
+			w.ops[i].resolve(res, err)
+			if err != nil {
+				return js.Undefined(), err
+			}
+		}
+		return js.Undefined(), nil
+	})
+	if err != nil {
+		return err
+	}
+	if s.tx.d.feed != nil || s.tx.d.readCache != nil {
+		s.tx.changes = append(s.tx.changes, w.changes...)
+	}
+	return nil
+}
+
+// inAtomicCallback is set while an atomic callback runs.
+//
+// The callback runs within a js event handler: waiting for a request or
+// promise would deadlock, as the event loop cannot run until it returns.
+var inAtomicCallback bool
+
+// callAtomic calls onSuccess, converting a panic to an error.
+func callAtomic(
+	onSuccess func(req js.Value, w *atomicWriter) (bool, error),
+	req js.Value,
+	w *atomicWriter,
+) (more bool, e error) {
+	inAtomicCallback = true
+	defer func() {
+		inAtomicCallback = false
+		if rerr := recover(); rerr != nil {
+			var ok bool
+			e, ok = recoveredError(rerr)
+			if !ok {
+				e = errors.Errorf("atomic update paniced: %v", rerr)
+			}
+		}
+	}()
+	return onSuccess(req, w)
+}
+
+// UpdateAtomic reads and writes a record in a single request chain.
+//
+// The put is issued from the get success event, so no other transaction can
+// write the record in between, even in other tabs. update is called with the
+// current value, undefined if not found, and returns the new value. Pending
+// writes are applied first. If the transaction is inactive, it is restarted
+// and the update is retried. Returns the new value.
+//
+// IMPORTANT: update runs within the success event handler of the get request
+// and must not block. Waiting for a request, promise, lock or transaction
+// would deadlock: requests and promises awaited by this package return
+// ErrBlockingInAtomicUpdate instead.
+//
+// The store must use out-of-line keys.
+func (s *DurableObjectStore) UpdateAtomic(key interface{}, update func(cur js.Value) (js.Value, error)) (js.Value, error) {
+	keyVal := js.ValueOf(MaybeConvertValueToJs(key))
+	out := js.Undefined()
+	err := s.runAtomic(func(stor *ObjectStore) js.Value {
+		return stor.val.Call("get", keyVal)
+	}, func(req js.Value, w *atomicWriter) (bool, error) {
+		newVal, err := update(req.Get("result"))
+		if err != nil {
+			return false, err
+		}
+		out = newVal
+		return false, w.write(s.id, newDurableOp(durableOpPut, keyVal, newVal))
+	})
+	return out, err
+}
@@ -0,0 +1,107 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import "syscall/js"
+
+// DurableIndex is an index of a DurableObjectStore.
+//
+// Reads are retried if the transaction goes inactive.
+type DurableIndex struct {
+	store *DurableObjectStore
+	name  string
+}
+
+// Index returns an index of the object store by name.
+//
+// The index is resolved when it is first read.
+func (s *DurableObjectStore) Index(name string) *DurableIndex {
+	return &DurableIndex{store: s, name: name}
+}
+
+// GetName returns the index name.
+func (i *DurableIndex) GetName() string {
+	return i.name
+}
+
+// durableRead reads from the index with the "inactive transaction" logic.
+func (i *DurableIndex) durableRead(read func(idx *Index) error) error {
+	_, err := i.store.durableRead(func(stor *ObjectStore) (js.Value, error) {
+		idx, err := stor.Index(i.name)
+		if err != nil {
+			return js.Undefined(), err
+		}
+		return js.Undefined(), read(idx)
+	})
+	return err
+}
+
+// Get gets the first record value matching the query.
+func (i *DurableIndex) Get(query interface{}) (js.Value, error) {
+	out := js.Undefined()
+	err := i.durableRead(func(idx *Index) error {
+		var err error
+		out, err = idx.Get(query)
+		return err
+	})
+	return out, err
+}
+
+// GetKey gets the primary key of the first record matching the query.
+func (i *DurableIndex) GetKey(query interface{}) (js.Value, error) {
+	out := js.Undefined()
+	err := i.durableRead(func(idx *Index) error {
+		var err error
+		out, err = idx.GetKey(query)
+		return err
+	})
+	return out, err
+}
+
+// GetAll gets all record values matching an optional query with an optional count.
+// A count of zero returns all matching values.
+func (i *DurableIndex) GetAll(query interface{}, count int) ([]js.Value, error) {
+	var out []js.Value
+	err := i.durableRead(func(idx *Index) error {
+		var err error
+		out, err = idx.GetAll(query, count)
+		return err
+	})
+	return out, err
+}
+
+// GetAllKeys gets all primary keys matching an optional query with an optional count.
+// A count of zero returns all matching keys.
+func (i *DurableIndex) GetAllKeys(query interface{}, count int) ([]js.Value, error) {
+	var out []js.Value
+	err := i.durableRead(func(idx *Index) error {
+		var err error
+		out, err = idx.GetAllKeys(query, count)
+		return err
+	})
+	return out, err
+}
+
+// GetAllEntries gets the primary keys and values of all records matching an
+// optional query with an optional count.
+// A count of zero returns all matching records.
+func (i *DurableIndex) GetAllEntries(query interface{}, count int) (keys, vals []js.Value, err error) {
+	err = i.durableRead(func(idx *Index) error {
+		var rerr error
+		keys, vals, rerr = idx.getPage(query, count)
+		return rerr
+	})
+	return keys, vals, err
+}
+
+// Count counts records matching the optional query.
+func (i *DurableIndex) Count(query interface{}) (int, error) {
+	var out int
+	err := i.durableRead(func(idx *Index) error {
+		var err error
+		out, err = idx.Count(query)
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,68 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"syscall/js"
+)
+
+// ErrNotResolved is returned if a DurableResult has not been resolved yet.
+var ErrNotResolved = errors.New("durable operation has not been applied yet")
+
+// DurableResult is the result of a write to a DurableObjectStore.
+//
+// If the transaction was inactive, the write is deferred until the
+// transaction is restarted by Commit or a read. The result resolves once the
+// write has been applied.
+type DurableResult struct {
+	done chan struct{}
+	once sync.Once
+	key  js.Value
+	err  error
+}
+
+// newDurableResult constructs a new unresolved DurableResult.
+func newDurableResult() *DurableResult {
+	return &DurableResult{done: make(chan struct{})}
+}
+
+// resolve resolves the result, if not already resolved.
+func (r *DurableResult) resolve(key js.Value, err error) {
+	r.once.Do(func() {
+		r.key, r.err = key, err
+		close(r.done)
+	})
+}
+
+// Done returns a channel which is closed when the result is resolved.
+func (r *DurableResult) Done() <-chan struct{} {
+	return r.done
+}
+
+// Key returns the key of the written record.
+//
+// Returns ErrNotResolved if the write has not been applied yet.
+func (r *DurableResult) Key() (js.Value, error) {
+	select {
+	case <-r.done:
+		return r.key, r.err
+	default:
+		return js.Undefined(), ErrNotResolved
+	}
+}
+
+// WaitKey waits for the write to be applied and returns the key.
+//
+// Call Commit on the transaction to apply any deferred writes.
+func (r *DurableResult) WaitKey(ctx context.Context) (js.Value, error) {
+	select {
+	case <-ctx.Done():
+		return js.Undefined(), ctx.Err()
+	case <-r.done:
+		return r.key, r.err
+	}
+}
@@ -10,7 +10,8 @@ import (
 
 // DurableTransaction handles call panics, errors, and transactions going inactive.
 //
-// backs any writes with an in-memory write-ahead-log
+// backs any writes with an in-memory write-ahead-log, which can be persisted
+// with Database.EnableWAL.
 type DurableTransaction struct {
 	// d is the database
 	d *Database
@@ -21,48 +22,101 @@ type DurableTransaction struct {
 	scope []string
 	// mode is the txn mode
 	mode TransactionMode
+	// opts are the transaction options
+	opts TransactionOptions
 	// stores is the set of object store handles
 	stores map[string]*DurableObjectStore
+	// walApplied is the list of applied write-ahead-log entries.
+	//
+	// Removed after Commit if the log is not transactional, and on Abort, as
+	// aborting rolls back the removal in the transaction that applied them.
+	walApplied []int
+	// changes is the list of write ops to publish to the change feed and
+	// apply to the read cache after commit.
+	changes []durableChange
+	// changelog is the changelog settings, if writes are recorded.
+	changelog *changelog
 }
 
 // NewDurableTransaction starts a transaction that handles typical errors and panics.
 //
 // This is the recommended way to use this library.
-func NewDurableTransaction(d *Database, scope []string, mode TransactionMode) (*DurableTransaction, error) {
-	txn, err := d.Transaction(scope, mode)
-	if err != nil {
-		return nil, err
-	}
-	mode = txn.GetMode()
+func NewDurableTransaction(
+	d *Database,
+	scope []string,
+	mode TransactionMode,
+	dopts ...DurableTransactionOption,
+) (*DurableTransaction, error) {
+	return NewDurableTransactionWithOptions(d, scope, &TransactionOptions{Mode: mode}, dopts...)
+}
+
+// DurableTransactionOption configures a DurableTransaction.
+type DurableTransactionOption func(t *DurableTransaction)
 
+// NewDurableTransactionWithOptions starts a durable transaction with options.
+//
+// The options are re-used when the transaction is restarted.
+func NewDurableTransactionWithOptions(
+	d *Database,
+	scope []string,
+	opts *TransactionOptions,
+	dopts ...DurableTransactionOption,
+) (*DurableTransaction, error) {
+	if opts == nil {
+		opts = &TransactionOptions{}
+	}
 	dt := &DurableTransaction{
 		d:      d,
-		txn:    txn,
-		scope:  scope,
-		mode:   mode,
 		stores: make(map[string]*DurableObjectStore),
 	}
+	for _, opt := range dopts {
+		opt(dt)
+	}
+	if dt.changelog != nil {
+		if opts.GetMode() != READWRITE {
+			dt.changelog = nil
+		} else {
+			if err := d.checkChangelogObjectStore(); err != nil {
+				return nil, err
+			}
+			// writes append to the changelog in the same transaction.
+			scope = append(append([]string(nil), scope...), ChangelogObjectStoreID)
+		}
+	}
+	txn, err := d.TransactionWithOptions(scope, opts)
+	if err != nil {
+		return nil, err
+	}
+	dt.txn = txn
+	dt.scope = scope
+	dt.mode = txn.GetMode()
+	dt.opts = *opts
+	dt.opts.Mode = dt.mode
 	dt.setOnCompleteCallback()
 	return dt, nil
 }
 
-// setOnCompleteCallback sets the on-complete callback.
+// setOnCompleteCallback sets the on-complete and on-abort callbacks.
 func (t *DurableTransaction) setOnCompleteCallback() {
-	if t.txn != nil {
-		t.txn.val.Set(
-			"oncomplete",
-			js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
-				// set txn to nil to indicate transaction complete
-				t.txn = nil
-				return nil
-			}),
-		)
+	txn := t.txn
+	if txn == nil {
+		return
+	}
+	// set txn to nil to indicate transaction complete
+	clearTxn := func() {
+		if t.txn == txn {
+			t.txn = nil
+		}
 	}
+	txn.OnComplete(clearTxn)
+	txn.OnAbort(func(err error) {
+		clearTxn()
+	})
 }
 
 // restartTransaction restarts the tx
 func (t *DurableTransaction) restartTransaction() error {
-	txn, err := t.d.Transaction(t.scope, t.mode)
+	txn, err := t.d.TransactionWithOptions(t.restartScope(), &t.opts)
 	if err != nil {
 		return err
 	}
@@ -74,7 +128,7 @@ func (t *DurableTransaction) restartTransaction() error {
 		stor.store = nstor
 		ops := stor.ops
 		for i, op := range ops {
-			if err := op.apply(nstor); err != nil {
+			if err := t.applyOp(nstor, op); err != nil {
 				return err
 			}
 			stor.ops = ops[i+1:] // don't apply again if successful
@@ -88,17 +142,121 @@ func (t *DurableTransaction) restartTransaction() error {
 	return nil
 }
 
+// restartScope returns the scope to use when restarting the transaction.
+//
+// Adds the write-ahead-log object store if any pending ops were logged to it.
+func (t *DurableTransaction) restartScope() []string {
+	wal := t.d.wal
+	if wal == nil || wal.objectStoreID() == "" {
+		return t.scope
+	}
+	for _, stor := range t.stores {
+		for _, op := range stor.ops {
+			if op.walSeq != 0 {
+				scope := make([]string, len(t.scope), len(t.scope)+1)
+				copy(scope, t.scope)
+				return append(scope, wal.objectStoreID())
+			}
+		}
+	}
+	return t.scope
+}
+
+// applyOp applies an op to the store and waits for it to complete.
+//
+// If the op was logged to the write-ahead-log, removes the log entry in the
+// same transaction, or after Commit if the log is not transactional. Records
+// the change for the change feed and read cache once the op succeeds.
+func (t *DurableTransaction) applyOp(s *ObjectStore, op *durableOp) (e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	req, err := t.issueOp(s, op)
+	if err != nil {
+		op.resolve(js.Undefined(), err)
+		return err
+	}
+	wal := t.d.wal
+	if op.walSeq == 0 || wal == nil {
+		wal = nil
+	}
+	// issue both requests before waiting so they commit together.
+	var walReq js.Value
+	if wal != nil && wal.objectStoreID() != "" {
+		walReq = s.val.Get("transaction").Call("objectStore", wal.objectStoreID()).Call("delete", op.walSeq)
+	}
+	res, err := WaitRequest(req)
+	if err == nil && !walReq.IsUndefined() {
+		_, err = WaitRequest(walReq)
+	}
+	op.resolve(res, err)
+	if err != nil {
+		return err
+	}
+	if wal != nil {
+		t.walApplied = append(t.walApplied, op.walSeq)
+	}
+	if t.d.feed != nil || t.d.readCache != nil {
+		t.changes = append(t.changes, durableChange{store: s.GetName(), op: op})
+	}
+	return nil
+}
+
+// issueOp issues the request for an op, recording it in the changelog if enabled.
+func (t *DurableTransaction) issueOp(s *ObjectStore, op *durableOp) (js.Value, error) {
+	req, err := op.issue(s)
+	if err == nil && t.changelog != nil {
+		t.changelog.logRequest(req, s.GetName(), op)
+	}
+	return req, err
+}
+
+// logOp persists an op to the write-ahead-log, if enabled.
+func (t *DurableTransaction) logOp(storeID string, op *durableOp) error {
+	wal := t.d.wal
+	if wal == nil || t.mode != READWRITE {
+		return nil
+	}
+	seq, err := wal.append(storeID, op, t.changelog)
+	if err != nil {
+		return err
+	}
+	op.walSeq = seq
+	return nil
+}
+
 // GetMode returns the transaction mode.
 func (t *DurableTransaction) GetMode() TransactionMode {
 	return t.mode
 }
 
 // Abort aborts a transaction.
+//
+// Discards any pending ops and removes them and any applied ops from the
+// write-ahead-log.
 func (t *DurableTransaction) Abort() {
 	if t.txn != nil {
 		t.txn.Abort()
 		t.txn = nil
 	}
+	walSeqs := t.walApplied
+	for _, stor := range t.stores {
+		for _, op := range stor.ops {
+			if op.walSeq != 0 {
+				walSeqs = append(walSeqs, op.walSeq)
+			}
+			op.resolve(js.Undefined(), ErrTransactionAborted)
+		}
+		stor.ops = nil
+	}
+	if wal := t.d.wal; wal != nil && len(walSeqs) != 0 {
+		// ignore error here
+		_ = wal.remove(walSeqs)
+	}
+	t.walApplied = nil
+	t.changes = nil
 }
 
 // Commit commits a transaction and waits for it to complete
@@ -128,11 +286,24 @@ func (t *DurableTransaction) Commit() error {
 		txn.Commit()
 		err = txn.WaitComplete()
 	}
+	if err == nil && len(t.walApplied) != 0 {
+		if wal := t.d.wal; wal != nil && wal.objectStoreID() == "" {
+			err = wal.remove(t.walApplied)
+		}
+		t.walApplied = nil
+	}
+	if err == nil && len(t.changes) != 0 {
+		if cache := t.d.readCache; cache != nil {
+			cache.applyCommit(t.changes)
+		}
+		if feed := t.d.feed; feed != nil {
+			feed.publish(t.changes)
+		}
+	}
+	t.changes = nil
 	return err
 }
 
-// Restart restarts the transaction if inactive.
-
 // DurableObjectStore backs changes in a write-ahead log.
 type DurableObjectStore struct {
 	id string
@@ -175,37 +346,93 @@ func (s *DurableObjectStore) GetName() string {
 	return s.id
 }
 
+// durableOpKind is the kind of operation in the write-ahead-log.
+type durableOpKind string
+
+const (
+	durableOpPut    durableOpKind = "put"
+	durableOpAdd    durableOpKind = "add"
+	durableOpDelete durableOpKind = "delete"
+	durableOpClear  durableOpKind = "clear"
+)
+
 // durableOp contains an operation against the store.
 type durableOp struct {
-	// apply applies the op
-	apply func(s *ObjectStore) error
+	// kind is the kind of operation
+	kind durableOpKind
+	// key is the key or query, converted to js.
+	key interface{}
+	// value is the value, converted to js.
+	value interface{}
+	// walSeq is the sequence number in the persistent write-ahead-log.
+	// zero if not persisted.
+	walSeq int
+	// result is resolved when the op is applied.
+	result *DurableResult
 }
 
 // newDurableOp constructs a new durableOp
-func newDurableOp(apply func(s *ObjectStore) error) *durableOp {
-	return &durableOp{apply: apply}
+func newDurableOp(kind durableOpKind, key, value interface{}) *durableOp {
+	return &durableOp{kind: kind, key: key, value: value, result: newDurableResult()}
+}
+
+// issue issues the request for the op without waiting for it.
+func (o *durableOp) issue(s *ObjectStore) (req js.Value, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	switch o.kind {
+	case durableOpPut:
+		return s.val.Call("put", writeArgs(o.value, o.key)...), nil
+	case durableOpAdd:
+		return s.val.Call("add", writeArgs(o.value, o.key)...), nil
+	case durableOpDelete:
+		return s.val.Call("delete", o.key), nil
+	case durableOpClear:
+		return s.val.Call("clear"), nil
+	default:
+		return js.Undefined(), errors.New("unknown durable op: " + string(o.kind))
+	}
+}
+
+// resolve resolves the op result, unless the op needs to be retried.
+func (o *durableOp) resolve(res js.Value, err error) {
+	if o.result == nil || (err != nil && errIsInactiveTransaction(err)) {
+		return
+	}
+	o.result.resolve(res, err)
 }
 
 // pushOp attempts an operation with the "inactive transaction" logic
 func (s *DurableObjectStore) pushOp(op *durableOp) error {
 	if s.tx.txn != nil && s.store != nil {
-		err := op.apply(s.store)
+		err := s.tx.applyOp(s.store, op)
 		if err != nil && errIsInactiveTransaction(err) {
 			s.tx.txn = nil
 			s.store = nil
-			err = nil
 			// defer applying the op until Commit() or a read
-			s.ops = append(s.ops, op)
+			err = s.bufferOp(op)
 		}
 		if err != nil {
 			return err
 		}
 	} else {
-		s.ops = append(s.ops, op)
+		return s.bufferOp(op)
 	}
 	return nil
 }
 
+// bufferOp logs the op to the write-ahead-log and buffers it until Commit() or a read.
+func (s *DurableObjectStore) bufferOp(op *durableOp) error {
+	if err := s.tx.logOp(s.id, op); err != nil {
+		return err
+	}
+	s.ops = append(s.ops, op)
+	return nil
+}
+
 // getOrBuildStore gets the store or re-starts the tx if it's inactive
 func (s *DurableObjectStore) getOrBuildStore() (*ObjectStore, error) {
 	if s.tx.txn != nil && s.store != nil {
@@ -222,36 +449,48 @@ func (s *DurableObjectStore) getOrBuildStore() (*ObjectStore, error) {
 }
 
 // Put puts data into the store.
-func (s *DurableObjectStore) Put(value interface{}, key interface{}) error {
-	value = MaybeConvertValueToJs(value)
+//
+// The key can be nil for stores with a key path or key generator.
+// The result resolves to the key of the record once the op is applied.
+func (s *DurableObjectStore) Put(value interface{}, key interface{}) (*DurableResult, error) {
+	value, err := ConvertValueToJs(value)
+	if err != nil {
+		return nil, err
+	}
 	key = MaybeConvertValueToJs(key)
-	return s.pushOp(newDurableOp(func(s *ObjectStore) error {
-		return s.Put(value, key)
-	}))
+	op := newDurableOp(durableOpPut, key, value)
+	if err := s.pushOp(op); err != nil {
+		return nil, err
+	}
+	return op.result, nil
 }
 
 // Add adds data to the store.
-func (s *DurableObjectStore) Add(value interface{}, key interface{}) error {
-	value = MaybeConvertValueToJs(value)
+//
+// The key can be nil for stores with a key path or key generator.
+// The result resolves to the key of the record once the op is applied.
+func (s *DurableObjectStore) Add(value interface{}, key interface{}) (*DurableResult, error) {
+	value, err := ConvertValueToJs(value)
+	if err != nil {
+		return nil, err
+	}
 	key = MaybeConvertValueToJs(key)
-	return s.pushOp(newDurableOp(func(s *ObjectStore) error {
-		return s.Add(value, key)
-	}))
+	op := newDurableOp(durableOpAdd, key, value)
+	if err := s.pushOp(op); err != nil {
+		return nil, err
+	}
+	return op.result, nil
 }
 
 // Delete deletes data from the store.
 func (s *DurableObjectStore) Delete(query interface{}) error {
 	query = MaybeConvertValueToJs(query)
-	return s.pushOp(newDurableOp(func(s *ObjectStore) error {
-		return s.Delete(query)
-	}))
+	return s.pushOp(newDurableOp(durableOpDelete, query, nil))
 }
 
 // Clear clears all data from the store.
 func (s *DurableObjectStore) Clear() error {
-	return s.pushOp(newDurableOp(func(s *ObjectStore) error {
-		return s.Clear()
-	}))
+	return s.pushOp(newDurableOp(durableOpClear, nil, nil))
 }
 
 // durableRead retries a read several times upon "inactive transaction" errors
@@ -276,10 +515,30 @@ func (s *DurableObjectStore) durableRead(read func(stor *ObjectStore) (js.Value,
 				}
 			}
 		}
-		return result, nil
+		return result, err
 	}
 }
 
+// KeyPath returns the key path, nil if the store uses out-of-line keys.
+func (s *DurableObjectStore) KeyPath() (*KeyPath, error) {
+	var out *KeyPath
+	_, err := s.durableRead(func(stor *ObjectStore) (js.Value, error) {
+		out = stor.KeyPath()
+		return js.Undefined(), nil
+	})
+	return out, err
+}
+
+// IndexNames returns the names of the indexes sorted by name.
+func (s *DurableObjectStore) IndexNames() ([]string, error) {
+	var out []string
+	_, err := s.durableRead(func(stor *ObjectStore) (js.Value, error) {
+		out = stor.IndexNames()
+		return js.Undefined(), nil
+	})
+	return out, err
+}
+
 // Get gets data from the store
 func (s *DurableObjectStore) Get(query interface{}) (js.Value, error) {
 	return s.durableRead(func(stor *ObjectStore) (js.Value, error) {
@@ -287,6 +546,16 @@ func (s *DurableObjectStore) Get(query interface{}) (js.Value, error) {
 	})
 }
 
+// GetBlob gets a Blob or File from the store.
+// Returns nil if not found.
+func (s *DurableObjectStore) GetBlob(query interface{}) (*Blob, error) {
+	val, err := s.Get(query)
+	if err != nil || val.IsUndefined() {
+		return nil, err
+	}
+	return NewBlob(val)
+}
+
 // GetKey gets data from the store by key.
 func (s *DurableObjectStore) GetKey(query interface{}) (js.Value, error) {
 	return s.durableRead(func(stor *ObjectStore) (js.Value, error) {
@@ -295,17 +564,65 @@ func (s *DurableObjectStore) GetKey(query interface{}) (js.Value, error) {
 }
 
 // GetAll gets all values matching an optional query with an optional count.
-func (s *DurableObjectStore) GetAll(query interface{}) (js.Value, error) {
-	return s.durableRead(func(stor *ObjectStore) (js.Value, error) {
-		return stor.GetAll(query)
+// A count of zero returns all matching values.
+func (s *DurableObjectStore) GetAll(query interface{}, count int) ([]js.Value, error) {
+	var out []js.Value
+	_, err := s.durableRead(func(stor *ObjectStore) (js.Value, error) {
+		vals, err := stor.GetAll(query, count)
+		out = vals
+		return js.Undefined(), err
 	})
+	return out, err
+}
+
+// GetAllBytes gets all values matching an optional query with an optional count.
+// A count of zero returns all matching values.
+// Returns an error if any of the values is not binary.
+func (s *DurableObjectStore) GetAllBytes(query interface{}, count int) ([][]byte, error) {
+	vals, err := s.GetAll(query, count)
+	if err != nil {
+		return nil, err
+	}
+	return bytesSliceFromJs(vals)
 }
 
 // GetAllKeys gets all keys matching an optional query with an optional count.
-func (s *DurableObjectStore) GetAllKeys(query interface{}) (js.Value, error) {
-	return s.durableRead(func(stor *ObjectStore) (js.Value, error) {
-		return stor.GetAllKeys(query)
+// A count of zero returns all matching keys.
+func (s *DurableObjectStore) GetAllKeys(query interface{}, count int) ([]js.Value, error) {
+	var out []js.Value
+	_, err := s.durableRead(func(stor *ObjectStore) (js.Value, error) {
+		keys, err := stor.GetAllKeys(query, count)
+		out = keys
+		return js.Undefined(), err
 	})
+	return out, err
+}
+
+// GetAllKeysBytes gets all keys matching an optional query with an optional count.
+// A count of zero returns all matching keys.
+// Returns an error if any of the keys is not binary.
+func (s *DurableObjectStore) GetAllKeysBytes(query interface{}, count int) ([][]byte, error) {
+	keys, err := s.GetAllKeys(query, count)
+	if err != nil {
+		return nil, err
+	}
+	return bytesSliceFromJs(keys)
+}
+
+// ScanPages iterates over the records in an optional IDBKeyRange in pages.
+//
+// Each page is read with a single request, so the transaction can be
+// restarted between pages. Each page after the first starts after the last
+// key of the previous page. Use Bound() to build a key range.
+func (s *DurableObjectStore) ScanPages(krv js.Value, pageSize int, cb func(keys, vals []js.Value) error) error {
+	return scanPages(krv, pageSize, func(krv js.Value, count int) (keys, vals []js.Value, err error) {
+		_, err = s.durableRead(func(stor *ObjectStore) (js.Value, error) {
+			var rerr error
+			keys, vals, rerr = stor.getPage(krv, count)
+			return js.Undefined(), rerr
+		})
+		return keys, vals, err
+	}, cb)
 }
 
 // Count counts keys matching the optional query.
@@ -324,7 +641,7 @@ func (s *DurableObjectStore) Count(query interface{}) (int, error) {
 func (s *DurableObjectStore) OpenCursor(krv js.Value) (c *Cursor, e error) {
 	defer func() {
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e, _ = recoveredError(err)
 		}
 	}()
 
@@ -336,3 +653,21 @@ func (s *DurableObjectStore) OpenCursor(krv js.Value) (c *Cursor, e error) {
 	})
 	return out, err
 }
+
+// OpenKeyCursor opens a cursor over keys only with a optional IDBKeyRange.
+// Use Bound() to build a key range.
+func (s *DurableObjectStore) OpenKeyCursor(krv js.Value) (c *Cursor, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+
+	var out *Cursor
+	_, err := s.durableRead(func(stor *ObjectStore) (js.Value, error) {
+		c, err := stor.OpenKeyCursor(krv)
+		out = c
+		return js.Undefined(), err
+	})
+	return out, err
+}
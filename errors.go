@@ -8,6 +8,30 @@ import (
 var (
 	// ErrEmptyKey is returned if the key was empty.
 	ErrEmptyKey = errors.New("key cannot be empty")
+	// ErrInvalidTransactionMode is returned if the transaction mode was invalid.
+	ErrInvalidTransactionMode = errors.New("invalid transaction mode")
+	// ErrInvalidDurability is returned if the transaction durability was invalid.
+	ErrInvalidDurability = errors.New("invalid transaction durability")
+	// ErrBlobCorrupt is returned if a blob manifest or chunk was invalid.
+	ErrBlobCorrupt = errors.New("blob is corrupt")
+	// ErrBlobChecksum is returned if the blob contents did not match the checksum.
+	ErrBlobChecksum = errors.New("blob checksum mismatch")
+	// ErrBlobClosed is returned if the blob writer was already closed.
+	ErrBlobClosed = errors.New("blob writer is closed")
+	// ErrUnknownEncryptionKey is returned if the encryption key was not in the keyring.
+	ErrUnknownEncryptionKey = errors.New("unknown encryption key")
+	// ErrDecryptionFailed is returned if a value could not be decrypted.
+	ErrDecryptionFailed = errors.New("decryption failed")
+	// ErrQuotaExceeded is returned if a write failed because the storage quota was exceeded.
+	//
+	// Matches a DOMError with the name QuotaExceededError.
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	// ErrLeaseLost is returned if a queue job lease expired or is held by another consumer.
+	ErrLeaseLost = errors.New("job lease lost")
+	// ErrLockLost is returned if a fallback lock lease was lost while the lock was held.
+	ErrLockLost = errors.New("lock lease lost")
+	// ErrBlockingInAtomicUpdate is returned if an atomic update callback waits for a request or promise.
+	ErrBlockingInAtomicUpdate = errors.New("cannot wait for a request or promise within an atomic update")
 )
 
 // errIsInactiveTransaction checks if an error is the "inactive transaction" error
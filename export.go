@@ -0,0 +1,294 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// ExportFormat identifies the database export format.
+const ExportFormat = "go-indexeddb-export"
+
+// ExportVersion is the current version of the export format.
+const ExportVersion = 1
+
+// exportPageSize is the number of records read or written at a time.
+const exportPageSize = 500
+
+// exportEntry is a line of a database export.
+//
+// An export is a header, followed by the records, followed by the end marker.
+// Keys and values use the tagged JSON encoding, preserving binary data,
+// dates, and the distinction between null and undefined.
+type exportEntry struct {
+	// Header is set on the first entry.
+	Header *exportHeader `json:"header,omitempty"`
+	// Record is set on each record entry.
+	Record *exportRecord `json:"record,omitempty"`
+	// End is set on the last entry.
+	End *exportEnd `json:"end,omitempty"`
+}
+
+// exportHeader describes the exported database.
+type exportHeader struct {
+	// Format is the ExportFormat.
+	Format string `json:"format"`
+	// Version is the format version.
+	Version int `json:"version"`
+	// Schema is the database schema.
+	Schema *Schema `json:"schema"`
+}
+
+// exportRecord is a record in an object store.
+type exportRecord struct {
+	// Store is the object store name.
+	Store string `json:"store"`
+	// Key is the record key.
+	Key *taggedValue `json:"key"`
+	// Value is the record value.
+	Value *taggedValue `json:"value"`
+}
+
+// exportEnd marks the end of the export.
+type exportEnd struct {
+	// Records is the number of exported records.
+	Records int `json:"records"`
+}
+
+// Export writes the schema and all records of the database to w.
+//
+// The export is newline-delimited JSON: a versioned header with the schema,
+// one line per record, and an end marker with the record count. Object stores
+// are read in pages, so the export is not a point-in-time snapshot if the
+// database is written concurrently. Blob values are not supported.
+func (d *Database) Export(ctx context.Context, w io.Writer) error {
+	schema, err := d.DescribeSchema()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	err = enc.Encode(&exportEntry{Header: &exportHeader{
+		Format:  ExportFormat,
+		Version: ExportVersion,
+		Schema:  schema,
+	}})
+	if err != nil {
+		return err
+	}
+
+	var records int
+	for _, storeSchema := range schema.ObjectStores {
+		durTx, err := NewDurableTransaction(d, []string{storeSchema.Name}, READONLY)
+		if err != nil {
+			return err
+		}
+		store, err := durTx.GetObjectStore(storeSchema.Name)
+		if err != nil {
+			durTx.Abort()
+			return err
+		}
+		err = store.ScanPages(js.Undefined(), exportPageSize, func(keys, vals []js.Value) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			for i, key := range keys {
+				rec := &exportRecord{Store: storeSchema.Name}
+				var err error
+				rec.Key, err = encodeTaggedJs(key)
+				if err != nil {
+					return errors.Wrapf(err, "%s: key", storeSchema.Name)
+				}
+				rec.Value, err = encodeTaggedJs(vals[i])
+				if err != nil {
+					return errors.Wrapf(err, "%s: value", storeSchema.Name)
+				}
+				if err := enc.Encode(&exportEntry{Record: rec}); err != nil {
+					return err
+				}
+				records++
+			}
+			return nil
+		})
+		durTx.Abort()
+		if err != nil {
+			return err
+		}
+	}
+	return enc.Encode(&exportEntry{End: &exportEnd{Records: records}})
+}
+
+// importTempSuffix is appended to the database name for the temporary import.
+const importTempSuffix = "~import"
+
+// Import creates a database from an export written by Database.Export.
+//
+// The export is first imported into a temporary database, so an invalid or
+// truncated export leaves any existing database with the name untouched.
+// Then deletes the existing database and its localStorage write-ahead-log,
+// waiting for other connections to close, and copies the object stores,
+// indexes and records from the temporary database. The database version is
+// restored from the export. If the copy fails, the partially imported
+// database is left in place.
+func (i *IndexedDB) Import(ctx context.Context, name string, r io.Reader) (*Database, error) {
+	tmpName := name + importTempSuffix
+	tmp, err := i.importDatabase(ctx, tmpName, r)
+	if err != nil {
+		_ = i.DeleteDatabase(ctx, tmpName)
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tmp.Export(ctx, pw))
+	}()
+	db, err := i.importDatabase(ctx, name, pr)
+	// unblocks the export if the import stopped early.
+	_ = pr.Close()
+	tmp.Close()
+	_ = i.DeleteDatabase(ctx, tmpName)
+	return db, err
+}
+
+// importDatabase deletes and recreates a database from an export.
+func (i *IndexedDB) importDatabase(ctx context.Context, name string, r io.Reader) (*Database, error) {
+	dec := json.NewDecoder(r)
+	first := &exportEntry{}
+	if err := dec.Decode(first); err != nil {
+		return nil, errors.Wrap(err, "read export header")
+	}
+	hdr := first.Header
+	if hdr == nil || hdr.Format != ExportFormat || hdr.Schema == nil {
+		return nil, errors.New("not a database export")
+	}
+	if hdr.Version != ExportVersion {
+		return nil, errors.Errorf("unsupported export version: %d", hdr.Version)
+	}
+
+	if err := i.DeleteDatabase(ctx, name); err != nil {
+		return nil, err
+	}
+	// entries left for the deleted database must not be replayed.
+	if err := clearLocalStorageWAL(name); err != nil {
+		return nil, err
+	}
+	version := hdr.Schema.Version
+	if version < 1 {
+		version = 1
+	}
+	storeSchemas := make(map[string]*ObjectStoreSchema, len(hdr.Schema.ObjectStores))
+	db, err := i.Open(ctx, name, version, func(d *DatabaseUpdate, oldVersion, newVersion int) error {
+		for _, storeSchema := range hdr.Schema.ObjectStores {
+			storeSchemas[storeSchema.Name] = storeSchema
+			opts := NewCreateObjectStoreOptsWithKeyPath(storeSchema.KeyPath, storeSchema.AutoIncrement)
+			if err := d.CreateObjectStore(storeSchema.Name, opts); err != nil {
+				return err
+			}
+			for _, idx := range storeSchema.Indexes {
+				_, err := d.CreateIndex(storeSchema.Name, idx.Name, idx.KeyPath, &CreateIndexOpts{
+					Unique:     idx.Unique,
+					MultiEntry: idx.MultiEntry,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var batch []*exportRecord
+	var records int
+	for {
+		ent := &exportEntry{}
+		if err := dec.Decode(ent); err != nil {
+			db.Close()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, errors.Wrap(err, "read export")
+		}
+		if ent.Record != nil {
+			if _, ok := storeSchemas[ent.Record.Store]; !ok {
+				db.Close()
+				return nil, errors.Errorf("record for unknown object store: %s", ent.Record.Store)
+			}
+			batch = append(batch, ent.Record)
+			records++
+		}
+		if len(batch) >= exportPageSize || (ent.End != nil && len(batch) != 0) {
+			if err := importRecords(ctx, db, storeSchemas, batch); err != nil {
+				db.Close()
+				return nil, err
+			}
+			batch = batch[:0]
+		}
+		if ent.End != nil {
+			if ent.End.Records != records {
+				db.Close()
+				return nil, errors.Errorf("export has %d records but expected %d", records, ent.End.Records)
+			}
+			return db, nil
+		}
+	}
+}
+
+// importRecords writes a batch of records in a single transaction.
+//
+// All requests are issued before waiting so the transaction stays active.
+func importRecords(
+	ctx context.Context,
+	db *Database,
+	storeSchemas map[string]*ObjectStoreSchema,
+	batch []*exportRecord,
+) (e error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var scope []string
+	seen := make(map[string]struct{})
+	for _, rec := range batch {
+		if _, ok := seen[rec.Store]; !ok {
+			seen[rec.Store] = struct{}{}
+			scope = append(scope, rec.Store)
+		}
+	}
+	txn, err := db.Transaction(scope, READWRITE)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+			txn.Abort()
+		}
+	}()
+	for _, rec := range batch {
+		key, err := decodeTaggedJs(rec.Key)
+		if err != nil {
+			txn.Abort()
+			return errors.Wrapf(err, "%s: key", rec.Store)
+		}
+		val, err := decodeTaggedJs(rec.Value)
+		if err != nil {
+			txn.Abort()
+			return errors.Wrapf(err, "%s: value", rec.Store)
+		}
+		store := txn.val.Call("objectStore", rec.Store)
+		if storeSchemas[rec.Store].KeyPath != nil {
+			// the key is part of the value.
+			store.Call("put", val)
+		} else {
+			store.Call("put", val, key)
+		}
+	}
+	return txn.WaitComplete()
+}
@@ -0,0 +1,209 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// Index is an index of an object store attached to a transaction.
+type Index struct {
+	val js.Value
+}
+
+// CreateIndexOpts are the options for creating an index.
+type CreateIndexOpts struct {
+	// Unique disallows duplicate values for the index key.
+	Unique bool
+	// MultiEntry adds an index entry for each element if the key is an array.
+	MultiEntry bool
+}
+
+// ToJSValue converts the object to a js value.
+func (o *CreateIndexOpts) ToJSValue() js.Value {
+	val := js.Global().Get("Object").New()
+	val.Set("unique", o.Unique)
+	val.Set("multiEntry", o.MultiEntry)
+	return val
+}
+
+// CreateIndex creates an index on the object store.
+// Can only be called during an upgrade.
+// opts is optional
+func (s *ObjectStore) CreateIndex(name string, keyPath *KeyPath, opts *CreateIndexOpts) (i *Index, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+
+	if keyPath == nil {
+		return nil, errors.New("index key path cannot be empty")
+	}
+	args := []interface{}{name, keyPath.ToJSValue()}
+	if opts != nil {
+		args = append(args, opts.ToJSValue())
+	}
+	return &Index{val: s.val.Call("createIndex", args...)}, nil
+}
+
+// DeleteIndex deletes an index from the object store.
+// Can only be called during an upgrade.
+func (s *ObjectStore) DeleteIndex(name string) (e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+
+	s.val.Call("deleteIndex", name)
+	return nil
+}
+
+// Index returns an index of the object store by name.
+func (s *ObjectStore) Index(name string) (i *Index, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+
+	val := s.val.Call("index", name)
+	if !val.Truthy() {
+		return nil, errors.Errorf("Index(%s) returned nil", name)
+	}
+	return &Index{val: val}, nil
+}
+
+// GetName returns the index name.
+func (i *Index) GetName() string {
+	return i.val.Get("name").String()
+}
+
+// KeyPath returns the index key path.
+func (i *Index) KeyPath() *KeyPath {
+	return keyPathFromJs(i.val.Get("keyPath"))
+}
+
+// Unique checks if the index disallows duplicate keys.
+func (i *Index) Unique() bool {
+	return i.val.Get("unique").Bool()
+}
+
+// MultiEntry checks if the index adds an entry for each element of array keys.
+func (i *Index) MultiEntry() bool {
+	return i.val.Get("multiEntry").Bool()
+}
+
+// GetJsValue returns the underlying js index handle.
+func (i *Index) GetJsValue() js.Value {
+	return i.val
+}
+
+// Get gets the first record value matching the query.
+func (i *Index) Get(query interface{}) (_ js.Value, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	query = MaybeConvertValueToJs(query)
+	return WaitRequest(i.val.Call("get", query))
+}
+
+// GetKey gets the primary key of the first record matching the query.
+func (i *Index) GetKey(query interface{}) (_ js.Value, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	query = MaybeConvertValueToJs(query)
+	return WaitRequest(i.val.Call("getKey", query))
+}
+
+// GetAll gets all record values matching an optional query with an optional count.
+// A count of zero returns all matching values.
+func (i *Index) GetAll(query interface{}, count int) (_ []js.Value, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	query = MaybeConvertValueToJs(query)
+	vals, err := WaitRequest(i.val.Call("getAll", countArgs(query, count)...))
+	if err != nil {
+		return nil, err
+	}
+	return jsArrayToSlice(vals), nil
+}
+
+// GetAllKeys gets all primary keys matching an optional query with an optional count.
+// A count of zero returns all matching keys.
+func (i *Index) GetAllKeys(query interface{}, count int) (_ []js.Value, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	query = MaybeConvertValueToJs(query)
+	keys, err := WaitRequest(i.val.Call("getAllKeys", countArgs(query, count)...))
+	if err != nil {
+		return nil, err
+	}
+	return jsArrayToSlice(keys), nil
+}
+
+// getPage gets the primary keys and values matching a query with a count.
+//
+// Issues both requests before waiting so they see the same snapshot.
+func (i *Index) getPage(query interface{}, count int) (keys, vals []js.Value, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	query = MaybeConvertValueToJs(query)
+	keysReq := i.val.Call("getAllKeys", countArgs(query, count)...)
+	valsReq := i.val.Call("getAll", countArgs(query, count)...)
+	keysVal, err := WaitRequest(keysReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	valsVal, err := WaitRequest(valsReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	return jsArrayToSlice(keysVal), jsArrayToSlice(valsVal), nil
+}
+
+// Count counts records matching the optional query.
+func (i *Index) Count(query interface{}) (_ int, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	query = MaybeConvertValueToJs(query)
+	v, err := WaitRequest(i.val.Call("count", query))
+	if err != nil {
+		return 0, err
+	}
+	return v.Int(), nil
+}
+
+// OpenCursor opens a cursor with a optional IDBKeyRange.
+// Use Bound() to build a key range.
+func (i *Index) OpenCursor(krv js.Value) (c *Cursor, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+
+	req := i.val.Call("openCursor", krv)
+	return NewCursor(req), nil
+}
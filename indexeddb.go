@@ -5,8 +5,6 @@ package indexeddb
 
 import (
 	"context"
-	"errors"
-
 	"syscall/js"
 )
 
@@ -25,6 +23,8 @@ func GlobalIndexedDB() *IndexedDB {
 }
 
 // Open opens an indexeddb database with a version and upgrader.
+//
+// Replays any operations left in the persistent write-ahead-log.
 func (i *IndexedDB) Open(
 	ctx context.Context,
 	name string,
@@ -46,8 +46,13 @@ func (i *IndexedDB) Open(
 			// event is an IDBVersionChangeEvent
 			oldVersion := event.Get("oldVersion").Int()
 			newVersion := event.Get("newVersion").Int()
-			db = &Database{val: event.Get("target").Get("result")}
-			if err := upgrader(&DatabaseUpdate{Database: db}, oldVersion, newVersion); err != nil {
+			target := event.Get("target")
+			db = &Database{val: target.Get("result")}
+			upd := &DatabaseUpdate{
+				Database: db,
+				txn:      NewTransaction(target.Get("transaction")),
+			}
+			if err := upgrader(upd, oldVersion, newVersion); err != nil {
 				putErr(err)
 			}
 			return nil
@@ -56,12 +61,7 @@ func (i *IndexedDB) Open(
 	odbReq.Set("onerror", js.FuncOf(
 		func(th js.Value, dats []js.Value) interface{} {
 			o := dats[0]
-			go putErr(errors.New(o.
-				Get("target").
-				Get("error").
-				Get("message").
-				String(),
-			))
+			go putErr(domError(o.Get("target").Get("error")))
 			return nil
 		},
 	))
@@ -87,6 +87,34 @@ func (i *IndexedDB) Open(
 	return db, nil
 }
 
+// DeleteDatabase deletes a database by name.
+//
+// Waits until other connections to the database are closed.
+// Does nothing if the database does not exist.
+func (i *IndexedDB) DeleteDatabase(ctx context.Context, name string) error {
+	errCh := make(chan error, 1)
+	// released by the callback: the request may outlive ctx.
+	var cb js.Func
+	cb = js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+		cb.Release()
+		var err error
+		if dats[0].Get("type").String() == "error" {
+			err = domError(dats[0].Get("target").Get("error"))
+		}
+		errCh <- err
+		return nil
+	})
+	req := i.val.Call("deleteDatabase", name)
+	req.Set("onsuccess", cb)
+	req.Set("onerror", cb)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
 // GetJsValue returns the underlying js database handle.
 func (i *IndexedDB) GetJsValue() js.Value {
 	return i.val
@@ -5,8 +5,18 @@ package indexeddb
 
 import (
 	"context"
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"syscall/js"
 	"testing"
+	"time"
+
+	"github.com/paralin/go-indexeddb/tuple"
 )
 
 func TestIndexedDB(t *testing.T) {
@@ -82,3 +92,2981 @@ func TestIndexedDB(t *testing.T) {
 		t.Fatalf("Error scanning prefix: %v", err)
 	}
 }
+
+// openTestDB opens a test database, creating the schema with upgrade.
+func openTestDB(tb testing.TB, name string, upgrade func(d *DatabaseUpdate) error) *Database {
+	tb.Helper()
+	db, err := GlobalIndexedDB().Open(
+		context.Background(),
+		name,
+		1,
+		func(d *DatabaseUpdate, oldVersion, newVersion int) error {
+			return upgrade(d)
+		},
+	)
+	if err != nil {
+		tb.Fatalf("Error opening database: %v", err)
+	}
+	return db
+}
+
+// openTestKvtx starts a durable transaction on an object store and wraps it in a Kvtx.
+func openTestKvtx(tb testing.TB, db *Database, id string, mode TransactionMode, opts ...KvtxOption) *Kvtx {
+	tb.Helper()
+	durTx, err := NewDurableTransaction(db, []string{id}, mode)
+	if err != nil {
+		tb.Fatalf("Error getting durable transaction: %v", err)
+	}
+	kvtx, err := NewKvtxTx(durTx, id, opts...)
+	if err != nil {
+		tb.Fatalf("Error getting object store: %v", err)
+	}
+	return kvtx
+}
+
+func TestDurableReadError(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-read-error", func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+
+	durTx, err := NewDurableTransaction(db, []string{id}, READONLY)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	store, err := durTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+
+	// a boolean is not a valid key.
+	if _, err := store.Get(true); err == nil {
+		t.Fatal("Expected error getting an invalid key")
+	}
+	if _, err := store.Count(true); err == nil {
+		t.Fatal("Expected error counting an invalid key")
+	}
+}
+
+func TestDurableWriteError(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-write-error", func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+	if err := db.EnableChangeFeed(); err != nil {
+		t.Fatalf("Error enabling change feed: %v", err)
+	}
+	defer db.DisableChangeFeed()
+
+	durTx, err := NewDurableTransaction(db, []string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	store, err := durTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+
+	// a boolean is not a valid key: the write must not be recorded.
+	if _, err := store.Put(js.ValueOf("value"), true); err == nil {
+		t.Fatal("Expected error putting an invalid key")
+	}
+	if len(durTx.changes) != 0 {
+		t.Fatalf("Expected failed write not to be recorded: %d changes", len(durTx.changes))
+	}
+	if _, err := store.Put(js.ValueOf("value"), "key"); err != nil {
+		t.Fatalf("Error putting value: %v", err)
+	}
+	if len(durTx.changes) != 1 {
+		t.Fatalf("Expected write to be recorded: %d changes", len(durTx.changes))
+	}
+	if err := durTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+}
+
+func TestKvtxScanPrefixBound(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-scan-prefix", func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+
+	objStore := openTestKvtx(t, db, id, READWRITE)
+	for _, key := range []string{"a", "a\xff", "a\xff\x01", "b", "\xff", "\xff\xff\x01"} {
+		if err := objStore.Set([]byte(key), []byte("test")); err != nil {
+			t.Fatalf("Error setting key/value: %v", err)
+		}
+	}
+
+	// keys where the prefix is followed by 0xff must be included.
+	for prefix, expected := range map[string]int{
+		"a":        3,
+		"a\xff":    2,
+		"\xff":     2,
+		"\xff\xff": 1,
+		"":         6,
+	} {
+		var n int
+		err := objStore.ScanPrefixKeys([]byte(prefix), func(key []byte) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error scanning prefix: %v", err)
+		}
+		if n != expected {
+			t.Fatalf("Scanned wrong number of keys with prefix %q. Expected %d, got %d", prefix, expected, n)
+		}
+	}
+	objStore.Discard()
+}
+
+func TestDurableTransactionWAL(t *testing.T) {
+	id := "testObjectStore"
+	upgrader := func(d *DatabaseUpdate) error {
+		if !d.ContainsObjectStore(id) {
+			if err := d.CreateObjectStore(id, nil); err != nil {
+				return err
+			}
+		}
+		return d.CreateWALObjectStore()
+	}
+
+	for _, useLocalStorage := range []bool{false, true} {
+		dbName := "test-db-wal"
+		if useLocalStorage {
+			dbName += "-ls"
+		}
+		db := openTestDB(t, dbName, upgrader)
+		if err := db.EnableWAL(); err != nil {
+			t.Fatalf("Error enabling write-ahead-log: %v", err)
+		}
+		if useLocalStorage {
+			db.wal = &localStorageWAL{
+				ls:     js.Global().Get("localStorage"),
+				prefix: walLocalStoragePrefix + dbName + "/",
+			}
+		}
+
+		durTx, err := NewDurableTransaction(db, []string{id}, READWRITE)
+		if err != nil {
+			t.Fatalf("Error getting durable transaction: %v", err)
+		}
+		store, err := durTx.GetObjectStore(id)
+		if err != nil {
+			t.Fatalf("Error getting object store: %v", err)
+		}
+		// simulate the transaction going inactive: the op is buffered.
+		durTx.txn.Abort()
+		durTx.txn = nil
+		if _, err := store.Put([]byte("wal-value"), []byte("wal-key")); err != nil {
+			t.Fatalf("Error putting value: %v", err)
+		}
+		if len(store.ops) != 1 || store.ops[0].walSeq == 0 {
+			t.Fatal("expected op to be buffered in the write-ahead-log")
+		}
+
+		// simulate the page closing without calling Commit.
+		db.Close()
+
+		db = openTestDB(t, dbName, upgrader)
+		if err := db.EnableWAL(); err != nil {
+			t.Fatalf("Error enabling write-ahead-log: %v", err)
+		}
+		kvtx := openTestKvtx(t, db, id, READONLY)
+		dat, found, err := kvtx.Get([]byte("wal-key"))
+		if err != nil {
+			t.Fatalf("Error getting value: %v", err)
+		}
+		if !found || string(dat) != "wal-value" {
+			t.Fatalf("expected write-ahead-log to be replayed: found=%v data=%s", found, dat)
+		}
+
+		var wal writeAheadLog = &idbWAL{db: db}
+		if useLocalStorage {
+			wal = &localStorageWAL{
+				ls:     js.Global().Get("localStorage"),
+				prefix: walLocalStoragePrefix + dbName + "/",
+			}
+		}
+		entries, err := wal.load()
+		if err != nil {
+			t.Fatalf("Error loading write-ahead-log: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("expected write-ahead-log to be empty after replay: %d entries", len(entries))
+		}
+		db.Close()
+	}
+}
+
+func TestDurableTransactionWALAbort(t *testing.T) {
+	id := "testObjectStore"
+	upgrader := func(d *DatabaseUpdate) error {
+		if !d.ContainsObjectStore(id) {
+			if err := d.CreateObjectStore(id, nil); err != nil {
+				return err
+			}
+		}
+		return d.CreateWALObjectStore()
+	}
+
+	for _, useLocalStorage := range []bool{false, true} {
+		dbName := "test-db-wal-abort"
+		var wal writeAheadLog
+		if useLocalStorage {
+			dbName += "-ls"
+			wal = &localStorageWAL{
+				ls:     js.Global().Get("localStorage"),
+				prefix: walLocalStoragePrefix + dbName + "/",
+			}
+		}
+		db := openTestDB(t, dbName, upgrader)
+		if err := db.EnableWAL(); err != nil {
+			t.Fatalf("Error enabling write-ahead-log: %v", err)
+		}
+		if wal != nil {
+			db.wal = wal
+		}
+
+		durTx, err := NewDurableTransaction(db, []string{id}, READWRITE)
+		if err != nil {
+			t.Fatalf("Error getting durable transaction: %v", err)
+		}
+		store, err := durTx.GetObjectStore(id)
+		if err != nil {
+			t.Fatalf("Error getting object store: %v", err)
+		}
+		// buffer the op, then apply it by restarting the transaction.
+		durTx.txn.Abort()
+		durTx.txn = nil
+		if _, err := store.Put([]byte("wal-value"), []byte("wal-key")); err != nil {
+			t.Fatalf("Error putting value: %v", err)
+		}
+		if _, err := store.Count(nil); err != nil {
+			t.Fatalf("Error counting values: %v", err)
+		}
+		if len(store.ops) != 0 || len(durTx.walApplied) != 1 {
+			t.Fatal("Expected buffered op to be applied")
+		}
+		durTx.Abort()
+		db.Close()
+
+		db = openTestDB(t, dbName, upgrader)
+		if err := db.EnableWAL(); err != nil {
+			t.Fatalf("Error enabling write-ahead-log: %v", err)
+		}
+		kvtx := openTestKvtx(t, db, id, READONLY)
+		if _, found, err := kvtx.Get([]byte("wal-key")); err != nil || found {
+			t.Fatalf("Expected aborted write not to be replayed: found=%v err=%v", found, err)
+		}
+		if wal == nil {
+			wal = &idbWAL{db: db}
+		}
+		entries, err := wal.load()
+		if err != nil {
+			t.Fatalf("Error loading write-ahead-log: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("Expected write-ahead-log to be empty after abort: %d entries", len(entries))
+		}
+		db.Close()
+	}
+}
+
+func TestWALReplayErrors(t *testing.T) {
+	kvID, uniqueID := "testObjectStore", "uniqueObjectStore"
+	upgrader := func(d *DatabaseUpdate) error {
+		if !d.ContainsObjectStore(kvID) {
+			if err := d.CreateObjectStore(kvID, nil); err != nil {
+				return err
+			}
+		}
+		if !d.ContainsObjectStore(uniqueID) {
+			if err := d.CreateObjectStore(uniqueID, NewCreateObjectStoreOpts("id", false)); err != nil {
+				return err
+			}
+			opts := &CreateIndexOpts{Unique: true}
+			if _, err := d.CreateIndex(uniqueID, "name", NewKeyPath("name"), opts); err != nil {
+				return err
+			}
+		}
+		return d.CreateWALObjectStore()
+	}
+	record := func(id int, name string) js.Value {
+		rec := js.Global().Get("Object").New()
+		rec.Set("id", id)
+		rec.Set("name", name)
+		return rec
+	}
+
+	for _, useLocalStorage := range []bool{false, true} {
+		dbName := "test-db-wal-replay-errors"
+		if useLocalStorage {
+			dbName += "-ls"
+		}
+		db := openTestDB(t, dbName, upgrader)
+		var wal writeAheadLog = &idbWAL{db: db}
+		if useLocalStorage {
+			wal = &localStorageWAL{
+				ls:     js.Global().Get("localStorage"),
+				prefix: walLocalStoragePrefix + dbName + "/",
+			}
+		}
+		for _, ent := range []struct {
+			store string
+			op    *durableOp
+		}{
+			{uniqueID, newDurableOp(durableOpPut, nil, record(1, "alice"))},
+			// fails with a ConstraintError on the unique index.
+			{uniqueID, newDurableOp(durableOpPut, nil, record(2, "alice"))},
+			// fails with a DataError: the store has no key generator.
+			{kvID, newDurableOp(durableOpPut, nil, js.ValueOf("no-key"))},
+			// the object store does not exist.
+			{"deletedObjectStore", newDurableOp(durableOpPut, "key", js.ValueOf("value"))},
+			{kvID, newDurableOp(durableOpPut, "key", js.ValueOf("value"))},
+		} {
+			if _, err := wal.append(ent.store, ent.op, nil); err != nil {
+				t.Fatalf("Error appending to write-ahead-log: %v", err)
+			}
+		}
+		db.Close()
+
+		db = openTestDB(t, dbName, upgrader)
+		if err := db.EnableWAL(); err != nil {
+			t.Fatalf("Error enabling write-ahead-log: %v", err)
+		}
+		durTx, err := NewDurableTransaction(db, []string{kvID, uniqueID}, READONLY)
+		if err != nil {
+			t.Fatalf("Error getting durable transaction: %v", err)
+		}
+		kvStore, err := durTx.GetObjectStore(kvID)
+		if err != nil {
+			t.Fatalf("Error getting object store: %v", err)
+		}
+		val, err := kvStore.Get("key")
+		if err != nil {
+			t.Fatalf("Error getting value: %v", err)
+		}
+		if val.Type() != js.TypeString || val.String() != "value" {
+			t.Fatalf("Expected entries after the failed entries to be replayed, got %s", val.Type().String())
+		}
+		uniqueStore, err := durTx.GetObjectStore(uniqueID)
+		if err != nil {
+			t.Fatalf("Error getting object store: %v", err)
+		}
+		if n, err := uniqueStore.Count(nil); err != nil || n != 1 {
+			t.Fatalf("Expected 1 record, got %d: %v", n, err)
+		}
+		if !useLocalStorage {
+			wal = &idbWAL{db: db}
+		}
+		entries, err := wal.load()
+		if err != nil {
+			t.Fatalf("Error loading write-ahead-log: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("Expected failed entries to be dropped: %d entries", len(entries))
+		}
+		db.Close()
+	}
+}
+
+func TestWALReplayLiveHandle(t *testing.T) {
+	id := "testObjectStore"
+	upgrader := func(d *DatabaseUpdate) error {
+		if !d.ContainsObjectStore(id) {
+			if err := d.CreateObjectStore(id, nil); err != nil {
+				return err
+			}
+		}
+		return d.CreateWALObjectStore()
+	}
+
+	dbName := "test-db-wal-live"
+	db1 := openTestDB(t, dbName, upgrader)
+	defer db1.Close()
+	if err := db1.EnableWAL(); err != nil {
+		t.Fatalf("Error enabling write-ahead-log: %v", err)
+	}
+	durTx, err := NewDurableTransaction(db1, []string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	store, err := durTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	durTx.txn.Abort()
+	durTx.txn = nil
+	if _, err := store.Add([]byte("live-value"), []byte("live-key")); err != nil {
+		t.Fatalf("Error adding value: %v", err)
+	}
+
+	// a second handle must not replay the entries of the open handle.
+	db2 := openTestDB(t, dbName, upgrader)
+	defer db2.Close()
+	if err := db2.EnableWAL(); err != nil {
+		t.Fatalf("Error enabling write-ahead-log: %v", err)
+	}
+	entries, err := (&idbWAL{db: db2}).load()
+	if err != nil {
+		t.Fatalf("Error loading write-ahead-log: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected the live entry to be kept: %d entries", len(entries))
+	}
+
+	if err := durTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+	kvtx := openTestKvtx(t, db2, id, READONLY)
+	dat, found, err := kvtx.Get([]byte("live-key"))
+	if err != nil || !found || string(dat) != "live-value" {
+		t.Fatalf("Expected the committed value: found=%v data=%s err=%v", found, dat, err)
+	}
+}
+
+func TestWALQuotaExceeded(t *testing.T) {
+	dbName := "test-db-wal-quota"
+	wal := &localStorageWAL{
+		ls:     js.Global().Get("localStorage"),
+		prefix: walLocalStoragePrefix + dbName + "/",
+	}
+	defer clearLocalStorageWAL(dbName)
+
+	// larger than the localStorage quota.
+	big := js.ValueOf(strings.Repeat("x", 6<<20))
+	_, err := wal.append("testObjectStore", newDurableOp(durableOpPut, "key", big), nil)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected ErrQuotaExceeded, got %v", err)
+	}
+}
+
+func TestTransactionEvents(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-events", func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+
+	// multiple listeners observe completion
+	txn, err := db.Transaction([]string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting transaction: %v", err)
+	}
+	var completed int
+	txn.OnComplete(func() { completed++ })
+	txn.OnComplete(func() { completed++ })
+	store, err := txn.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	if _, err := store.Put([]byte("value"), []byte("key")); err != nil {
+		t.Fatalf("Error putting value: %v", err)
+	}
+	txn.Commit()
+	if err := txn.WaitComplete(); err != nil {
+		t.Fatalf("Error waiting for transaction: %v", err)
+	}
+	if completed != 2 {
+		t.Fatalf("expected 2 complete callbacks, got %d", completed)
+	}
+
+	// aborting does not leave WaitComplete hanging
+	txn, err = db.Transaction([]string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting transaction: %v", err)
+	}
+	var abortErr error
+	txn.OnAbort(func(err error) { abortErr = err })
+	txn.Abort()
+	<-txn.Done()
+	if err := txn.WaitComplete(); err != ErrTransactionAborted {
+		t.Fatalf("expected ErrTransactionAborted, got %v", err)
+	}
+	if abortErr != ErrTransactionAborted {
+		t.Fatalf("expected abort callback with ErrTransactionAborted, got %v", abortErr)
+	}
+}
+
+func TestTransactionOptions(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-options", func(d *DatabaseUpdate) error {
+		if mode := d.GetTransaction().GetMode(); mode != VERSIONCHANGE {
+			return errors.New("expected versionchange transaction during upgrade: " + string(mode))
+		}
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+
+	for _, mode := range []TransactionMode{"invalid", VERSIONCHANGE} {
+		if _, err := db.Transaction([]string{id}, mode); !errors.Is(err, ErrInvalidTransactionMode) {
+			t.Fatalf("expected ErrInvalidTransactionMode for mode %q, got %v", mode, err)
+		}
+	}
+
+	txn, err := db.TransactionWithOptions([]string{id}, &TransactionOptions{
+		Mode:       READWRITE,
+		Durability: DurabilityRelaxed,
+	})
+	if err != nil {
+		t.Fatalf("Error getting transaction: %v", err)
+	}
+	if txn.GetMode() != READWRITE {
+		t.Fatalf("expected readwrite mode, got %s", txn.GetMode())
+	}
+	if dur := txn.GetDurability(); dur != DurabilityRelaxed {
+		t.Fatalf("expected relaxed durability, got %s", dur)
+	}
+	txn.Commit()
+	if err := txn.WaitComplete(); err != nil {
+		t.Fatalf("Error waiting for transaction: %v", err)
+	}
+}
+
+func TestGeneratedKeys(t *testing.T) {
+	ctx := context.Background()
+	autoID, inlineID := "autoIncrementStore", "inlineKeyStore"
+	db := openTestDB(t, "test-db-keys", func(d *DatabaseUpdate) error {
+		if err := d.CreateObjectStore(autoID, NewCreateObjectStoreOpts("", true)); err != nil {
+			return err
+		}
+		return d.CreateObjectStore(inlineID, NewCreateObjectStoreOpts("id", false))
+	})
+	defer db.Close()
+
+	txn, err := db.Transaction([]string{autoID, inlineID}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting transaction: %v", err)
+	}
+	autoStore, err := txn.GetObjectStore(autoID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	key, err := autoStore.Add([]byte("first"), nil)
+	if err != nil {
+		t.Fatalf("Error adding value: %v", err)
+	}
+	if key.Int() != 1 {
+		t.Fatalf("expected generated key 1, got %v", key)
+	}
+	inlineStore, err := txn.GetObjectStore(inlineID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	key, err = inlineStore.Put(map[string]interface{}{"id": "inline-key"}, nil)
+	if err != nil {
+		t.Fatalf("Error putting value: %v", err)
+	}
+	if key.String() != "inline-key" {
+		t.Fatalf("expected in-line key, got %v", key)
+	}
+	txn.Commit()
+	if err := txn.WaitComplete(); err != nil {
+		t.Fatalf("Error waiting for transaction: %v", err)
+	}
+
+	// durable writes deferred until Commit resolve after replay
+	durTx, err := NewDurableTransaction(db, []string{autoID}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	durStore, err := durTx.GetObjectStore(autoID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	durTx.txn.Abort()
+	durTx.txn = nil
+	res, err := durStore.Add([]byte("second"), nil)
+	if err != nil {
+		t.Fatalf("Error adding value: %v", err)
+	}
+	if _, err := res.Key(); err != ErrNotResolved {
+		t.Fatalf("expected ErrNotResolved before commit, got %v", err)
+	}
+	if err := durTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+	key, err = res.WaitKey(ctx)
+	if err != nil {
+		t.Fatalf("Error waiting for key: %v", err)
+	}
+	if key.Int() != 2 {
+		t.Fatalf("expected generated key 2, got %v", key)
+	}
+}
+
+func TestDescribeSchema(t *testing.T) {
+	db := openTestDB(t, "test-db-schema", func(d *DatabaseUpdate) error {
+		if err := d.CreateObjectStore("people", NewCreateObjectStoreOpts("id", true)); err != nil {
+			return err
+		}
+		if err := d.CreateObjectStore("blobs", nil); err != nil {
+			return err
+		}
+		people, err := d.GetTransaction().GetObjectStore("people")
+		if err != nil {
+			return err
+		}
+		_, err = people.CreateIndex("byName", NewCompoundKeyPath("last", "first"), &CreateIndexOpts{Unique: true})
+		return err
+	})
+	defer db.Close()
+
+	if names := db.ObjectStoreNames(); len(names) != 2 || names[0] != "blobs" || names[1] != "people" {
+		t.Fatalf("unexpected object store names: %v", names)
+	}
+
+	schema, err := db.DescribeSchema()
+	if err != nil {
+		t.Fatalf("Error describing schema: %v", err)
+	}
+	dat, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("Error marshaling value: %v", err)
+	}
+	expected := `{"name":"test-db-schema","version":1,"objectStores":[` +
+		`{"name":"blobs"},` +
+		`{"name":"people","keyPath":"id","autoIncrement":true,` +
+		`"indexes":[{"name":"byName","keyPath":["last","first"],"unique":true}]}]}`
+	if string(dat) != expected {
+		t.Fatalf("unexpected schema:\n%s\nexpected:\n%s", dat, expected)
+	}
+
+	decoded := &Schema{}
+	if err := json.Unmarshal(dat, decoded); err != nil {
+		t.Fatalf("Error unmarshaling value: %v", err)
+	}
+	if kp := decoded.ObjectStores[1].Indexes[0].KeyPath; !kp.IsCompound() || len(kp.GetPaths()) != 2 {
+		t.Fatalf("expected compound key path after decoding, got %v", kp)
+	}
+}
+
+func TestGetAllPages(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-pages", func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+
+	durTx, err := NewDurableTransaction(db, []string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	store, err := durTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := store.Put([]byte{'v', byte(i)}, []byte{'k', byte(i)}); err != nil {
+			t.Fatalf("Error putting value: %v", err)
+		}
+	}
+
+	vals, err := store.GetAllBytes(nil, 3)
+	if err != nil {
+		t.Fatalf("Error getting all values: %v", err)
+	}
+	if len(vals) != 3 || vals[2][1] != 2 {
+		t.Fatalf("unexpected values with count 3: %v", vals)
+	}
+	keys, err := store.GetAllKeysBytes(nil, 0)
+	if err != nil {
+		t.Fatalf("Error getting all keys: %v", err)
+	}
+	if len(keys) != 10 || keys[9][1] != 9 {
+		t.Fatalf("unexpected keys without count: %v", keys)
+	}
+
+	var pages, records int
+	err = store.ScanPages(Bound([]byte{'k', 1}, []byte{'k', 8}, false, true), 3, func(keys, vals []js.Value) error {
+		for i := range keys {
+			key, err := CopyBinaryFromJs(keys[i])
+			if err != nil {
+				return err
+			}
+			if key[1] != byte(records+1) {
+				return errors.New("unexpected key order")
+			}
+			records++
+		}
+		pages++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error scanning pages: %v", err)
+	}
+	if pages != 3 || records != 7 {
+		t.Fatalf("expected 7 records in 3 pages, got %d in %d", records, pages)
+	}
+	if err := durTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+}
+
+// testRecord is a record used to test the value codec.
+type testRecord struct {
+	ID      string            `idb:"id"`
+	Name    string            `idb:"name"`
+	Age     int               `idb:"age,omitempty"`
+	Created time.Time         `idb:"created"`
+	Tags    []string          `idb:"tags"`
+	Data    []byte            `idb:"data"`
+	Scores  map[int]float64   `idb:"scores"`
+	Labels  map[string]string `idb:"labels"`
+	Ignored string            `idb:"-"`
+}
+
+func TestValueCodec(t *testing.T) {
+	id := "records"
+	db := openTestDB(t, "test-db-codec", func(d *DatabaseUpdate) error {
+		if err := d.CreateObjectStore(id, NewCreateObjectStoreOpts("id", false)); err != nil {
+			return err
+		}
+		store, err := d.GetTransaction().GetObjectStore(id)
+		if err != nil {
+			return err
+		}
+		_, err = store.CreateIndex("byName", NewKeyPath("name"), nil)
+		return err
+	})
+	defer db.Close()
+
+	rec := &testRecord{
+		ID:      "rec-1",
+		Name:    "alice",
+		Created: time.UnixMilli(1700000000123).UTC(),
+		Tags:    []string{"a", "b"},
+		Data:    []byte{1, 2, 3},
+		Scores:  map[int]float64{1: 1.5},
+		Labels:  map[string]string{"k": "v"},
+		Ignored: "ignored",
+	}
+	durTx, err := NewDurableTransaction(db, []string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	store, err := durTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	if _, err := store.Put(rec, nil); err != nil {
+		t.Fatalf("Error putting value: %v", err)
+	}
+	if err := durTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+
+	// query by the index on the encoded struct field
+	txn, err := db.Transaction([]string{id}, READONLY)
+	if err != nil {
+		t.Fatalf("Error getting transaction: %v", err)
+	}
+	ostore, err := txn.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	index, err := ostore.Index("byName")
+	if err != nil {
+		t.Fatalf("Error getting index: %v", err)
+	}
+	val, err := WaitRequest(index.GetJsValue().Call("get", "alice"))
+	if err != nil {
+		t.Fatalf("Error waiting for request: %v", err)
+	}
+	if !val.Get("age").IsUndefined() || !val.Get("Ignored").IsUndefined() {
+		t.Fatal("expected omitempty and ignored fields to be skipped")
+	}
+
+	out := &testRecord{}
+	if err := DecodeValue(val, out); err != nil {
+		t.Fatalf("Error decoding value: %v", err)
+	}
+	rec.Ignored = ""
+	if !reflect.DeepEqual(rec, out) {
+		t.Fatalf("decoded record mismatch:\n%#v\nexpected:\n%#v", out, rec)
+	}
+
+	var generic interface{}
+	if err := DecodeValue(val, &generic); err != nil {
+		t.Fatalf("Error decoding value: %v", err)
+	}
+	if m, ok := generic.(map[string]interface{}); !ok || m["name"] != "alice" {
+		t.Fatalf("unexpected generic decode: %#v", generic)
+	}
+
+	if _, err := EncodeValue(int64(1 << 60)); err == nil {
+		t.Fatal("expected error encoding unsafe integer")
+	}
+}
+
+// testEmbeddedInner is an unexported struct embedded by pointer.
+type testEmbeddedInner struct {
+	Name string
+}
+
+// testEmbedded embeds a pointer to an unexported struct.
+type testEmbedded struct {
+	*testEmbeddedInner
+	ID string
+}
+
+func TestValueCodecEmbeddedPointer(t *testing.T) {
+	val, err := EncodeValue(&testEmbedded{testEmbeddedInner: &testEmbeddedInner{Name: "alice"}, ID: "1"})
+	if err != nil {
+		t.Fatalf("Error encoding value: %v", err)
+	}
+
+	// the nil embedded pointer cannot be allocated.
+	out := &testEmbedded{}
+	if err := DecodeValue(val, out); err == nil {
+		t.Fatal("Expected error decoding into a nil embedded pointer to an unexported struct")
+	}
+
+	out = &testEmbedded{testEmbeddedInner: &testEmbeddedInner{}}
+	if err := DecodeValue(val, out); err != nil {
+		t.Fatalf("Error decoding value: %v", err)
+	}
+	if out.Name != "alice" || out.ID != "1" {
+		t.Fatalf("Decoded wrong value: %#v", out)
+	}
+
+	// fields of the embedded struct which are not set are skipped.
+	val.Delete("Name")
+	out = &testEmbedded{}
+	if err := DecodeValue(val, out); err != nil {
+		t.Fatalf("Error decoding value: %v", err)
+	}
+	if out.testEmbeddedInner != nil || out.ID != "1" {
+		t.Fatalf("Decoded wrong value: %#v", out)
+	}
+}
+
+// testRecursiveEmbed embeds a pointer to itself.
+type testRecursiveEmbed struct {
+	*testRecursiveEmbed
+	ID string
+}
+
+func TestValueCodecRecursiveEmbed(t *testing.T) {
+	val, err := EncodeValue(&testRecursiveEmbed{ID: "1"})
+	if err != nil {
+		t.Fatalf("Error encoding value: %v", err)
+	}
+	out := &testRecursiveEmbed{}
+	if err := DecodeValue(val, out); err != nil {
+		t.Fatalf("Error decoding value: %v", err)
+	}
+	if out.ID != "1" || out.testRecursiveEmbed != nil {
+		t.Fatalf("Decoded wrong value: %#v", out)
+	}
+}
+
+// testVTMessage implements VTMessage for testing.
+type testVTMessage struct {
+	body string
+}
+
+// MarshalVT marshals the message to binary.
+func (m *testVTMessage) MarshalVT() ([]byte, error) {
+	return []byte(m.body), nil
+}
+
+// UnmarshalVT unmarshals the message from binary.
+func (m *testVTMessage) UnmarshalVT(data []byte) error {
+	m.body = string(data)
+	return nil
+}
+
+func TestTypedStore(t *testing.T) {
+	recordsID, msgsID := "records", "messages"
+	db := openTestDB(t, "test-db-typed", func(d *DatabaseUpdate) error {
+		if err := d.CreateObjectStore(recordsID, NewCreateObjectStoreOpts("id", false)); err != nil {
+			return err
+		}
+		if err := d.CreateObjectStore(msgsID, nil); err != nil {
+			return err
+		}
+		store, err := d.GetTransaction().GetObjectStore(recordsID)
+		if err != nil {
+			return err
+		}
+		_, err = store.CreateIndex("byName", NewKeyPath("name"), nil)
+		return err
+	})
+	defer db.Close()
+
+	durTx, err := NewDurableTransaction(db, []string{recordsID, msgsID}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	recordsStore, err := durTx.GetObjectStore(recordsID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	records := NewTypedStore[string, *testRecord](recordsStore, StringCodec{}, ObjectCodec[*testRecord]{})
+	for _, rec := range []*testRecord{
+		{ID: "1", Name: "alice"},
+		{ID: "2", Name: "bob"},
+		{ID: "3", Name: "alice"},
+	} {
+		if err := records.Put(rec.ID, rec); err != nil {
+			t.Fatalf("Error putting value: %v", err)
+		}
+	}
+	rec, found, err := records.Get("2")
+	if err != nil {
+		t.Fatalf("Error getting value: %v", err)
+	}
+	if !found || rec.Name != "bob" {
+		t.Fatalf("unexpected record: %v %#v", found, rec)
+	}
+	if err := records.Delete("2"); err != nil {
+		t.Fatalf("Error deleting key: %v", err)
+	}
+	if _, found, err = records.Get("2"); err != nil || found {
+		t.Fatalf("expected record to be deleted: %v %v", found, err)
+	}
+
+	byName := NewTypedIndex[string](records, "byName", StringCodec{})
+	var ids []string
+	err = byName.GetAll("alice", 0, func(key string, val *testRecord) error {
+		ids = append(ids, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error getting all values: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "1" || ids[1] != "3" {
+		t.Fatalf("unexpected index query result: %v", ids)
+	}
+
+	msgsStore, err := durTx.GetObjectStore(msgsID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	msgs := NewTypedStore[[]int, *testVTMessage](msgsStore, JSONCodec[[]int]{}, NewVTCodec[testVTMessage]())
+	for i, body := range []string{"a", "b", "c"} {
+		if err := msgs.Put([]int{1, i}, &testVTMessage{body: body}); err != nil {
+			t.Fatalf("Error putting value: %v", err)
+		}
+	}
+	var bodies string
+	err = msgs.Scan(js.Undefined(), func(key []int, val *testVTMessage) error {
+		bodies += val.body
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error scanning object store: %v", err)
+	}
+	if bodies != "abc" {
+		t.Fatalf("unexpected scan result: %s", bodies)
+	}
+	if err := msgs.Put([]int{1, 3}, nil); err == nil {
+		t.Fatal("expected error putting a nil message")
+	}
+	if err := durTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+}
+
+func TestKvtxTupleKeys(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-tuple", func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+
+	kvtx := openTestKvtx(t, db, id, READWRITE)
+	keys := []tuple.Tuple{
+		{"acme", "user", int64(-5)},
+		{"acme", "user", int64(10)},
+		{"acme", "user\xff", int64(1)},
+		{"acme", "users", int64(1)},
+		{"other", "user", int64(1)},
+	}
+	for _, key := range keys {
+		if err := kvtx.Set(key.MustPack(), []byte("v")); err != nil {
+			t.Fatalf("Error setting key/value: %v", err)
+		}
+	}
+
+	var found []tuple.Tuple
+	err := kvtx.ScanPrefixKeys(tuple.Tuple{"acme", "user"}.MustPack(), func(key []byte) error {
+		tup, err := tuple.Unpack(key)
+		found = append(found, tup)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error scanning prefix keys: %v", err)
+	}
+	if !reflect.DeepEqual(found, keys[:2]) {
+		t.Fatalf("unexpected prefix scan result: %v", found)
+	}
+
+	partial, err := tuple.Tuple{"acme", "user"}.PartialPrefix()
+	if err != nil {
+		t.Fatalf("Error packing partial prefix: %v", err)
+	}
+	var count int
+	err = kvtx.ScanPrefixKeys(partial, func(key []byte) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error scanning prefix keys: %v", err)
+	}
+	if count != 4 {
+		t.Fatalf("expected 4 keys with partial prefix, got %d", count)
+	}
+	if err := kvtx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+}
+
+func TestCompoundKeys(t *testing.T) {
+	compoundID, arrayID := "compound", "arrayKeys"
+	db := openTestDB(t, "test-db-compound", func(d *DatabaseUpdate) error {
+		opts := NewCreateObjectStoreOptsWithKeyPath(NewCompoundKeyPath("tenant", "id"), false)
+		if err := d.CreateObjectStore(compoundID, opts); err != nil {
+			return err
+		}
+		if _, err := d.CreateIndex(compoundID, "byTenantName", NewCompoundKeyPath("tenant", "name"), nil); err != nil {
+			return err
+		}
+		return d.CreateObjectStore(arrayID, nil)
+	})
+	defer db.Close()
+
+	durTx, err := NewDurableTransaction(db, []string{compoundID, arrayID}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	store, err := durTx.GetObjectStore(compoundID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	for _, rec := range []map[string]interface{}{
+		{"tenant": "a", "id": 2, "name": "zed"},
+		{"tenant": "a", "id": 1, "name": "amy"},
+		{"tenant": "b", "id": 1, "name": "bob"},
+	} {
+		if _, err := store.Put(rec, nil); err != nil {
+			t.Fatalf("Error putting value: %v", err)
+		}
+	}
+
+	keys, err := store.GetAllKeys(Bound([]interface{}{"a", 0}, []interface{}{"a", 100}, false, false), 0)
+	if err != nil {
+		t.Fatalf("Error getting all keys: %v", err)
+	}
+	var decoded []interface{}
+	for _, key := range keys {
+		dk, err := DecodeKey(key)
+		if err != nil {
+			t.Fatalf("Error decoding key: %v", err)
+		}
+		decoded = append(decoded, dk)
+	}
+	expected := []interface{}{
+		[]interface{}{"a", float64(1)},
+		[]interface{}{"a", float64(2)},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("unexpected compound keys: %#v", decoded)
+	}
+
+	names, err := store.Index("byTenantName").GetAll([]interface{}{"a", "zed"}, 0)
+	if err != nil {
+		t.Fatalf("Error getting all values: %v", err)
+	}
+	if len(names) != 1 || names[0].Get("id").Int() != 2 {
+		t.Fatal("unexpected compound index query result")
+	}
+
+	// out-of-line native array keys with binary elements
+	arrStore, err := durTx.GetObjectStore(arrayID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	arrKey, err := EncodeKey([]interface{}{"x", []byte{1, 2}})
+	if err != nil {
+		t.Fatalf("Error encoding key: %v", err)
+	}
+	if _, err := arrStore.Put("value", arrKey); err != nil {
+		t.Fatalf("Error putting value: %v", err)
+	}
+	cursor, err := arrStore.OpenCursor(js.Undefined())
+	if err != nil {
+		t.Fatalf("Error opening cursor: %v", err)
+	}
+	cv := cursor.WaitValue()
+	if cv == nil {
+		t.Fatal("expected cursor value")
+	}
+	dk, err := DecodeKey(cv.Key)
+	if err != nil {
+		t.Fatalf("Error decoding key: %v", err)
+	}
+	if !reflect.DeepEqual(dk, []interface{}{"x", []byte{1, 2}}) {
+		t.Fatalf("unexpected array key from cursor: %#v", dk)
+	}
+	if _, err := EncodeKey(map[string]interface{}{"a": 1}); err == nil {
+		t.Fatal("expected error encoding object as key")
+	}
+	if err := durTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+}
+
+// openBenchKvtx opens a database with a store populated with n 256-byte values.
+func openBenchKvtx(b *testing.B, name string, n int) *Database {
+	id := "testObjectStore"
+	db := openTestDB(b, name, func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	kvtx := openTestKvtx(b, db, id, READWRITE)
+	if err := kvtx.objStore.Clear(); err != nil {
+		b.Fatalf("Error clearing object store: %v", err)
+	}
+	val := make([]byte, 256)
+	for i := 0; i < n; i++ {
+		if err := kvtx.Set([]byte{'k', byte(i >> 8), byte(i)}, val); err != nil {
+			b.Fatalf("Error setting key/value: %v", err)
+		}
+	}
+	if err := kvtx.Commit(); err != nil {
+		b.Fatalf("Error committing transaction: %v", err)
+	}
+	return db
+}
+
+// newBenchKvtx opens a read-only Kvtx on the benchmark store.
+func newBenchKvtx(b *testing.B, db *Database) *Kvtx {
+	return openTestKvtx(b, db, "testObjectStore", READONLY)
+}
+
+func BenchmarkKvtxGet(b *testing.B) {
+	db := openBenchKvtx(b, "bench-db-get", 1)
+	defer db.Close()
+	kvtx := newBenchKvtx(b, db)
+	defer kvtx.Discard()
+	key := []byte{'k', 0, 0}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, found, err := kvtx.Get(key); err != nil || !found {
+			b.Fatal("expected value", err)
+		}
+	}
+}
+
+func BenchmarkKvtxScanPrefix(b *testing.B) {
+	db := openBenchKvtx(b, "bench-db-scan", 100)
+	defer db.Close()
+	kvtx := newBenchKvtx(b, db)
+	defer kvtx.Discard()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := kvtx.ScanPrefix([]byte("k"), func(key, val []byte) error {
+			return nil
+		})
+		if err != nil {
+			b.Fatalf("Error scanning prefix: %v", err)
+		}
+	}
+}
+
+func BenchmarkKvtxGetInto(b *testing.B) {
+	db := openBenchKvtx(b, "bench-db-get", 1)
+	defer db.Close()
+	kvtx := newBenchKvtx(b, db)
+	defer kvtx.Discard()
+	key := []byte{'k', 0, 0}
+	var buf []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var found bool
+		var err error
+		buf, found, err = kvtx.GetInto(key, buf)
+		if err != nil || !found {
+			b.Fatal("expected value", err)
+		}
+	}
+}
+
+func BenchmarkKvtxScanPrefixBorrowed(b *testing.B) {
+	db := openBenchKvtx(b, "bench-db-scan", 100)
+	defer db.Close()
+	kvtx := newBenchKvtx(b, db)
+	defer kvtx.Discard()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := kvtx.ScanPrefixBorrowed([]byte("k"), func(key, val []byte) error {
+			return nil
+		})
+		if err != nil {
+			b.Fatalf("Error scanning prefix: %v", err)
+		}
+	}
+}
+
+func BenchmarkKvtxScanPrefixKeysBorrowed(b *testing.B) {
+	db := openBenchKvtx(b, "bench-db-scan", 100)
+	defer db.Close()
+	kvtx := newBenchKvtx(b, db)
+	defer kvtx.Discard()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := kvtx.ScanPrefixKeysBorrowed([]byte("k"), func(key []byte) error {
+			return nil
+		})
+		if err != nil {
+			b.Fatalf("Error scanning prefix keys: %v", err)
+		}
+	}
+}
+
+func TestKvtxBorrowed(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-borrowed", func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+
+	kvtx := openTestKvtx(t, db, id, READWRITE)
+	defer kvtx.Discard()
+	for _, k := range []string{"a1", "a2", "b1"} {
+		if err := kvtx.Set([]byte(k), []byte("value-"+k)); err != nil {
+			t.Fatalf("Error setting key/value: %v", err)
+		}
+	}
+
+	buf := make([]byte, 0, 64)
+	data, found, err := kvtx.GetInto([]byte("a2"), buf)
+	if err != nil || !found {
+		t.Fatalf("Expected value: found=%v err=%v", found, err)
+	}
+	if string(data) != "value-a2" || &data[0] != &buf[:1][0] {
+		t.Fatalf("expected value in dst buffer: %q", data)
+	}
+
+	var keys, vals []string
+	err = kvtx.ScanPrefixBorrowed([]byte("a"), func(key, val []byte) error {
+		keys = append(keys, string(key))
+		vals = append(vals, string(val))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error scanning prefix: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"a1", "a2"}) ||
+		!reflect.DeepEqual(vals, []string{"value-a1", "value-a2"}) {
+		t.Fatalf("unexpected borrowed scan: %v %v", keys, vals)
+	}
+
+	keys = nil
+	err = kvtx.ScanPrefixKeysBorrowed(nil, func(key []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error scanning prefix keys: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"a1", "a2", "b1"}) {
+		t.Fatalf("unexpected borrowed key scan: %v", keys)
+	}
+}
+
+func TestKvtxBlobStore(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-blob", func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+
+	newBlobs := func() (*Kvtx, *KvtxBlobStore) {
+		kvtx := openTestKvtx(t, db, id, READWRITE)
+		return kvtx, NewKvtxBlobStore(kvtx, 1024)
+	}
+	countChunks := func(kvtx *Kvtx) int {
+		var n int
+		err := kvtx.ScanPrefixKeys([]byte(KvtxBlobChunkPrefix), func(key []byte) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error scanning prefix keys: %v", err)
+		}
+		return n
+	}
+
+	key := []byte("big")
+	data := make([]byte, 2500)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	kvtx, blobs := newBlobs()
+	if n, err := blobs.Set(key, bytes.NewReader(data)); err != nil || n != int64(len(data)) {
+		t.Fatalf("Expected blob to be written: n=%d err=%v", n, err)
+	}
+	if err := kvtx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+
+	kvtx, blobs = newBlobs()
+	if c := countChunks(kvtx); c != 3 {
+		t.Fatalf("expected 3 chunks but got %d", c)
+	}
+	r, found, err := blobs.OpenReader(key)
+	if err != nil || !found {
+		t.Fatalf("Expected blob: found=%v err=%v", found, err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Error reading blob: %v", err)
+	}
+	if !bytes.Equal(out, data) || r.Size() != int64(len(data)) {
+		t.Fatal("blob contents mismatch")
+	}
+
+	// an unfinished rewrite does not change the current blob
+	w, err := blobs.OpenWriter(key)
+	if err != nil {
+		t.Fatalf("Error opening blob writer: %v", err)
+	}
+	if _, err := w.Write(make([]byte, 2048)); err != nil {
+		t.Fatalf("Error writing blob: %v", err)
+	}
+	r, _, err = blobs.OpenReader(key)
+	if err != nil {
+		t.Fatalf("Error opening blob reader: %v", err)
+	}
+	if out, err := io.ReadAll(r); err != nil || !bytes.Equal(out, data) {
+		t.Fatalf("Expected the previous blob contents: %v", err)
+	}
+
+	// overwrite with a smaller blob: stale chunks are removed
+	w, err = blobs.OpenWriter(key)
+	if err != nil {
+		t.Fatalf("Error opening blob writer: %v", err)
+	}
+	if _, err := w.Write([]byte("small")); err != nil {
+		t.Fatalf("Error writing blob: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Error closing blob writer: %v", err)
+	}
+	if c := countChunks(kvtx); c != 1 {
+		t.Fatalf("expected 1 chunk but got %d", c)
+	}
+	if size, _, err := blobs.Size(key); err != nil || size != 5 {
+		t.Fatalf("Expected size 5: size=%d err=%v", size, err)
+	}
+
+	// corrupt the chunk: reading fails the checksum
+	m, _, err := blobs.readManifest(key)
+	if err != nil {
+		t.Fatalf("Error reading manifest: %v", err)
+	}
+	if err := kvtx.Set(blobChunkKey(blobGenerationPrefix(key, m.Generation), 0), []byte("SMALL")); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	r, _, err = blobs.OpenReader(key)
+	if err != nil {
+		t.Fatalf("Error opening blob reader: %v", err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrBlobChecksum) {
+		t.Fatalf("expected checksum error but got %v", err)
+	}
+
+	if err := blobs.Delete(key); err != nil {
+		t.Fatalf("Error deleting key: %v", err)
+	}
+	if c := countChunks(kvtx); c != 0 {
+		t.Fatalf("expected 0 chunks but got %d", c)
+	}
+	if _, found, err := blobs.OpenReader(key); err != nil || found {
+		t.Fatalf("Expected blob to be deleted: found=%v err=%v", found, err)
+	}
+	if err := kvtx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+}
+
+func TestBlob(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-native-blob", func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+
+	data := make([]byte, 200*1024+17)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	blob, err := NewBlobFromReader(bytes.NewReader(data), "application/octet-stream")
+	if err != nil {
+		t.Fatalf("Error creating blob: %v", err)
+	}
+	file, err := NewFileFromReader(bytes.NewReader([]byte("hello")), "hello.txt", "text/plain")
+	if err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+	type blobRecord struct {
+		Name string `idb:"name"`
+		Data *Blob  `idb:"data"`
+	}
+
+	durTx, err := NewDurableTransaction(db, []string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	store, err := durTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	if _, err := store.Put(blob, "blob"); err != nil {
+		t.Fatalf("Error putting value: %v", err)
+	}
+	if _, err := store.Put(file, "file"); err != nil {
+		t.Fatalf("Error putting value: %v", err)
+	}
+	if _, err := store.Put(&blobRecord{Name: "rec", Data: file}, "record"); err != nil {
+		t.Fatalf("Error putting value: %v", err)
+	}
+	if err := durTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+
+	durTx, err = NewDurableTransaction(db, []string{id}, READONLY)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	defer durTx.Abort()
+	store, err = durTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	gotBlob, err := store.GetBlob("blob")
+	if err != nil {
+		t.Fatalf("Error getting blob: %v", err)
+	}
+	gotFile, err := store.GetBlob("file")
+	if err != nil {
+		t.Fatalf("Error getting blob: %v", err)
+	}
+	recVal, err := store.Get("record")
+	if err != nil {
+		t.Fatalf("Error getting value: %v", err)
+	}
+	if missing, err := store.GetBlob("missing"); err != nil || missing != nil {
+		t.Fatalf("Expected missing blob to be nil: %v", err)
+	}
+
+	if gotBlob.Size() != int64(len(data)) || gotBlob.Type() != "application/octet-stream" || gotBlob.IsFile() {
+		t.Fatal("unexpected blob metadata")
+	}
+	out, err := io.ReadAll(gotBlob.NewReader())
+	if err != nil {
+		t.Fatalf("Error reading blob: %v", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("blob contents mismatch")
+	}
+	var chunks int
+	out = out[:0]
+	err = gotBlob.ReadChunks(64*1024, func(chunk []byte) error {
+		chunks++
+		out = append(out, chunk...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading chunks: %v", err)
+	}
+	if chunks != 4 || !bytes.Equal(out, data) {
+		t.Fatalf("unexpected chunked read: %d chunks", chunks)
+	}
+
+	if gotFile.Name() != "hello.txt" || !gotFile.IsFile() {
+		t.Fatal("expected file name to be preserved")
+	}
+	rec := &blobRecord{}
+	if err := DecodeValue(recVal, rec); err != nil {
+		t.Fatalf("Error decoding value: %v", err)
+	}
+	if rec.Data == nil {
+		t.Fatal("expected blob field to be decoded")
+	}
+	out, err = io.ReadAll(rec.Data.NewReader())
+	if err != nil {
+		t.Fatalf("Error reading blob: %v", err)
+	}
+	if string(out) != "hello" {
+		t.Fatalf("unexpected file contents: %q", out)
+	}
+}
+
+func TestKvtxCompression(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-compression", func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+
+	codec, err := NewCompressionCodec(64, 0)
+	if err != nil {
+		t.Fatalf("Error creating compression codec: %v", err)
+	}
+	kvtx := openTestKvtx(t, db, id, READWRITE, WithKvtxValueCodec(codec))
+	defer kvtx.Discard()
+
+	large := bytes.Repeat([]byte(`{"name":"compressible","count":1},`), 100)
+	random := make([]byte, 512)
+	for i := range random {
+		random[i] = byte((i * 7919) ^ (i >> 3) * 31)
+	}
+	values := map[string][]byte{
+		"a-small":  []byte("small"),
+		"b-large":  large,
+		"c-random": random,
+	}
+	for k, v := range values {
+		if err := kvtx.Set([]byte(k), v); err != nil {
+			t.Fatalf("Error setting key/value: %v", err)
+		}
+	}
+
+	stored, err := kvtx.objStore.Get([]byte("b-large"))
+	if err != nil {
+		t.Fatalf("Error getting value: %v", err)
+	}
+	if stored.Length() >= len(large)/4 {
+		t.Fatalf("expected value to be compressed: %d bytes", stored.Length())
+	}
+	if hdr := CopyByteSliceFromJs(stored)[0]; hdr != compressionHeaderDeflate {
+		t.Fatalf("unexpected header: %d", hdr)
+	}
+
+	for k, v := range values {
+		data, found, err := kvtx.Get([]byte(k))
+		if err != nil || !found {
+			t.Fatalf("Expected value for %s: found=%v err=%v", k, found, err)
+		}
+		if !bytes.Equal(data, v) {
+			t.Fatalf("value mismatch for %s", k)
+		}
+	}
+
+	var n int
+	err = kvtx.ScanPrefixBorrowed(nil, func(key, val []byte) error {
+		n++
+		if !bytes.Equal(val, values[string(key)]) {
+			t.Fatalf("scan value mismatch for %s", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error scanning prefix: %v", err)
+	}
+	err = kvtx.ScanPrefix([]byte("b"), func(key, val []byte) error {
+		n++
+		if !bytes.Equal(val, large) {
+			t.Fatalf("scan value mismatch for %s", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error scanning prefix: %v", err)
+	}
+	if n != 4 {
+		t.Fatalf("expected 4 scanned values but got %d", n)
+	}
+
+	// values written before the codec was enabled have no header.
+	plain, err := NewKvtxTx(kvtx.txn, id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	legacy := []byte(`{"name":"legacy"}`)
+	if err := plain.Set([]byte("d-legacy"), legacy); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	if _, _, err := kvtx.Get([]byte("d-legacy")); err == nil {
+		t.Fatal("expected error reading an untagged value")
+	}
+	untaggedCodec, err := NewCompressionCodec(64, 0, WithCompressionAllowUntagged())
+	if err != nil {
+		t.Fatalf("Error creating codec: %v", err)
+	}
+	kvtx, err = NewKvtxTx(kvtx.txn, id, WithKvtxValueCodec(untaggedCodec))
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	data, found, err := kvtx.Get([]byte("d-legacy"))
+	if err != nil || !found || !bytes.Equal(data, legacy) {
+		t.Fatalf("Expected untagged value to be read as uncompressed: found=%v err=%v", found, err)
+	}
+	// writing the value again adds the header.
+	if err := kvtx.Set([]byte("d-legacy"), data); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	stored, err = kvtx.objStore.Get([]byte("d-legacy"))
+	if err != nil {
+		t.Fatalf("Error getting value: %v", err)
+	}
+	if hdr := CopyByteSliceFromJs(stored)[0]; hdr != compressionHeaderRaw {
+		t.Fatalf("unexpected header: %d", hdr)
+	}
+}
+
+func TestEncryptedKvtx(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-encrypted", func(d *DatabaseUpdate) error {
+		return d.CreateObjectStore(id, nil)
+	})
+	defer db.Close()
+
+	keyring, err := NewEncryptionKeyring(1, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("Error creating keyring: %v", err)
+	}
+	kvtx := openTestKvtx(t, db, id, READWRITE)
+	defer kvtx.Discard()
+	enc, err := NewEncryptedKvtx(kvtx, keyring, &EncryptedKvtxOpts{
+		KeyHMACKey: []byte("hmac-key"),
+	})
+	if err != nil {
+		t.Fatalf("Error creating encrypted kvtx: %v", err)
+	}
+
+	values := map[string]string{
+		"users/alice": "a",
+		"users/bob":   "b",
+		"groups/dev":  "g",
+	}
+	for k, v := range values {
+		if err := enc.Set([]byte(k), []byte(v)); err != nil {
+			t.Fatalf("Error setting key/value: %v", err)
+		}
+	}
+
+	// no plaintext in the underlying store
+	err = kvtx.ScanPrefix(nil, func(key, val []byte) error {
+		if bytes.Contains(key, []byte("users")) || bytes.Contains(val, []byte("alice")) {
+			t.Fatalf("found plaintext in store: %q", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error scanning prefix: %v", err)
+	}
+
+	if data, found, err := enc.Get([]byte("users/bob")); err != nil || !found || string(data) != "b" {
+		t.Fatalf("Expected decrypted value: data=%s found=%v err=%v", data, found, err)
+	}
+	scanKeys := func(prefix string) map[string]string {
+		out := make(map[string]string)
+		err := enc.ScanPrefix([]byte(prefix), func(key, val []byte) error {
+			out[string(key)] = string(val)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error scanning prefix: %v", err)
+		}
+		return out
+	}
+	expected := map[string]string{"users/alice": "a", "users/bob": "b"}
+	if got := scanKeys("users/"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected prefix scan: %v", got)
+	}
+	if got := scanKeys("users/al"); !reflect.DeepEqual(got, map[string]string{"users/alice": "a"}) {
+		t.Fatalf("unexpected partial prefix scan: %v", got)
+	}
+
+	// rotate the key and migrate
+	if err := keyring.AddKey(2, bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatalf("Error adding key: %v", err)
+	}
+	if err := keyring.SetActive(2); err != nil {
+		t.Fatalf("Error setting active key: %v", err)
+	}
+	if err := enc.Set([]byte("users/carol"), []byte("c")); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	n, err := enc.ReEncrypt()
+	if err != nil {
+		t.Fatalf("Error re-encrypting values: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 re-encrypted values but got %d", n)
+	}
+	rotated, err := NewEncryptionKeyring(2, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatalf("Error creating keyring: %v", err)
+	}
+	enc, err = NewEncryptedKvtx(kvtx, rotated, &EncryptedKvtxOpts{KeyHMACKey: []byte("hmac-key")})
+	if err != nil {
+		t.Fatalf("Error creating encrypted kvtx: %v", err)
+	}
+	if got := scanKeys(""); len(got) != 4 || got["groups/dev"] != "g" {
+		t.Fatalf("unexpected scan after rotation: %v", got)
+	}
+
+	// a value moved to another key fails authentication
+	sealed, _, err := kvtx.Get(enc.encodeKey([]byte("users/bob"), false))
+	if err != nil {
+		t.Fatalf("Error getting value: %v", err)
+	}
+	if err := kvtx.Set(enc.encodeKey([]byte("users/alice"), false), sealed); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	if _, _, err := enc.Get([]byte("users/alice")); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("expected decryption error but got %v", err)
+	}
+}
+
+func TestWatcherQueueLimit(t *testing.T) {
+	w := &Watcher{store: "testObjectStore", wake: make(chan struct{}, 1)}
+	for i := 0; i < watcherQueueLimit+10; i++ {
+		w.push(&ChangeEvent{Store: w.store, Keys: []js.Value{js.ValueOf(i)}, Local: true})
+	}
+	if len(w.queue) != watcherQueueLimit {
+		t.Fatalf("Expected %d queued events, got %d", watcherQueueLimit, len(w.queue))
+	}
+	last := w.queue[len(w.queue)-1]
+	if !last.AllKeys || len(last.Keys) != 0 || !last.Local {
+		t.Fatalf("Expected overflowing events to be coalesced: %#v", last)
+	}
+	w.push(&ChangeEvent{Store: w.store, Keys: []js.Value{js.ValueOf("remote")}})
+	if len(w.queue) != watcherQueueLimit || last.Local {
+		t.Fatalf("Expected remote event to be coalesced: %d %#v", len(w.queue), last)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	id := "testObjectStore"
+	open := func() *Database {
+		return openTestDB(t, "test-db-watch", func(d *DatabaseUpdate) error {
+			return d.CreateObjectStore(id, nil)
+		})
+	}
+	// db2 simulates another tab with its own BroadcastChannel.
+	db1, db2 := open(), open()
+	defer db1.Close()
+	defer db2.Close()
+	if err := db1.EnableChangeFeed(); err != nil {
+		t.Fatalf("Error enabling change feed: %v", err)
+	}
+	defer db1.DisableChangeFeed()
+	defer db2.DisableChangeFeed()
+
+	local, err := db1.Watch(id, []byte("users/"))
+	if err != nil {
+		t.Fatalf("Error watching object store: %v", err)
+	}
+	defer local.Close()
+	remote, err := db2.Watch(id, []byte("users/"))
+	if err != nil {
+		t.Fatalf("Error watching object store: %v", err)
+	}
+	defer remote.Close()
+
+	kvtx := openTestKvtx(t, db1, id, READWRITE)
+	if err := kvtx.Set([]byte("users/alice"), []byte("a")); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	if err := kvtx.Set([]byte("groups/dev"), []byte("g")); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	if err := kvtx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+
+	waitEvent := func(w *Watcher) *ChangeEvent {
+		select {
+		case ev := <-w.Events():
+			return ev
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for change event")
+			return nil
+		}
+	}
+	for _, w := range []*Watcher{local, remote} {
+		ev := waitEvent(w)
+		if ev.Store != id || ev.AllKeys || len(ev.Keys) != 1 {
+			t.Fatalf("unexpected change event: %#v", ev)
+		}
+		if key := CopyByteSliceFromJs(ev.Keys[0]); string(key) != "users/alice" {
+			t.Fatalf("unexpected changed key: %q", key)
+		}
+		if ev.Local != (w == local) {
+			t.Fatalf("unexpected local flag: %v", ev.Local)
+		}
+	}
+
+	// clearing the store notifies all watchers
+	durTx, err := NewDurableTransaction(db1, []string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	store, err := durTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	if err := store.Clear(); err != nil {
+		t.Fatalf("Error clearing object store: %v", err)
+	}
+	if err := durTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+	if ev := waitEvent(remote); !ev.AllKeys {
+		t.Fatalf("expected all keys event: %#v", ev)
+	}
+
+	remote.Close()
+	if _, ok := <-remote.Events(); ok {
+		t.Fatal("expected events channel to be closed")
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t, "test-db-locks", func(d *DatabaseUpdate) error {
+		return d.CreateLockObjectStore()
+	})
+	defer db.Close()
+
+	type withLockFn func(ctx context.Context, name string, mode LockMode, fn func() error) error
+	impls := map[string]withLockFn{
+		"web-locks": db.WithLock,
+		"lease": func(ctx context.Context, name string, mode LockMode, fn func() error) error {
+			return db.withLeaseLock(ctx, lockNamePrefix+db.GetName()+"/"+name, mode, fn)
+		},
+	}
+	for implName, withLock := range impls {
+		// exclusive holders never overlap
+		var active, maxActive int
+		errCh := make(chan error, 3)
+		for i := 0; i < 3; i++ {
+			go func() {
+				errCh <- withLock(ctx, "counter", LockExclusive, func() error {
+					active++
+					if active > maxActive {
+						maxActive = active
+					}
+					<-time.After(time.Millisecond * 20)
+					active--
+					return nil
+				})
+			}()
+		}
+		for i := 0; i < 3; i++ {
+			if err := <-errCh; err != nil {
+				t.Fatalf("Error holding lock with %s: %v", implName, err)
+			}
+		}
+		if maxActive != 1 {
+			t.Fatalf("%s: expected exclusive lock but %d holders overlapped", implName, maxActive)
+		}
+
+		// shared holders overlap, exclusive waiters time out
+		held := make(chan struct{})
+		releaseShared := make(chan struct{})
+		go func() {
+			errCh <- withLock(ctx, "shared", LockShared, func() error {
+				close(held)
+				<-releaseShared
+				return nil
+			})
+		}()
+		<-held
+		if err := withLock(ctx, "shared", LockShared, func() error { return nil }); err != nil {
+			t.Fatalf("Error holding lock with %s: %v", implName, err)
+		}
+		timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+		err := withLock(timeoutCtx, "shared", LockExclusive, func() error {
+			return errors.New("acquired exclusive lock while shared lock was held")
+		})
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("%s: expected deadline exceeded but got %v", implName, err)
+		}
+		close(releaseShared)
+		if err := <-errCh; err != nil {
+			t.Fatalf("Error holding lock with %s: %v", implName, err)
+		}
+		if err := withLock(ctx, "shared", LockExclusive, func() error { return nil }); err != nil {
+			t.Fatalf("Error holding lock with %s: %v", implName, err)
+		}
+	}
+
+	// a lease taken over by another holder is reported as lost
+	lockName := lockNamePrefix + db.GetName() + "/lost"
+	err := db.withLeaseLock(ctx, lockName, LockExclusive, func() error {
+		txn, err := db.Transaction([]string{LockObjectStoreID}, READWRITE)
+		if err != nil {
+			return err
+		}
+		holders := js.Global().Get("Object").New()
+		holders.Set("other", jsNow()+float64(LockLeaseTTL.Milliseconds()))
+		rec := js.Global().Get("Object").New()
+		rec.Set("mode", string(LockExclusive))
+		rec.Set("holders", holders)
+		txn.val.Call("objectStore", LockObjectStoreID).Call("put", rec, lockName)
+		txn.Commit()
+		return txn.WaitComplete()
+	})
+	if !errors.Is(err, ErrLockLost) {
+		t.Fatalf("expected ErrLockLost: %v", err)
+	}
+}
+
+func TestChangelog(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-changelog", func(d *DatabaseUpdate) error {
+		if err := d.CreateObjectStore(id, nil); err != nil {
+			return err
+		}
+		return d.CreateChangelogObjectStore()
+	})
+	defer db.Close()
+
+	// writes are only logged by transactions which opt in.
+	plainTx, err := NewDurableTransaction(db, []string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	withClog := WithChangelog(&ChangelogOptions{IncludeValues: true})
+	durTx, err := NewDurableTransaction(db, []string{id}, READWRITE, withClog)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	kvtx, err := NewKvtxTx(durTx, id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	if err := kvtx.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	if err := kvtx.Set([]byte("b"), []byte("2")); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	if err := kvtx.Delete([]byte("a")); err != nil {
+		t.Fatalf("Error deleting key: %v", err)
+	}
+	if err := kvtx.objStore.Delete(Bound([]byte("x"), []byte("z"), false, true)); err != nil {
+		t.Fatalf("Error deleting key: %v", err)
+	}
+	if err := kvtx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+	plainStore, err := plainTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	if _, err := plainStore.Put("plain", "d"); err != nil {
+		t.Fatalf("Error putting value: %v", err)
+	}
+	if err := plainTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+
+	// a pending entry is skipped if the changelog is not in the transaction scope.
+	rawTx, err := db.Transaction([]string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting transaction: %v", err)
+	}
+	rawStore, err := rawTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	rawOp := newDurableOp(durableOpPut, "raw", js.ValueOf("e"))
+	rawReq, err := rawOp.issue(rawStore)
+	if err != nil {
+		t.Fatalf("Error issuing op: %v", err)
+	}
+	(&changelog{}).logRequest(rawReq, id, rawOp)
+	rawTx.Commit()
+	if err := rawTx.WaitComplete(); err != nil {
+		t.Fatalf("Error waiting for transaction: %v", err)
+	}
+
+	// aborted writes are not logged
+	durTx, err = NewDurableTransaction(db, []string{id}, READWRITE, withClog)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	store, err := durTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	if _, err := store.Put("aborted", "c"); err != nil {
+		t.Fatalf("Error putting value: %v", err)
+	}
+	durTx.Abort()
+
+	entries, err := db.ReadChangelog(0, 0)
+	if err != nil {
+		t.Fatalf("Error reading changelog: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 changelog entries but got %d", len(entries))
+	}
+	var ops []string
+	for i, ent := range entries {
+		if ent.Store != id || (i != 0 && ent.Seq <= entries[i-1].Seq) {
+			t.Fatalf("unexpected changelog entry: %#v", ent)
+		}
+		ops = append(ops, ent.Op)
+	}
+	if !reflect.DeepEqual(ops, []string{"put", "put", "delete", "delete"}) {
+		t.Fatalf("unexpected changelog ops: %v", ops)
+	}
+	if key := CopyByteSliceFromJs(entries[1].Key); string(key) != "b" {
+		t.Fatalf("unexpected changelog key: %q", key)
+	}
+	if val := CopyByteSliceFromJs(entries[1].Value); string(val) != "2" {
+		t.Fatalf("unexpected changelog value: %q", val)
+	}
+	if !entries[3].Key.IsUndefined() || !entries[3].KeyRange.InstanceOf(js.Global().Get("IDBKeyRange")) {
+		t.Fatal("expected key range delete entry")
+	}
+	if time.Since(entries[0].Time) > time.Minute {
+		t.Fatalf("unexpected changelog time: %v", entries[0].Time)
+	}
+
+	page, err := db.ReadChangelog(entries[1].Seq, 1)
+	if err != nil {
+		t.Fatalf("Error reading changelog: %v", err)
+	}
+	if len(page) != 1 || page[0].Seq != entries[2].Seq {
+		t.Fatal("expected to read from the cursor position")
+	}
+	if err := db.TruncateChangelog(entries[2].Seq); err != nil {
+		t.Fatalf("Error truncating changelog: %v", err)
+	}
+	rest, err := db.ReadChangelog(0, 0)
+	if err != nil {
+		t.Fatalf("Error reading changelog: %v", err)
+	}
+	if len(rest) != 1 || rest[0].Seq != entries[3].Seq {
+		t.Fatalf("expected 1 entry after truncate but got %d", len(rest))
+	}
+
+	// replayed writes are logged if their transaction logged them.
+	wal := &localStorageWAL{
+		ls:     js.Global().Get("localStorage"),
+		prefix: walLocalStoragePrefix + db.GetName() + "/",
+	}
+	if _, err := wal.append(id, newDurableOp(durableOpPut, "replay-plain", js.ValueOf("f")), nil); err != nil {
+		t.Fatalf("Error appending to write-ahead-log: %v", err)
+	}
+	if _, err := wal.append(id, newDurableOp(durableOpPut, "replay-logged", js.ValueOf("g")), &changelog{}); err != nil {
+		t.Fatalf("Error appending to write-ahead-log: %v", err)
+	}
+	if err := replayWriteAheadLog(db, wal); err != nil {
+		t.Fatalf("Error replaying write-ahead-log: %v", err)
+	}
+	rest, err = db.ReadChangelog(0, 0)
+	if err != nil {
+		t.Fatalf("Error reading changelog: %v", err)
+	}
+	if len(rest) != 2 || rest[1].Key.String() != "replay-logged" || !rest[1].Value.IsUndefined() {
+		t.Fatalf("expected the logged replayed write in the changelog: %#v", rest)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	binID, compoundID, autoID := "binary", "compound", "auto"
+	db, err := GlobalIndexedDB().Open(
+		ctx,
+		"test-db-export",
+		3,
+		func(d *DatabaseUpdate, oldVersion, newVersion int) error {
+			if err := d.CreateObjectStore(binID, nil); err != nil {
+				return err
+			}
+			opts := NewCreateObjectStoreOptsWithKeyPath(NewCompoundKeyPath("tenant", "id"), false)
+			if err := d.CreateObjectStore(compoundID, opts); err != nil {
+				return err
+			}
+			if _, err := d.CreateIndex(compoundID, "byName", NewKeyPath("name"), &CreateIndexOpts{Unique: true}); err != nil {
+				return err
+			}
+			return d.CreateObjectStore(autoID, NewCreateObjectStoreOpts("", true))
+		},
+	)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	durTx, err := NewDurableTransaction(db, []string{binID, compoundID, autoID}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	kvtx, err := NewKvtxTx(durTx, binID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	binVal := []byte{0, 1, 2, 0xff, 0xfe}
+	if err := kvtx.Set([]byte{0xff, 0x00, 'k'}, binVal); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	for i := 0; i < exportPageSize+10; i++ {
+		if err := kvtx.Set([]byte(fmt.Sprintf("key-%04d", i)), []byte{byte(i)}); err != nil {
+			t.Fatalf("Error setting key/value: %v", err)
+		}
+	}
+	store, err := durTx.GetObjectStore(compoundID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	for _, rec := range []map[string]interface{}{
+		{"tenant": "a", "id": 1, "name": "amy"},
+		{"tenant": "b", "id": 1, "name": "bob"},
+	} {
+		if _, err := store.Put(rec, nil); err != nil {
+			t.Fatalf("Error putting value: %v", err)
+		}
+	}
+	autoStore, err := durTx.GetObjectStore(autoID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	for _, val := range []string{"one", "two"} {
+		if _, err := autoStore.Add(val, nil); err != nil {
+			t.Fatalf("Error adding value: %v", err)
+		}
+	}
+	if err := durTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := db.Export(ctx, &buf); err != nil {
+		t.Fatalf("Error exporting database: %v", err)
+	}
+	exported := buf.String()
+
+	// write-ahead-log entries left for the replaced database are removed.
+	staleWALKey := walLocalStoragePrefix + "test-db-export-imported/1"
+	js.Global().Get("localStorage").Call("setItem", staleWALKey, "{}")
+	imported, err := GlobalIndexedDB().Import(ctx, "test-db-export-imported", bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Error importing database: %v", err)
+	}
+	if !js.Global().Get("localStorage").Call("getItem", staleWALKey).IsNull() {
+		t.Fatal("expected write-ahead-log entries to be removed by the import")
+	}
+
+	srcSchema, err := db.DescribeSchema()
+	if err != nil {
+		t.Fatalf("Error describing schema: %v", err)
+	}
+	dstSchema, err := imported.DescribeSchema()
+	if err != nil {
+		t.Fatalf("Error describing schema: %v", err)
+	}
+	srcSchema.Name = dstSchema.Name
+	if !reflect.DeepEqual(srcSchema, dstSchema) {
+		srcJSON, _ := json.Marshal(srcSchema)
+		dstJSON, _ := json.Marshal(dstSchema)
+		t.Fatalf("schema mismatch:\n%s\n%s", srcJSON, dstJSON)
+	}
+
+	// the records round-trip exactly
+	buf.Reset()
+	if err := imported.Export(ctx, &buf); err != nil {
+		t.Fatalf("Error exporting database: %v", err)
+	}
+	skipHeader := func(s string) string {
+		return s[strings.IndexByte(s, '\n')+1:]
+	}
+	if skipHeader(buf.String()) != skipHeader(exported) {
+		t.Fatal("re-exported records do not match")
+	}
+
+	durTx, err = NewDurableTransaction(imported, []string{binID, autoID}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	kvtx, err = NewKvtxTx(durTx, binID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	val, found, err := kvtx.Get([]byte{0xff, 0x00, 'k'})
+	if err != nil {
+		t.Fatalf("Error getting value: %v", err)
+	}
+	if !found || !bytes.Equal(val, binVal) {
+		t.Fatalf("unexpected binary value: %v %v", found, val)
+	}
+	// the key generator continues after the imported keys
+	autoStore, err = durTx.GetObjectStore(autoID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	res, err := autoStore.Add("three", nil)
+	if err != nil {
+		t.Fatalf("Error adding value: %v", err)
+	}
+	if err := durTx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+	key, err := res.Key()
+	if err != nil {
+		t.Fatalf("Error waiting for key: %v", err)
+	}
+	if key.Int() != 3 {
+		t.Fatalf("unexpected generated key: %v", key)
+	}
+	imported.Close()
+
+	// a truncated export fails, leaving the existing database in place
+	truncated := exported[:strings.LastIndexByte(strings.TrimSuffix(exported, "\n"), '\n')+1]
+	if _, err := GlobalIndexedDB().Import(ctx, "test-db-export-imported", strings.NewReader(truncated)); err == nil {
+		t.Fatal("expected error importing truncated export")
+	}
+	imported, err = GlobalIndexedDB().Open(ctx, "test-db-export-imported", 3, func(d *DatabaseUpdate, oldVersion, newVersion int) error {
+		return errors.New("expected the existing database to be kept")
+	})
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer imported.Close()
+	durTx, err = NewDurableTransaction(imported, []string{autoID}, READONLY)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	autoStore, err = durTx.GetObjectStore(autoID)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	if n, err := autoStore.Count(nil); err != nil || n != 3 {
+		t.Fatalf("Expected the existing records to be kept, got %d: %v", n, err)
+	}
+	durTx.Abort()
+	if _, err := GlobalIndexedDB().Import(ctx, "test-db-export-invalid", strings.NewReader("{}\n")); err == nil {
+		t.Fatal("expected error importing invalid export")
+	}
+}
+
+func TestStorage(t *testing.T) {
+	storage := GlobalStorage()
+	if storage == nil {
+		t.Skip("StorageManager API not available")
+	}
+	est, err := storage.Estimate()
+	if err != nil {
+		t.Fatalf("Error estimating storage: %v", err)
+	}
+	if est.Quota == 0 || est.Ratio() < 0 || est.Ratio() > 1 {
+		t.Fatalf("unexpected estimate: %#v", est)
+	}
+	if _, err := storage.Persisted(); err != nil {
+		t.Fatalf("Error checking persisted storage: %v", err)
+	}
+
+	// rejections with a QuotaExceededError match ErrQuotaExceeded
+	exc := js.Global().Get("DOMException").New("quota full", "QuotaExceededError")
+	_, err = awaitPromise(js.Global().Get("Promise").Call("reject", exc))
+	if !errors.Is(err, ErrQuotaExceeded) || err.Error() != "quota full" {
+		t.Fatalf("expected quota error: %v", err)
+	}
+	var domErr *DOMError
+	if !errors.As(err, &domErr) || domErr.Name != "QuotaExceededError" {
+		t.Fatalf("expected DOMError: %v", err)
+	}
+	exc = js.Global().Get("DOMException").New("bad key", "DataError")
+	if err := domError(exc); errors.Is(err, ErrQuotaExceeded) {
+		t.Fatal("unexpected quota error match")
+	}
+
+	// quota watcher emits events when crossing thresholds
+	usage := make(chan uint64, 1)
+	usage <- 50
+	estimate := func() (*StorageEstimate, error) {
+		u := <-usage
+		return &StorageEstimate{Usage: u, Quota: 100}, nil
+	}
+	if _, err := newQuotaWatcher(estimate, time.Hour, []float64{1.5}); err == nil {
+		t.Fatal("expected invalid threshold error")
+	}
+	w, err := newQuotaWatcher(estimate, time.Hour, []float64{0.95, 0.8})
+	if err != nil {
+		t.Fatalf("Error watching quota: %v", err)
+	}
+	defer w.Close()
+	expectEvent := func(threshold float64, rising bool) {
+		select {
+		case ev := <-w.Events():
+			if ev.Threshold != threshold || ev.Rising != rising {
+				t.Fatalf("unexpected quota event: %#v", ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for quota event")
+		}
+	}
+	expectNoEvent := func() {
+		select {
+		case ev := <-w.Events():
+			t.Fatalf("unexpected quota event: %#v", ev)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	expectNoEvent()
+	usage <- 85
+	w.Check()
+	expectEvent(0.8, true)
+	usage <- 99
+	w.Check()
+	expectEvent(0.95, true)
+	usage <- 96
+	w.Check()
+	expectNoEvent()
+	usage <- 10
+	w.Check()
+	expectEvent(0, false)
+	w.Close()
+	for range w.Events() {
+	}
+}
+
+func TestKvtxTTL(t *testing.T) {
+	id := "testObjectStore"
+	db := openTestDB(t, "test-db-kvtx-ttl", func(d *DatabaseUpdate) error {
+		if err := d.CreateObjectStore(id, nil); err != nil {
+			return err
+		}
+		if err := d.CreateKvtxExpiryIndex(id); err != nil {
+			return err
+		}
+		return d.CreateChangelogObjectStore()
+	})
+	defer db.Close()
+
+	kvtx := openTestKvtx(t, db, id, READWRITE)
+	if err := kvtx.SetWithTTL([]byte("a/short"), []byte("1"), 50*time.Millisecond); err != nil {
+		t.Fatalf("Error setting key/value with ttl: %v", err)
+	}
+	if err := kvtx.SetWithTTL([]byte("a/long"), []byte("2"), time.Hour); err != nil {
+		t.Fatalf("Error setting key/value with ttl: %v", err)
+	}
+	if err := kvtx.Set([]byte("a/plain"), []byte("3")); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	if err := kvtx.SetWithTTL([]byte("a/none"), []byte("4"), 0); err == nil {
+		t.Fatal("expected error for zero ttl")
+	}
+	if err := kvtx.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+
+	readKeys := func() (keys, keysOnly []string, vals map[string]string) {
+		kvtx := openTestKvtx(t, db, id, READONLY)
+		defer kvtx.Discard()
+		vals = make(map[string]string)
+		err := kvtx.ScanPrefix([]byte("a/"), func(key, val []byte) error {
+			keys = append(keys, string(key))
+			vals[string(key)] = string(val)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error scanning prefix: %v", err)
+		}
+		err = kvtx.ScanPrefixKeys([]byte("a/"), func(key []byte) error {
+			keysOnly = append(keysOnly, string(key))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error scanning prefix keys: %v", err)
+		}
+		for _, key := range []string{"a/short", "a/long", "a/plain"} {
+			val, found, err := kvtx.Get([]byte(key))
+			if err != nil {
+				t.Fatalf("Error getting value: %v", err)
+			}
+			exists, err := kvtx.Exists([]byte(key))
+			if err != nil {
+				t.Fatalf("Error checking key exists: %v", err)
+			}
+			_, scanned := vals[key]
+			if found != scanned || exists != scanned || (found && string(val) != vals[key]) {
+				t.Fatalf("inconsistent read of %s: %v %v %v", key, found, exists, scanned)
+			}
+		}
+		return keys, keysOnly, vals
+	}
+
+	keys, keysOnly, vals := readKeys()
+	expected := []string{"a/long", "a/plain", "a/short"}
+	if !reflect.DeepEqual(keys, expected) || !reflect.DeepEqual(keysOnly, expected) {
+		t.Fatalf("unexpected keys before expiry: %v %v", keys, keysOnly)
+	}
+	if vals["a/short"] != "1" || vals["a/long"] != "2" || vals["a/plain"] != "3" {
+		t.Fatalf("unexpected values: %v", vals)
+	}
+
+	<-time.After(100 * time.Millisecond)
+	keys, keysOnly, _ = readKeys()
+	expected = []string{"a/long", "a/plain"}
+	if !reflect.DeepEqual(keys, expected) || !reflect.DeepEqual(keysOnly, expected) {
+		t.Fatalf("unexpected keys after expiry: %v %v", keys, keysOnly)
+	}
+
+	// the sweeper deletes the expired record in bounded batches and drops
+	// it from the read cache.
+	if err := db.EnableReadCache(nil); err != nil {
+		t.Fatalf("Error enabling read cache: %v", err)
+	}
+	db.readCache.fill(id, []byte("a/short"), []byte("stale"), 0)
+	sweeper := db.StartKvtxSweeper(id, time.Hour, 1, &ChangelogOptions{})
+	var size uint64
+	for i := 0; i < 50; i++ {
+		kvtx := openTestKvtx(t, db, id, READONLY)
+		var err error
+		size, err = kvtx.Size()
+		kvtx.Discard()
+		if err != nil {
+			t.Fatalf("Error getting size: %v", err)
+		}
+		if size == 2 {
+			break
+		}
+		<-time.After(10 * time.Millisecond)
+	}
+	sweeper.Close()
+	if err := sweeper.Err(); err != nil {
+		t.Fatalf("Error sweeping expired keys: %v", err)
+	}
+	if size != 2 {
+		t.Fatalf("expected expired key to be swept: size %d", size)
+	}
+	if stats := db.GetReadCacheStats(); stats.Entries != 0 {
+		t.Fatalf("expected swept key to be dropped from the read cache: %#v", stats)
+	}
+	n, err := db.SweepExpired(id, 0, nil)
+	if err != nil {
+		t.Fatalf("Error sweeping expired keys: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("expected nothing to sweep: %d", n)
+	}
+
+	entries, err := db.ReadChangelog(0, 0)
+	if err != nil {
+		t.Fatalf("Error reading changelog: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Op != "delete" || string(CopyByteSliceFromJs(entries[0].Key)) != "a/short" {
+		t.Fatalf("expected only the sweep in changelog: %#v", entries)
+	}
+}
+
+func TestKvtxCache(t *testing.T) {
+	id, metaID := "testObjectStore", "testCacheMeta"
+	db := openTestDB(t, "test-db-kvtx-cache", func(d *DatabaseUpdate) error {
+		if err := d.CreateObjectStore(id, nil); err != nil {
+			return err
+		}
+		return d.CreateKvtxCacheMetaObjectStore(metaID)
+	})
+	defer db.Close()
+
+	stats := &KvtxCacheStats{}
+	opts := &KvtxCacheOpts{MaxBytes: 10, MaxEntries: 3}
+	openCache := func() *KvtxCache {
+		durTx, err := NewDurableTransaction(db, []string{id, metaID}, READWRITE)
+		if err != nil {
+			t.Fatalf("Error getting durable transaction: %v", err)
+		}
+		kvtx, err := NewKvtxTx(durTx, id)
+		if err != nil {
+			t.Fatalf("Error getting object store: %v", err)
+		}
+		cache, err := NewKvtxCache(kvtx, metaID, opts, stats)
+		if err != nil {
+			t.Fatalf("Error creating cache: %v", err)
+		}
+		return cache
+	}
+	set := func(cache *KvtxCache, key, val string) {
+		if err := cache.Set([]byte(key), []byte(val)); err != nil {
+			t.Fatalf("Error setting key/value: %v", err)
+		}
+		// access times have millisecond resolution
+		<-time.After(5 * time.Millisecond)
+	}
+	get := func(cache *KvtxCache, key string) bool {
+		_, found, err := cache.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("Error getting value: %v", err)
+		}
+		<-time.After(5 * time.Millisecond)
+		return found
+	}
+	expectUsage := func(cache *KvtxCache, expBytes, expEntries uint64) {
+		b, n, err := cache.Usage()
+		if err != nil {
+			t.Fatalf("Error getting cache usage: %v", err)
+		}
+		if b != expBytes || n != expEntries {
+			t.Fatalf("unexpected usage: %d bytes %d entries", b, n)
+		}
+	}
+
+	cache := openCache()
+	set(cache, "a", "111")
+	set(cache, "b", "222")
+	set(cache, "c", "333")
+	expectUsage(cache, 9, 3)
+	// touch a so b is least recently used
+	if !get(cache, "a") {
+		t.Fatal("expected a to be cached")
+	}
+	if err := cache.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+
+	// entry budget evicts b
+	cache = openCache()
+	set(cache, "d", "4")
+	if get(cache, "b") {
+		t.Fatal("expected b to be evicted")
+	}
+	expectUsage(cache, 7, 3)
+	// byte budget evicts c and a
+	set(cache, "e", "5555555")
+	if get(cache, "c") || get(cache, "a") || !get(cache, "d") || !get(cache, "e") {
+		t.Fatal("unexpected entries after byte eviction")
+	}
+	expectUsage(cache, 8, 2)
+	// overwriting adjusts the size
+	set(cache, "e", "55")
+	expectUsage(cache, 3, 2)
+	if err := cache.Delete([]byte("d")); err != nil {
+		t.Fatalf("Error deleting key: %v", err)
+	}
+	expectUsage(cache, 2, 1)
+	if err := cache.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+
+	counters := stats.Snapshot()
+	expected := KvtxCacheCounters{Hits: 3, Misses: 3, Evictions: 3}
+	if counters != expected {
+		t.Fatalf("unexpected counters: %#v", counters)
+	}
+
+	// totals which are out of sync with the entries are clamped at zero.
+	cache = openCache()
+	if err := cache.writeTotals(&kvtxCacheTotals{bytes: 1}); err != nil {
+		t.Fatalf("Error writing cache totals: %v", err)
+	}
+	if err := cache.Delete([]byte("e")); err != nil {
+		t.Fatalf("Error deleting key: %v", err)
+	}
+	expectUsage(cache, 0, 0)
+	cache.GetKvtx().Discard()
+}
+
+func TestReadCache(t *testing.T) {
+	id := "testObjectStore"
+	open := func() *Database {
+		return openTestDB(t, "test-db-read-cache", func(d *DatabaseUpdate) error {
+			return d.CreateObjectStore(id, nil)
+		})
+	}
+	// db2 simulates another tab with its own BroadcastChannel.
+	db1, db2 := open(), open()
+	defer db1.Close()
+	defer db2.Close()
+	if err := db1.EnableReadCache(&ReadCacheOpts{MaxEntries: 2}); err != nil {
+		t.Fatalf("Error enabling read cache: %v", err)
+	}
+	defer db1.DisableReadCache()
+	if err := db2.EnableChangeFeed(); err != nil {
+		t.Fatalf("Error enabling change feed: %v", err)
+	}
+	defer db2.DisableChangeFeed()
+
+	write := func(db *Database, kvs ...string) {
+		kvtx := openTestKvtx(t, db, id, READWRITE)
+		for i := 0; i < len(kvs); i += 2 {
+			if err := kvtx.Set([]byte(kvs[i]), []byte(kvs[i+1])); err != nil {
+				t.Fatalf("Error setting key/value: %v", err)
+			}
+		}
+		if err := kvtx.Commit(); err != nil {
+			t.Fatalf("Error committing transaction: %v", err)
+		}
+	}
+	read := func(key string) string {
+		kvtx := openTestKvtx(t, db1, id, READONLY)
+		defer kvtx.Discard()
+		val, _, err := kvtx.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("Error getting value: %v", err)
+		}
+		return string(val)
+	}
+
+	// committed writes are written through, evicting beyond the limit
+	write(db1, "a", "1", "b", "2", "c", "3")
+	stats := db1.GetReadCacheStats()
+	if stats.Entries != 2 || stats.Bytes != 2 || stats.Evictions != 1 {
+		t.Fatalf("unexpected stats after write: %#v", stats)
+	}
+	if read("b") != "2" || read("c") != "3" || read("a") != "1" {
+		t.Fatal("unexpected values")
+	}
+	stats = db1.GetReadCacheStats()
+	if stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats after read: %#v", stats)
+	}
+
+	// hits are served without reading IndexedDB
+	txn, err := db1.Transaction([]string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting transaction: %v", err)
+	}
+	txn.GetJsValue().Call("objectStore", id).Call("put", CopyByteSliceToJs([]byte("x")), CopyByteSliceToJs([]byte("a")))
+	if err := txn.WaitComplete(); err != nil {
+		t.Fatalf("Error waiting for transaction: %v", err)
+	}
+	if read("a") != "1" {
+		t.Fatal("expected cached value")
+	}
+
+	// reads in a READWRITE transaction bypass the cache
+	kvtx := openTestKvtx(t, db1, id, READWRITE)
+	val, _, err := kvtx.Get([]byte("a"))
+	if err != nil {
+		t.Fatalf("Error getting value: %v", err)
+	}
+	if string(val) != "x" {
+		t.Fatalf("expected uncached value in READWRITE transaction: %q", val)
+	}
+	if err := kvtx.Set([]byte("b"), []byte("pending")); err != nil {
+		t.Fatalf("Error setting key/value: %v", err)
+	}
+	val, _, err = kvtx.Get([]byte("b"))
+	if err != nil {
+		t.Fatalf("Error getting value: %v", err)
+	}
+	if string(val) != "pending" {
+		t.Fatalf("expected pending value in written store: %q", val)
+	}
+	kvtx.Discard()
+	if read("b") != "2" {
+		t.Fatal("discarded write changed the cache")
+	}
+
+	// changes from other tabs invalidate the cache
+	write(db2, "a", "remote")
+	deadline := time.Now().Add(2 * time.Second)
+	for read("a") != "remote" {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for invalidation")
+		}
+		<-time.After(10 * time.Millisecond)
+	}
+	if stats := db1.GetReadCacheStats(); stats.Invalidations == 0 {
+		t.Fatalf("expected invalidation: %#v", stats)
+	}
+
+	// disabling the cache disables the change feed only if it enabled it
+	db1.DisableReadCache()
+	if db1.feed != nil {
+		t.Fatal("expected change feed enabled by the read cache to be disabled")
+	}
+	if err := db2.EnableReadCache(nil); err != nil {
+		t.Fatalf("Error enabling read cache: %v", err)
+	}
+	db2.DisableReadCache()
+	if db2.feed == nil {
+		t.Fatal("expected change feed enabled before the read cache to be kept")
+	}
+	if err := db1.EnableReadCache(nil); err != nil {
+		t.Fatalf("Error enabling read cache: %v", err)
+	}
+	w, err := db1.Watch(id, nil)
+	if err != nil {
+		t.Fatalf("Error watching object store: %v", err)
+	}
+	db1.DisableReadCache()
+	if db1.feed == nil {
+		t.Fatal("expected change feed with watchers to be kept")
+	}
+	w.Close()
+}
+
+func TestCounter(t *testing.T) {
+	id := "testObjectStore"
+	open := func() *Database {
+		return openTestDB(t, "test-db-counter", func(d *DatabaseUpdate) error {
+			return d.CreateObjectStore(id, nil)
+		})
+	}
+	db1, db2 := open(), open()
+	defer db1.Close()
+	defer db2.Close()
+
+	add := func(db *Database, delta int64, commit bool) (int64, error) {
+		durTx, err := NewDurableTransaction(db, []string{id}, READWRITE)
+		if err != nil {
+			return 0, err
+		}
+		store, err := durTx.GetObjectStore(id)
+		if err != nil {
+			return 0, err
+		}
+		val, err := NewCounter(store, "counter").Add(delta)
+		if err != nil {
+			durTx.Abort()
+			return 0, err
+		}
+		if !commit {
+			durTx.Abort()
+			return val, nil
+		}
+		return val, durTx.Commit()
+	}
+	if val, err := add(db1, 5, true); err != nil || val != 5 {
+		t.Fatalf("unexpected add result: %v %v", val, err)
+	}
+	if val, err := add(db1, -2, true); err != nil || val != 3 {
+		t.Fatalf("unexpected add result: %v %v", val, err)
+	}
+	// aborted updates are rolled back
+	if val, err := add(db1, 10, false); err != nil || val != 13 {
+		t.Fatalf("unexpected add result: %v %v", val, err)
+	}
+
+	// concurrent updates from two connections are not lost
+	errCh := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		db := db1
+		if i%2 == 1 {
+			db = db2
+		}
+		go func() {
+			_, err := add(db, 1, true)
+			errCh <- err
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatalf("Error adding to counter: %v", err)
+		}
+	}
+	durTx, err := NewDurableTransaction(db1, []string{id}, READONLY)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	store, err := durTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	val, err := NewCounter(store, "counter").Get()
+	durTx.Abort()
+	if err != nil {
+		t.Fatalf("Error getting counter: %v", err)
+	}
+	if val != 23 {
+		t.Fatalf("expected counter 23: %d", val)
+	}
+
+	// waiting for a request within an atomic update fails instead of deadlocking.
+	roTx, err := NewDurableTransaction(db2, []string{id}, READONLY)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	roStore, err := roTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	durTx, err = NewDurableTransaction(db1, []string{id}, READWRITE)
+	if err != nil {
+		t.Fatalf("Error getting durable transaction: %v", err)
+	}
+	store, err = durTx.GetObjectStore(id)
+	if err != nil {
+		t.Fatalf("Error getting object store: %v", err)
+	}
+	_, err = store.UpdateAtomic("blocking", func(cur js.Value) (js.Value, error) {
+		_, err := roStore.Get("counter")
+		return js.ValueOf(1), err
+	})
+	durTx.Abort()
+	roTx.Abort()
+	if !errors.Is(err, ErrBlockingInAtomicUpdate) {
+		t.Fatalf("expected ErrBlockingInAtomicUpdate: %v", err)
+	}
+
+	// sequences in two connections hand out unique ids
+	seq1 := NewSequence(db1, id, "seq", 3)
+	seq2 := NewSequence(db2, id, "seq", 3)
+	seen := make(map[int64]bool)
+	var last1, last2 int64
+	for i := 0; i < 10; i++ {
+		id1, err := seq1.Next()
+		if err != nil {
+			t.Fatalf("Error getting next id: %v", err)
+		}
+		id2, err := seq2.Next()
+		if err != nil {
+			t.Fatalf("Error getting next id: %v", err)
+		}
+		if seen[id1] || seen[id2] || id1 <= last1 || id2 <= last2 {
+			t.Fatalf("duplicate or decreasing ids: %d %d", id1, id2)
+		}
+		seen[id1], seen[id2] = true, true
+		last1, last2 = id1, id2
+	}
+	if !seen[1] {
+		t.Fatal("expected the first id to be 1")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	id, deadID := "jobs", "deadJobs"
+	open := func() *Database {
+		return openTestDB(t, "test-db-queue", func(d *DatabaseUpdate) error {
+			if err := d.CreateQueueObjectStore(id); err != nil {
+				return err
+			}
+			return d.CreateQueueObjectStore(deadID)
+		})
+	}
+	// db2 simulates another tab.
+	db1, db2 := open(), open()
+	defer db1.Close()
+	defer db2.Close()
+
+	opts := &QueueOpts{MaxAttempts: 2, DeadLetterStoreID: deadID}
+	withQueue := func(db *Database, cb func(q *Queue)) {
+		durTx, err := NewDurableTransaction(db, []string{id, deadID}, READWRITE)
+		if err != nil {
+			t.Fatalf("Error getting durable transaction: %v", err)
+		}
+		q, err := NewQueue(durTx, id, opts)
+		if err != nil {
+			t.Fatalf("Error creating queue: %v", err)
+		}
+		cb(q)
+		if err := durTx.Commit(); err != nil {
+			t.Fatalf("Error committing transaction: %v", err)
+		}
+	}
+	lease := func(db *Database, n int, timeout time.Duration) []*Job {
+		var jobs []*Job
+		withQueue(db, func(q *Queue) {
+			var err error
+			jobs, err = q.Lease(n, timeout)
+			if err != nil {
+				t.Fatalf("Error leasing jobs: %v", err)
+			}
+		})
+		return jobs
+	}
+	payloads := func(jobs []*Job) []string {
+		var out []string
+		for _, job := range jobs {
+			out = append(out, string(job.Payload))
+		}
+		return out
+	}
+
+	withQueue(db1, func(q *Queue) {
+		for _, payload := range []string{"a", "b", "c"} {
+			if _, err := q.Enqueue([]byte(payload)); err != nil {
+				t.Fatalf("Error enqueueing job: %v", err)
+			}
+		}
+	})
+
+	// leased jobs are hidden from other consumers
+	jobs1 := lease(db1, 2, time.Hour)
+	jobs2 := lease(db2, 2, time.Hour)
+	if !reflect.DeepEqual(payloads(jobs1), []string{"a", "b"}) || !reflect.DeepEqual(payloads(jobs2), []string{"c"}) {
+		t.Fatalf("unexpected leased jobs: %v %v", payloads(jobs1), payloads(jobs2))
+	}
+	if jobs1[0].ID >= jobs1[1].ID || jobs1[0].Attempts != 1 || jobs1[0].LeaseExpires.IsZero() {
+		t.Fatalf("unexpected job: %#v", jobs1[0])
+	}
+	if jobs := lease(db1, 1, time.Hour); len(jobs) != 0 {
+		t.Fatalf("expected no visible jobs: %v", payloads(jobs))
+	}
+
+	withQueue(db1, func(q *Queue) {
+		if err := q.Ack(jobs1[0]); err != nil {
+			t.Fatalf("Error acknowledging job: %v", err)
+		}
+		if err := q.Nack(jobs1[1], 0, "boom"); err != nil {
+			t.Fatalf("Error returning job: %v", err)
+		}
+		if err := q.Ack(jobs1[0]); !errors.Is(err, ErrLeaseLost) {
+			t.Fatalf("expected lease lost: %v", err)
+		}
+	})
+
+	// nacked jobs are retried, then dead-lettered
+	retried := lease(db2, 2, time.Hour)
+	if !reflect.DeepEqual(payloads(retried), []string{"b"}) || retried[0].Attempts != 2 || retried[0].LastError != "boom" {
+		t.Fatalf("unexpected retried jobs: %#v", retried)
+	}
+	withQueue(db2, func(q *Queue) {
+		if err := q.Nack(retried[0], 0, "boom again"); err != nil {
+			t.Fatalf("Error returning job: %v", err)
+		}
+		dead, err := q.DeadLetters(0)
+		if err != nil {
+			t.Fatalf("Error reading dead letters: %v", err)
+		}
+		if len(dead) != 1 || string(dead[0].Payload) != "b" || dead[0].ID != jobs1[1].ID || dead[0].LastError != "boom again" {
+			t.Fatalf("unexpected dead letters: %#v", dead)
+		}
+	})
+
+	// expired leases become visible again
+	withQueue(db2, func(q *Queue) {
+		if err := q.Ack(jobs2[0]); err != nil {
+			t.Fatalf("Error acknowledging job: %v", err)
+		}
+		if _, err := q.Enqueue([]byte("d")); err != nil {
+			t.Fatalf("Error enqueueing job: %v", err)
+		}
+	})
+	short := lease(db1, 1, 20*time.Millisecond)
+	<-time.After(50 * time.Millisecond)
+	again := lease(db2, 1, time.Hour)
+	if len(short) != 1 || len(again) != 1 || again[0].ID != short[0].ID {
+		t.Fatalf("expected expired lease to be leased again: %v %v", payloads(short), payloads(again))
+	}
+	withQueue(db1, func(q *Queue) {
+		if err := q.Ack(short[0]); !errors.Is(err, ErrLeaseLost) {
+			t.Fatalf("expected lease lost: %v", err)
+		}
+		if err := q.Ack(again[0]); err != nil {
+			t.Fatalf("Error acknowledging job: %v", err)
+		}
+		n, err := q.Len()
+		if err != nil {
+			t.Fatalf("Error getting queue length: %v", err)
+		}
+		if n != 0 {
+			t.Fatalf("expected empty queue: %d", n)
+		}
+	})
+}
@@ -0,0 +1,240 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// taggedValue is a self-describing JSON representation of a js value.
+//
+// Unlike JSON.stringify, this preserves binary data, dates, and the
+// distinction between null and undefined.
+type taggedValue struct {
+	// T is the type tag.
+	T string `json:"t"`
+	// V is the encoded value, if any.
+	V json.RawMessage `json:"v,omitempty"`
+}
+
+const (
+	taggedUndefined = "u"
+	taggedNull      = "n"
+	taggedBool      = "b"
+	taggedNumber    = "f"
+	taggedString    = "s"
+	taggedBinary    = "bin"
+	taggedDate      = "d"
+	taggedArray     = "a"
+	taggedObject    = "o"
+	taggedMap       = "m"
+)
+
+// marshalTaggedJs encodes a js value to the tagged JSON representation.
+func marshalTaggedJs(val js.Value) ([]byte, error) {
+	tv, err := encodeTaggedJs(val)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tv)
+}
+
+// unmarshalTaggedJs decodes a js value from the tagged JSON representation.
+func unmarshalTaggedJs(data []byte) (js.Value, error) {
+	tv := &taggedValue{}
+	if err := json.Unmarshal(data, tv); err != nil {
+		return js.Undefined(), err
+	}
+	return decodeTaggedJs(tv)
+}
+
+// encodeTaggedJs encodes a js value to a taggedValue.
+func encodeTaggedJs(val js.Value) (*taggedValue, error) {
+	global := js.Global()
+	mustMarshal := func(t string, v interface{}) (*taggedValue, error) {
+		dat, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return &taggedValue{T: t, V: dat}, nil
+	}
+
+	switch val.Type() {
+	case js.TypeUndefined:
+		return &taggedValue{T: taggedUndefined}, nil
+	case js.TypeNull:
+		return &taggedValue{T: taggedNull}, nil
+	case js.TypeBoolean:
+		return mustMarshal(taggedBool, val.Bool())
+	case js.TypeNumber:
+		// JSON cannot represent NaN or Inf: store numbers as strings.
+		return mustMarshal(taggedNumber, strconv.FormatFloat(val.Float(), 'g', -1, 64))
+	case js.TypeString:
+		return mustMarshal(taggedString, val.String())
+	case js.TypeObject:
+	default:
+		return nil, errors.Errorf("cannot encode js value of type %s", val.Type().String())
+	}
+
+	switch {
+	case val.InstanceOf(global.Get("ArrayBuffer")):
+		return mustMarshal(taggedBinary, base64.StdEncoding.EncodeToString(
+			CopyByteSliceFromJs(global.Get("Uint8Array").New(val)),
+		))
+	case global.Get("ArrayBuffer").Call("isView", val).Bool():
+		view := global.Get("Uint8Array").New(
+			val.Get("buffer"),
+			val.Get("byteOffset"),
+			val.Get("byteLength"),
+		)
+		return mustMarshal(taggedBinary, base64.StdEncoding.EncodeToString(CopyByteSliceFromJs(view)))
+	case val.InstanceOf(global.Get("Date")):
+		return mustMarshal(taggedDate, val.Call("getTime").Float())
+	case global.Get("Array").Call("isArray", val).Bool():
+		n := val.Length()
+		out := make([]*taggedValue, n)
+		for i := 0; i < n; i++ {
+			ev, err := encodeTaggedJs(val.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = ev
+		}
+		return mustMarshal(taggedArray, out)
+	case val.InstanceOf(global.Get("Map")):
+		entries := global.Get("Array").Call("from", val.Call("entries"))
+		n := entries.Length()
+		out := make([][2]*taggedValue, n)
+		for i := 0; i < n; i++ {
+			ent := entries.Index(i)
+			k, err := encodeTaggedJs(ent.Index(0))
+			if err != nil {
+				return nil, err
+			}
+			v, err := encodeTaggedJs(ent.Index(1))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = [2]*taggedValue{k, v}
+		}
+		return mustMarshal(taggedMap, out)
+	case val.InstanceOf(global.Get("Blob")):
+		return nil, errors.New("cannot encode Blob values to json")
+	}
+
+	keys := global.Get("Object").Call("keys", val)
+	n := keys.Length()
+	out := make(map[string]*taggedValue, n)
+	for i := 0; i < n; i++ {
+		k := keys.Index(i).String()
+		ev, err := encodeTaggedJs(val.Get(k))
+		if err != nil {
+			return nil, errors.Wrap(err, k)
+		}
+		out[k] = ev
+	}
+	return mustMarshal(taggedObject, out)
+}
+
+// decodeTaggedJs decodes a taggedValue to a js value.
+func decodeTaggedJs(tv *taggedValue) (js.Value, error) {
+	global := js.Global()
+	switch tv.T {
+	case taggedUndefined:
+		return js.Undefined(), nil
+	case taggedNull:
+		return js.Null(), nil
+	case taggedBool:
+		var b bool
+		if err := json.Unmarshal(tv.V, &b); err != nil {
+			return js.Undefined(), err
+		}
+		return js.ValueOf(b), nil
+	case taggedNumber:
+		var s string
+		if err := json.Unmarshal(tv.V, &s); err != nil {
+			return js.Undefined(), err
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return js.Undefined(), err
+		}
+		return js.ValueOf(f), nil
+	case taggedString:
+		var s string
+		if err := json.Unmarshal(tv.V, &s); err != nil {
+			return js.Undefined(), err
+		}
+		return js.ValueOf(s), nil
+	case taggedBinary:
+		var s string
+		if err := json.Unmarshal(tv.V, &s); err != nil {
+			return js.Undefined(), err
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return js.Undefined(), err
+		}
+		return CopyByteSliceToJs(b), nil
+	case taggedDate:
+		var ms float64
+		if err := json.Unmarshal(tv.V, &ms); err != nil {
+			return js.Undefined(), err
+		}
+		return global.Get("Date").New(ms), nil
+	case taggedArray:
+		var elems []*taggedValue
+		if err := json.Unmarshal(tv.V, &elems); err != nil {
+			return js.Undefined(), err
+		}
+		arr := global.Get("Array").New(len(elems))
+		for i, ev := range elems {
+			v, err := decodeTaggedJs(ev)
+			if err != nil {
+				return js.Undefined(), err
+			}
+			arr.SetIndex(i, v)
+		}
+		return arr, nil
+	case taggedMap:
+		var entries [][2]*taggedValue
+		if err := json.Unmarshal(tv.V, &entries); err != nil {
+			return js.Undefined(), err
+		}
+		m := global.Get("Map").New()
+		for _, ent := range entries {
+			k, err := decodeTaggedJs(ent[0])
+			if err != nil {
+				return js.Undefined(), err
+			}
+			v, err := decodeTaggedJs(ent[1])
+			if err != nil {
+				return js.Undefined(), err
+			}
+			m.Call("set", k, v)
+		}
+		return m, nil
+	case taggedObject:
+		var fields map[string]*taggedValue
+		if err := json.Unmarshal(tv.V, &fields); err != nil {
+			return js.Undefined(), err
+		}
+		obj := global.Get("Object").New()
+		for k, fv := range fields {
+			v, err := decodeTaggedJs(fv)
+			if err != nil {
+				return js.Undefined(), errors.Wrap(err, k)
+			}
+			obj.Set(k, v)
+		}
+		return obj, nil
+	default:
+		return js.Undefined(), errors.Errorf("unknown tagged value type: %q", tv.T)
+	}
+}
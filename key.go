@@ -0,0 +1,74 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"math"
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// EncodeKey converts a Go value to a valid IndexedDB key.
+//
+// Valid keys are numbers (except NaN), strings, time.Time, []byte, and
+// slices or arrays of valid keys, which are stored as native array keys.
+// Array keys compare element by element, so slices can be used as composite
+// keys and to query compound key paths.
+func EncodeKey(key interface{}) (js.Value, error) {
+	val, err := EncodeValue(key)
+	if err != nil {
+		return js.Undefined(), err
+	}
+	if err := ValidateKey(val); err != nil {
+		return js.Undefined(), err
+	}
+	return val, nil
+}
+
+// DecodeKey converts an IndexedDB key to a Go value.
+//
+// Numbers are decoded as float64, strings as string, Date as time.Time,
+// binary keys as []byte, and array keys as []interface{}.
+func DecodeKey(key js.Value) (interface{}, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	return decodeGeneric(key)
+}
+
+// ValidateKey checks if a js value is a valid IndexedDB key.
+func ValidateKey(key js.Value) error {
+	global := js.Global()
+	switch key.Type() {
+	case js.TypeNumber:
+		if math.IsNaN(key.Float()) {
+			return errors.New("invalid key: NaN")
+		}
+		return nil
+	case js.TypeString:
+		return nil
+	case js.TypeObject:
+	default:
+		return errors.Errorf("invalid key type: %s", key.Type().String())
+	}
+
+	switch {
+	case key.InstanceOf(global.Get("Date")):
+		if math.IsNaN(key.Call("getTime").Float()) {
+			return errors.New("invalid key: invalid date")
+		}
+		return nil
+	case key.InstanceOf(global.Get("ArrayBuffer")) || global.Get("ArrayBuffer").Call("isView", key).Bool():
+		return nil
+	case global.Get("Array").Call("isArray", key).Bool():
+		for i := 0; i < key.Length(); i++ {
+			if err := ValidateKey(key.Index(i)); err != nil {
+				return errors.Wrapf(err, "index %d", i)
+			}
+		}
+		return nil
+	}
+	return errors.New("invalid key: object is not a date, binary, or array")
+}
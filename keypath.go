@@ -0,0 +1,114 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"encoding/json"
+	"strings"
+	"syscall/js"
+)
+
+// KeyPath is the key path of an object store or index.
+//
+// A nil *KeyPath indicates out-of-line keys.
+type KeyPath struct {
+	// paths is the list of paths.
+	paths []string
+	// compound indicates this is an array key path.
+	compound bool
+}
+
+// NewKeyPath constructs a string key path.
+//
+// An empty path indicates the value itself is the key.
+func NewKeyPath(path string) *KeyPath {
+	return &KeyPath{paths: []string{path}}
+}
+
+// NewCompoundKeyPath constructs an array key path.
+//
+// The key is an array with the values at each path.
+func NewCompoundKeyPath(paths ...string) *KeyPath {
+	return &KeyPath{paths: paths, compound: true}
+}
+
+// IsCompound checks if the key path is an array key path.
+func (k *KeyPath) IsCompound() bool {
+	return k.compound
+}
+
+// GetPaths returns the list of paths.
+// A string key path has a single path.
+func (k *KeyPath) GetPaths() []string {
+	return k.paths
+}
+
+// String returns the key path formatted as a string.
+func (k *KeyPath) String() string {
+	if k == nil {
+		return "<nil>"
+	}
+	if !k.compound {
+		return k.paths[0]
+	}
+	return "[" + strings.Join(k.paths, ",") + "]"
+}
+
+// ToJSValue converts the key path to a js value.
+func (k *KeyPath) ToJSValue() js.Value {
+	if k == nil {
+		return js.Null()
+	}
+	if !k.compound {
+		return js.ValueOf(k.paths[0])
+	}
+	arr := js.Global().Get("Array").New(len(k.paths))
+	for i, p := range k.paths {
+		arr.SetIndex(i, p)
+	}
+	return arr
+}
+
+// MarshalJSON marshals the key path to a string or array of strings.
+func (k *KeyPath) MarshalJSON() ([]byte, error) {
+	if !k.compound {
+		return json.Marshal(k.paths[0])
+	}
+	return json.Marshal(k.paths)
+}
+
+// UnmarshalJSON unmarshals the key path from a string or array of strings.
+func (k *KeyPath) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*k = KeyPath{paths: []string{path}}
+		return nil
+	}
+	var paths []string
+	if err := json.Unmarshal(data, &paths); err != nil {
+		return err
+	}
+	*k = KeyPath{paths: paths, compound: true}
+	return nil
+}
+
+// keyPathFromJs converts a keyPath property to a KeyPath.
+func keyPathFromJs(val js.Value) *KeyPath {
+	switch val.Type() {
+	case js.TypeNull, js.TypeUndefined:
+		return nil
+	case js.TypeString:
+		return NewKeyPath(val.String())
+	}
+	return NewCompoundKeyPath(stringListFromJs(val)...)
+}
+
+// stringListFromJs converts an array or DOMStringList to a string slice.
+func stringListFromJs(val js.Value) []string {
+	out := make([]string, val.Length())
+	for i := range out {
+		out[i] = val.Index(i).String()
+	}
+	return out
+}
@@ -0,0 +1,348 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// KvtxBlobChunkPrefix is the key prefix for blob chunks.
+//
+// Chunks are stored at prefix + uvarint(len(key)) + key + uint64(generation) + uint32(index).
+// Keys with this prefix are reserved for use by KvtxBlobStore.
+const KvtxBlobChunkPrefix = "\xff\xffkvtx-blob/"
+
+// DefaultBlobChunkSize is the default size of a blob chunk.
+const DefaultBlobChunkSize = 256 * 1024
+
+// KvtxBlobStore stores large values as fixed-size chunks in a Kvtx.
+//
+// The blob key holds a manifest with the size, chunk count, checksum, and
+// generation of the chunks. The chunks are written, replaced, and deleted in
+// the same transaction as the manifest, and are committed with Kvtx.Commit.
+//
+// Writing a blob writes the chunks under a new generation and replaces the
+// manifest last, so readers see either the old or the new blob even if the
+// transaction is restarted and commits part of the way through.
+type KvtxBlobStore struct {
+	tx        *Kvtx
+	chunkSize int
+}
+
+// blobManifest is the manifest stored at the blob key.
+type blobManifest struct {
+	// Size is the total size of the blob in bytes.
+	Size int64 `json:"size"`
+	// ChunkSize is the size of each chunk except the last.
+	ChunkSize int `json:"chunkSize"`
+	// Chunks is the number of chunks.
+	Chunks int `json:"chunks"`
+	// Sha256 is the hex sha256 checksum of the blob contents.
+	Sha256 string `json:"sha256"`
+	// Generation identifies the chunks written for this manifest.
+	Generation uint64 `json:"generation"`
+}
+
+// NewKvtxBlobStore constructs a new blob store on a Kvtx.
+//
+// If chunkSize is zero, uses DefaultBlobChunkSize.
+func NewKvtxBlobStore(tx *Kvtx, chunkSize int) *KvtxBlobStore {
+	if chunkSize <= 0 {
+		chunkSize = DefaultBlobChunkSize
+	}
+	return &KvtxBlobStore{tx: tx, chunkSize: chunkSize}
+}
+
+// blobChunkPrefix returns the key prefix for the chunks of all generations of a blob.
+func blobChunkPrefix(key []byte) []byte {
+	out := make([]byte, 0, len(KvtxBlobChunkPrefix)+binary.MaxVarintLen64+len(key)+12)
+	out = append(out, KvtxBlobChunkPrefix...)
+	out = binary.AppendUvarint(out, uint64(len(key)))
+	return append(out, key...)
+}
+
+// blobGenerationPrefix returns the key prefix for the chunks of a generation of a blob.
+func blobGenerationPrefix(key []byte, gen uint64) []byte {
+	return binary.BigEndian.AppendUint64(blobChunkPrefix(key), gen)
+}
+
+// blobChunkKey returns the key for a chunk given the chunk prefix.
+func blobChunkKey(prefix []byte, idx int) []byte {
+	out := make([]byte, len(prefix), len(prefix)+4)
+	copy(out, prefix)
+	return binary.BigEndian.AppendUint32(out, uint32(idx))
+}
+
+// deleteChunks deletes the chunks of all generations of a blob other than keep.
+//
+// keep is the prefix of the generation to keep, nil to delete all chunks.
+func (b *KvtxBlobStore) deleteChunks(key, keep []byte) error {
+	prefix := blobChunkPrefix(key)
+	// the chunk prefix always has an upper bound: KvtxBlobChunkPrefix is not all 0xff.
+	end := prefixUpperBound(prefix)
+	if keep == nil {
+		return b.tx.objStore.Delete(Bound(prefix, end, false, true))
+	}
+	if err := b.tx.objStore.Delete(Bound(prefix, keep, false, true)); err != nil {
+		return err
+	}
+	if keepEnd := prefixUpperBound(keep); bytes.Compare(keepEnd, end) < 0 {
+		return b.tx.objStore.Delete(Bound(keepEnd, end, false, true))
+	}
+	return nil
+}
+
+// readManifest reads the manifest for a blob.
+func (b *KvtxBlobStore) readManifest(key []byte) (*blobManifest, bool, error) {
+	data, found, err := b.tx.Get(key)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	m := &blobManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, true, errors.Wrap(ErrBlobCorrupt, err.Error())
+	}
+	if m.Size < 0 || m.ChunkSize <= 0 || m.Chunks < 0 ||
+		int64(m.Chunks) != (m.Size+int64(m.ChunkSize)-1)/int64(m.ChunkSize) {
+		return nil, true, errors.Wrap(ErrBlobCorrupt, "invalid manifest")
+	}
+	return m, true, nil
+}
+
+// Size returns the size of a blob.
+func (b *KvtxBlobStore) Size(key []byte) (size int64, found bool, err error) {
+	if len(key) == 0 {
+		return 0, false, ErrEmptyKey
+	}
+	m, found, err := b.readManifest(key)
+	if err != nil || !found {
+		return 0, found, err
+	}
+	return m.Size, true, nil
+}
+
+// Set writes a blob from a reader, returning the number of bytes written.
+func (b *KvtxBlobStore) Set(key []byte, r io.Reader) (int64, error) {
+	w, err := b.OpenWriter(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// Delete deletes a blob and all of its chunks.
+// Not found should not return an error.
+func (b *KvtxBlobStore) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	// delete the manifest first so it never refers to deleted chunks.
+	if err := b.tx.Delete(key); err != nil {
+		return err
+	}
+	return b.deleteChunks(key, nil)
+}
+
+// OpenWriter opens a writer for a blob, replacing any existing blob.
+//
+// The blob is not visible until Close is called.
+// Chunks are written as the buffer fills, under a new generation.
+func (b *KvtxBlobStore) OpenWriter(key []byte) (*KvtxBlobWriter, error) {
+	if len(key) == 0 {
+		return nil, ErrEmptyKey
+	}
+	var genBuf [8]byte
+	if _, err := rand.Read(genBuf[:]); err != nil {
+		return nil, err
+	}
+	gen := binary.BigEndian.Uint64(genBuf[:])
+	return &KvtxBlobWriter{
+		b:      b,
+		key:    append([]byte(nil), key...),
+		gen:    gen,
+		prefix: blobGenerationPrefix(key, gen),
+		buf:    make([]byte, 0, b.chunkSize),
+		hash:   sha256.New(),
+	}, nil
+}
+
+// OpenReader opens a reader for a blob.
+//
+// Returns ErrBlobChecksum from Read if the contents do not match the checksum.
+func (b *KvtxBlobStore) OpenReader(key []byte) (r *KvtxBlobReader, found bool, err error) {
+	if len(key) == 0 {
+		return nil, false, ErrEmptyKey
+	}
+	m, found, err := b.readManifest(key)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	return &KvtxBlobReader{
+		b:        b,
+		manifest: m,
+		prefix:   blobGenerationPrefix(key, m.Generation),
+		hash:     sha256.New(),
+	}, true, nil
+}
+
+// KvtxBlobWriter writes a blob in chunks.
+type KvtxBlobWriter struct {
+	b   *KvtxBlobStore
+	key []byte
+	gen uint64
+	// prefix is the key prefix for the chunks of the generation.
+	prefix []byte
+	buf    []byte
+	hash   hash.Hash
+	size   int64
+	chunks int
+	closed bool
+}
+
+// Write writes data to the blob.
+func (w *KvtxBlobWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrBlobClosed
+	}
+	var n int
+	for len(p) != 0 {
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush writes the buffered chunk.
+func (w *KvtxBlobWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	// Set copies the data: the buffer can be reused.
+	if err := w.b.tx.Set(blobChunkKey(w.prefix, w.chunks), w.buf); err != nil {
+		return err
+	}
+	_, _ = w.hash.Write(w.buf)
+	w.size += int64(len(w.buf))
+	w.chunks++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close writes the final chunk and the manifest.
+// Then deletes the chunks of the previous blob and of any unfinished writes.
+func (w *KvtxBlobWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	w.closed = true
+	data, err := json.Marshal(&blobManifest{
+		Size:       w.size,
+		ChunkSize:  w.b.chunkSize,
+		Chunks:     w.chunks,
+		Sha256:     hex.EncodeToString(w.hash.Sum(nil)),
+		Generation: w.gen,
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.b.tx.Set(w.key, data); err != nil {
+		return err
+	}
+	return w.b.deleteChunks(w.key, w.prefix)
+}
+
+// KvtxBlobReader reads a blob chunk by chunk.
+type KvtxBlobReader struct {
+	b        *KvtxBlobStore
+	manifest *blobManifest
+	prefix   []byte
+	hash     hash.Hash
+	// chunk is the current chunk, reused between chunks.
+	chunk []byte
+	// pos is the position in chunk
+	pos int
+	// next is the index of the next chunk to read
+	next int
+	err  error
+}
+
+// Size returns the size of the blob.
+func (r *KvtxBlobReader) Size() int64 {
+	return r.manifest.Size
+}
+
+// Read reads data from the blob.
+func (r *KvtxBlobReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r.pos == len(r.chunk) {
+		if err := r.readChunk(); err != nil {
+			r.err = err
+			return 0, err
+		}
+	}
+	n := copy(p, r.chunk[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// readChunk reads the next chunk, returning io.EOF after verifying the checksum.
+func (r *KvtxBlobReader) readChunk() error {
+	m := r.manifest
+	if r.next == m.Chunks {
+		sum, err := hex.DecodeString(m.Sha256)
+		if err != nil || !bytes.Equal(sum, r.hash.Sum(nil)) {
+			return ErrBlobChecksum
+		}
+		return io.EOF
+	}
+	data, found, err := r.b.tx.GetInto(blobChunkKey(r.prefix, r.next), r.chunk)
+	if err != nil {
+		return err
+	}
+	expected := int64(m.ChunkSize)
+	if r.next == m.Chunks-1 {
+		expected = m.Size - int64(m.ChunkSize)*int64(m.Chunks-1)
+	}
+	if !found || int64(len(data)) != expected {
+		return errors.Wrapf(ErrBlobCorrupt, "chunk %d missing or truncated", r.next)
+	}
+	_, _ = r.hash.Write(data)
+	r.chunk, r.pos = data, 0
+	r.next++
+	return nil
+}
+
+// _ is a type assertion
+var (
+	_ io.WriteCloser = ((*KvtxBlobWriter)(nil))
+	_ io.Reader      = ((*KvtxBlobReader)(nil))
+)
@@ -0,0 +1,328 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"bytes"
+	"sync/atomic"
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// KvtxCacheAtimeIndex is the name of the index on the last access time in a cache metadata store.
+const KvtxCacheAtimeIndex = "kvtx-cache-atime"
+
+const (
+	// kvtxCacheTotalsKey is the key of the totals record in the metadata store.
+	// String keys sort before the binary keys of the cache entries.
+	kvtxCacheTotalsKey = "totals"
+	// kvtxCacheEvictPageSize is the number of entries read at a time when evicting.
+	kvtxCacheEvictPageSize = 32
+)
+
+// CreateKvtxCacheMetaObjectStore creates the metadata object store for a KvtxCache.
+// Does nothing if it already exists.
+//
+// The store tracks the size and last access time of each cache entry.
+func (d *DatabaseUpdate) CreateKvtxCacheMetaObjectStore(metaStoreID string) error {
+	if d.ContainsObjectStore(metaStoreID) {
+		return nil
+	}
+	if err := d.CreateObjectStore(metaStoreID, nil); err != nil {
+		return err
+	}
+	_, err := d.CreateIndex(metaStoreID, KvtxCacheAtimeIndex, NewKeyPath("atime"), nil)
+	return err
+}
+
+// KvtxCacheOpts are the budget options for a KvtxCache.
+type KvtxCacheOpts struct {
+	// MaxBytes is the maximum total size of the values, zero for no limit.
+	MaxBytes uint64
+	// MaxEntries is the maximum number of entries, zero for no limit.
+	MaxEntries uint64
+}
+
+// KvtxCacheCounters is a snapshot of the cache counters.
+type KvtxCacheCounters struct {
+	// Hits is the number of Get calls which found the key.
+	Hits uint64
+	// Misses is the number of Get calls which did not find the key.
+	Misses uint64
+	// Evictions is the number of entries evicted to stay within the budget.
+	Evictions uint64
+}
+
+// KvtxCacheStats counts cache hits, misses, and evictions.
+//
+// Share a KvtxCacheStats between the KvtxCache of each transaction to count
+// across transactions. Safe for concurrent use.
+type KvtxCacheStats struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// Snapshot returns the current counter values.
+func (s *KvtxCacheStats) Snapshot() KvtxCacheCounters {
+	return KvtxCacheCounters{
+		Hits:      s.hits.Load(),
+		Misses:    s.misses.Load(),
+		Evictions: s.evictions.Load(),
+	}
+}
+
+// KvtxCache is a bounded cache on top of Kvtx with least-recently-used eviction.
+//
+// The size and last access time of each entry are tracked in a metadata
+// object store created with DatabaseUpdate.CreateKvtxCacheMetaObjectStore.
+// The transaction must include both the Kvtx object store and the metadata
+// object store. Get updates the access time if the transaction is READWRITE.
+type KvtxCache struct {
+	tx    *Kvtx
+	meta  *DurableObjectStore
+	opts  KvtxCacheOpts
+	stats *KvtxCacheStats
+}
+
+// NewKvtxCache constructs a KvtxCache with a Kvtx and metadata object store.
+//
+// opts and stats are optional.
+func NewKvtxCache(tx *Kvtx, metaStoreID string, opts *KvtxCacheOpts, stats *KvtxCacheStats) (*KvtxCache, error) {
+	meta, err := tx.txn.GetObjectStore(metaStoreID)
+	if err != nil {
+		return nil, err
+	}
+	if stats == nil {
+		stats = &KvtxCacheStats{}
+	}
+	c := &KvtxCache{tx: tx, meta: meta, stats: stats}
+	if opts != nil {
+		c.opts = *opts
+	}
+	return c, nil
+}
+
+// GetKvtx returns the underlying Kvtx.
+func (c *KvtxCache) GetKvtx() *Kvtx {
+	return c.tx
+}
+
+// GetStats returns the cache counters.
+func (c *KvtxCache) GetStats() *KvtxCacheStats {
+	return c.stats
+}
+
+// kvtxCacheTotals is the total size and number of the cache entries.
+type kvtxCacheTotals struct {
+	bytes   uint64
+	entries uint64
+}
+
+// remove subtracts a removed entry from the totals, clamping at zero.
+func (t *kvtxCacheTotals) remove(size uint64) {
+	if size > t.bytes {
+		size = t.bytes
+	}
+	t.bytes -= size
+	if t.entries != 0 {
+		t.entries--
+	}
+}
+
+// jsUint64 converts a stored js number to uint64, clamping negative values at zero.
+func jsUint64(val js.Value) uint64 {
+	if val.Type() != js.TypeNumber || val.Float() < 0 {
+		return 0
+	}
+	return uint64(val.Float())
+}
+
+// readTotals reads the totals record.
+func (c *KvtxCache) readTotals() (*kvtxCacheTotals, error) {
+	val, err := c.meta.Get(kvtxCacheTotalsKey)
+	if err != nil {
+		return nil, err
+	}
+	totals := &kvtxCacheTotals{}
+	if val.Type() == js.TypeObject {
+		totals.bytes = jsUint64(val.Get("bytes"))
+		totals.entries = jsUint64(val.Get("entries"))
+	}
+	return totals, nil
+}
+
+// writeTotals writes the totals record.
+func (c *KvtxCache) writeTotals(totals *kvtxCacheTotals) error {
+	val := js.Global().Get("Object").New()
+	val.Set("bytes", float64(totals.bytes))
+	val.Set("entries", float64(totals.entries))
+	_, err := c.meta.Put(val, kvtxCacheTotalsKey)
+	return err
+}
+
+// writeMeta writes the metadata record for an entry.
+func (c *KvtxCache) writeMeta(key []byte, size int) error {
+	val := js.Global().Get("Object").New()
+	val.Set("size", size)
+	val.Set("atime", jsNow())
+	_, err := c.meta.Put(val, key)
+	return err
+}
+
+// readSize reads the size of an entry from the metadata.
+func (c *KvtxCache) readSize(key []byte) (size uint64, found bool, err error) {
+	val, err := c.meta.Get(key)
+	if err != nil || val.Type() != js.TypeObject {
+		return 0, false, err
+	}
+	return jsUint64(val.Get("size")), true, nil
+}
+
+// Usage returns the total size and number of the cache entries.
+func (c *KvtxCache) Usage() (bytes, entries uint64, err error) {
+	totals, err := c.readTotals()
+	if err != nil {
+		return 0, 0, err
+	}
+	return totals.bytes, totals.entries, nil
+}
+
+// Get returns the value for a key, marking it as recently used.
+//
+// The access time is only updated if the transaction is READWRITE: entries
+// read only in READONLY transactions are evicted as if they were not used.
+func (c *KvtxCache) Get(key []byte) (data []byte, found bool, err error) {
+	data, found, err = c.tx.Get(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if !found {
+		c.stats.misses.Add(1)
+		return nil, false, nil
+	}
+	c.stats.hits.Add(1)
+	if c.tx.txn.GetMode() == READWRITE {
+		if err := c.writeMeta(key, len(data)); err != nil {
+			return nil, true, err
+		}
+	}
+	return data, true, nil
+}
+
+// Set sets the value of a key, evicting least-recently-used entries to stay
+// within the budget.
+//
+// The evictions are made in the same transaction as the write. If the value
+// alone exceeds the budget, all other entries are evicted.
+// This will not be committed until Commit is called.
+func (c *KvtxCache) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	totals, err := c.readTotals()
+	if err != nil {
+		return err
+	}
+	prevSize, found, err := c.readSize(key)
+	if err != nil {
+		return err
+	}
+	if found {
+		totals.remove(prevSize)
+	}
+	totals.entries++
+	totals.bytes += uint64(len(value))
+
+	if err := c.tx.Set(key, value); err != nil {
+		return err
+	}
+	if err := c.writeMeta(key, len(value)); err != nil {
+		return err
+	}
+	if err := c.evict(key, totals); err != nil {
+		return err
+	}
+	return c.writeTotals(totals)
+}
+
+// overBudget checks if the totals exceed the budget.
+func (c *KvtxCache) overBudget(totals *kvtxCacheTotals) bool {
+	return (c.opts.MaxBytes != 0 && totals.bytes > c.opts.MaxBytes) ||
+		(c.opts.MaxEntries != 0 && totals.entries > c.opts.MaxEntries)
+}
+
+// evict deletes the least-recently-used entries other than keep until the
+// totals are within the budget.
+func (c *KvtxCache) evict(keep []byte, totals *kvtxCacheTotals) error {
+	idx := c.meta.Index(KvtxCacheAtimeIndex)
+	for c.overBudget(totals) {
+		keys, vals, err := idx.GetAllEntries(nil, kvtxCacheEvictPageSize)
+		if err != nil {
+			return err
+		}
+		var evicted bool
+		for i := 0; i < len(keys) && c.overBudget(totals); i++ {
+			key, err := CopyBinaryFromJs(keys[i])
+			if err != nil {
+				return errors.Wrap(err, "cache metadata key")
+			}
+			if bytes.Equal(key, keep) {
+				continue
+			}
+			if err := c.tx.Delete(key); err != nil {
+				return err
+			}
+			if err := c.meta.Delete(key); err != nil {
+				return err
+			}
+			totals.remove(jsUint64(vals[i].Get("size")))
+			c.stats.evictions.Add(1)
+			evicted = true
+		}
+		if !evicted {
+			// only the kept entry remains.
+			return nil
+		}
+	}
+	return nil
+}
+
+// Delete deletes a key.
+// This will not be committed until Commit is called.
+func (c *KvtxCache) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	size, found, err := c.readSize(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return c.tx.Delete(key)
+	}
+	totals, err := c.readTotals()
+	if err != nil {
+		return err
+	}
+	totals.remove(size)
+	if err := c.tx.Delete(key); err != nil {
+		return err
+	}
+	if err := c.meta.Delete(key); err != nil {
+		return err
+	}
+	return c.writeTotals(totals)
+}
+
+// Commit commits the transaction to storage.
+func (c *KvtxCache) Commit() error {
+	return c.tx.Commit()
+}
+
+// Discard cancels the transaction.
+func (c *KvtxCache) Discard() {
+	c.tx.Discard()
+}
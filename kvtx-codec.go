@@ -0,0 +1,169 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// KvtxValueCodec transforms values stored by a Kvtx.
+//
+// All values in the store must be written with the same codec.
+type KvtxValueCodec interface {
+	// Encode appends the encoded value to dst and returns the result.
+	Encode(dst, val []byte) ([]byte, error)
+	// Decode appends the decoded value to dst and returns the result.
+	Decode(dst, data []byte) ([]byte, error)
+}
+
+// KvtxOption configures a Kvtx.
+type KvtxOption func(t *Kvtx)
+
+// WithKvtxValueCodec sets the codec used to encode and decode values.
+func WithKvtxValueCodec(codec KvtxValueCodec) KvtxOption {
+	return func(t *Kvtx) {
+		t.codec = codec
+	}
+}
+
+// Compression codec header bytes.
+const (
+	// compressionHeaderRaw indicates the value is stored uncompressed.
+	compressionHeaderRaw byte = iota
+	// compressionHeaderDeflate indicates the value is compressed with deflate.
+	compressionHeaderDeflate
+)
+
+// DefaultCompressionThreshold is the default minimum size of a value to compress.
+const DefaultCompressionThreshold = 256
+
+// CompressionCodec compresses values with deflate.
+//
+// Each value is prefixed with a header byte indicating if it is compressed.
+// Values smaller than the threshold, or which do not shrink when compressed,
+// are stored uncompressed.
+//
+// Values written before the codec was enabled have no header byte. To read
+// them, construct the codec with WithCompressionAllowUntagged. Writing the
+// values again with the codec adds the header.
+type CompressionCodec struct {
+	threshold int
+	level     int
+	// allowUntagged reads values without a known header as uncompressed.
+	allowUntagged bool
+	writers       sync.Pool
+	readers       sync.Pool
+}
+
+// CompressionCodecOption configures a CompressionCodec.
+type CompressionCodecOption func(c *CompressionCodec)
+
+// WithCompressionAllowUntagged reads values without a known header byte as uncompressed.
+//
+// Use this to read values written before the codec was enabled. An untagged
+// value which starts with a header byte, 0x00 or 0x01, is misread: JSON and
+// protobuf values never do, other values must be written again with the codec
+// first.
+func WithCompressionAllowUntagged() CompressionCodecOption {
+	return func(c *CompressionCodec) {
+		c.allowUntagged = true
+	}
+}
+
+// NewCompressionCodec constructs a new compression codec.
+//
+// If threshold is zero, uses DefaultCompressionThreshold.
+// The level is a compress/flate level, flate.DefaultCompression if zero.
+func NewCompressionCodec(threshold, level int, opts ...CompressionCodecOption) (*CompressionCodec, error) {
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	if _, err := flate.NewWriter(io.Discard, level); err != nil {
+		return nil, err
+	}
+	c := &CompressionCodec{threshold: threshold, level: level}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Encode appends the encoded value to dst and returns the result.
+func (c *CompressionCodec) Encode(dst, val []byte) ([]byte, error) {
+	if len(val) < c.threshold {
+		return append(append(dst, compressionHeaderRaw), val...), nil
+	}
+
+	out := bytes.NewBuffer(append(dst, compressionHeaderDeflate))
+	fw, _ := c.writers.Get().(*flate.Writer)
+	if fw == nil {
+		var err error
+		fw, err = flate.NewWriter(out, c.level)
+		if err != nil {
+			return dst, err
+		}
+	} else {
+		fw.Reset(out)
+	}
+	defer c.writers.Put(fw)
+	if _, err := fw.Write(val); err != nil {
+		return dst, err
+	}
+	if err := fw.Close(); err != nil {
+		return dst, err
+	}
+
+	enc := out.Bytes()
+	if len(enc)-len(dst)-1 >= len(val) {
+		// compression did not help
+		enc = append(enc[:len(dst)], compressionHeaderRaw)
+		return append(enc, val...), nil
+	}
+	return enc, nil
+}
+
+// Decode appends the decoded value to dst and returns the result.
+func (c *CompressionCodec) Decode(dst, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		if c.allowUntagged {
+			return dst, nil
+		}
+		return dst, errors.New("compressed value is missing the header")
+	}
+	switch data[0] {
+	case compressionHeaderRaw:
+		return append(dst, data[1:]...), nil
+	case compressionHeaderDeflate:
+	default:
+		if c.allowUntagged {
+			return append(dst, data...), nil
+		}
+		return dst, errors.Errorf("unknown compression header: %d", data[0])
+	}
+
+	src := bytes.NewReader(data[1:])
+	fr, _ := c.readers.Get().(io.ReadCloser)
+	if fr == nil {
+		fr = flate.NewReader(src)
+	} else if err := fr.(flate.Resetter).Reset(src, nil); err != nil {
+		return dst, err
+	}
+	defer c.readers.Put(fr)
+	out := bytes.NewBuffer(dst)
+	if _, err := out.ReadFrom(fr); err != nil {
+		return dst, errors.Wrap(err, "decompress value")
+	}
+	return out.Bytes(), nil
+}
+
+// _ is a type assertion
+var _ KvtxValueCodec = ((*CompressionCodec)(nil))
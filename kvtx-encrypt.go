@@ -0,0 +1,369 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// encryptedValueVersion is the version byte of an encrypted value.
+const encryptedValueVersion byte = 1
+
+// encryptedKeySegmentLen is the length of an encrypted key segment.
+const encryptedKeySegmentLen = 16
+
+// DefaultKeySeparator is the default separator between key segments.
+const DefaultKeySeparator = '/'
+
+// EncryptionKeyring holds the AEAD keys used to encrypt values.
+//
+// New values are encrypted with the active key. Each value is prefixed with
+// the ID of the key used to encrypt it, so old keys can be kept for
+// decryption while the values are migrated with EncryptedKvtx.ReEncrypt.
+type EncryptionKeyring struct {
+	aeads  map[uint32]cipher.AEAD
+	active uint32
+}
+
+// NewEncryptionKeyring constructs a new keyring with an active AES-GCM key.
+//
+// The key must be 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256.
+func NewEncryptionKeyring(activeID uint32, activeKey []byte) (*EncryptionKeyring, error) {
+	k := &EncryptionKeyring{aeads: make(map[uint32]cipher.AEAD)}
+	if err := k.AddKey(activeID, activeKey); err != nil {
+		return nil, err
+	}
+	k.active = activeID
+	return k, nil
+}
+
+// AddKey adds an AES-GCM key to the keyring for decryption.
+func (k *EncryptionKeyring) AddKey(id uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.aeads[id] = aead
+	return nil
+}
+
+// SetActive sets the key used to encrypt new values.
+func (k *EncryptionKeyring) SetActive(id uint32) error {
+	if _, ok := k.aeads[id]; !ok {
+		return errors.Wrapf(ErrUnknownEncryptionKey, "key id %d", id)
+	}
+	k.active = id
+	return nil
+}
+
+// GetActive returns the ID of the active key.
+func (k *EncryptionKeyring) GetActive() uint32 {
+	return k.active
+}
+
+// seal encrypts a value with the active key.
+func (k *EncryptionKeyring) seal(plaintext, ad []byte) ([]byte, error) {
+	aead := k.aeads[k.active]
+	hdrLen := 5 + aead.NonceSize()
+	out := make([]byte, hdrLen, hdrLen+len(plaintext)+aead.Overhead())
+	out[0] = encryptedValueVersion
+	binary.BigEndian.PutUint32(out[1:5], k.active)
+	if _, err := io.ReadFull(rand.Reader, out[5:hdrLen]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out[5:hdrLen], plaintext, ad), nil
+}
+
+// open decrypts a value, returning the key ID used to encrypt it.
+func (k *EncryptionKeyring) open(data, ad []byte) ([]byte, uint32, error) {
+	keyID, err := encryptedValueKeyID(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	aead, ok := k.aeads[keyID]
+	if !ok {
+		return nil, keyID, errors.Wrapf(ErrUnknownEncryptionKey, "key id %d", keyID)
+	}
+	hdrLen := 5 + aead.NonceSize()
+	if len(data) < hdrLen+aead.Overhead() {
+		return nil, keyID, errors.Wrap(ErrDecryptionFailed, "value is truncated")
+	}
+	plaintext, err := aead.Open(nil, data[5:hdrLen], data[hdrLen:], ad)
+	if err != nil {
+		return nil, keyID, errors.Wrap(ErrDecryptionFailed, err.Error())
+	}
+	return plaintext, keyID, nil
+}
+
+// encryptedValueKeyID returns the key ID of an encrypted value.
+func encryptedValueKeyID(data []byte) (uint32, error) {
+	if len(data) < 5 || data[0] != encryptedValueVersion {
+		return 0, errors.Wrap(ErrDecryptionFailed, "unknown value format")
+	}
+	return binary.BigEndian.Uint32(data[1:5]), nil
+}
+
+// EncryptedKvtxOpts are options for an EncryptedKvtx.
+type EncryptedKvtxOpts struct {
+	// KeyHMACKey enables deterministic encryption of keys if set.
+	//
+	// Each key segment is replaced with a HMAC-SHA256 of the segment chained
+	// with the previous segments. Prefix scans are efficient for prefixes
+	// ending on a segment boundary, other prefixes are filtered after
+	// decryption. Scans no longer return keys in lexicographic order.
+	//
+	// Rotating this key requires rewriting the store.
+	KeyHMACKey []byte
+	// KeySeparator separates key segments if KeyHMACKey is set.
+	// Defaults to DefaultKeySeparator if zero.
+	KeySeparator byte
+}
+
+// EncryptedKvtx encrypts the values, and optionally the keys, of a Kvtx.
+//
+// Values are encrypted with AES-GCM with the stored key as associated data.
+// All entries in the store must be written through the EncryptedKvtx.
+type EncryptedKvtx struct {
+	tx      *Kvtx
+	keyring *EncryptionKeyring
+	hmacKey []byte
+	sep     byte
+}
+
+// NewEncryptedKvtx constructs a new encrypting wrapper around a Kvtx.
+//
+// opts is optional
+func NewEncryptedKvtx(tx *Kvtx, keyring *EncryptionKeyring, opts *EncryptedKvtxOpts) (*EncryptedKvtx, error) {
+	if keyring == nil || len(keyring.aeads) == 0 {
+		return nil, errors.New("keyring must have at least one key")
+	}
+	e := &EncryptedKvtx{tx: tx, keyring: keyring, sep: DefaultKeySeparator}
+	if opts != nil {
+		if len(opts.KeyHMACKey) != 0 {
+			e.hmacKey = append([]byte(nil), opts.KeyHMACKey...)
+		}
+		if opts.KeySeparator != 0 {
+			e.sep = opts.KeySeparator
+		}
+	}
+	return e, nil
+}
+
+// GetKvtx returns the underlying Kvtx.
+func (e *EncryptedKvtx) GetKvtx() *Kvtx {
+	return e.tx
+}
+
+// encryptsKeys checks if the keys are encrypted.
+func (e *EncryptedKvtx) encryptsKeys() bool {
+	return len(e.hmacKey) != 0
+}
+
+// encodeKey encodes a key to the stored key.
+// If partial is set, a trailing incomplete segment is dropped (for prefixes).
+func (e *EncryptedKvtx) encodeKey(key []byte, partial bool) []byte {
+	if !e.encryptsKeys() {
+		return key
+	}
+	var out []byte
+	var prev []byte
+	for len(key) != 0 {
+		idx := bytes.IndexByte(key, e.sep)
+		if idx == -1 && partial {
+			break
+		}
+		seg := key
+		if idx != -1 {
+			seg = key[:idx+1]
+		}
+		key = key[len(seg):]
+
+		mac := hmac.New(sha256.New, e.hmacKey)
+		_, _ = mac.Write(prev)
+		_, _ = mac.Write(seg)
+		prev = mac.Sum(nil)[:encryptedKeySegmentLen]
+		out = append(out, prev...)
+	}
+	return out
+}
+
+// seal encrypts a key and value.
+func (e *EncryptedKvtx) seal(key, storedKey, value []byte) ([]byte, error) {
+	plaintext := value
+	if e.encryptsKeys() {
+		// store the original key with the value to decode it when scanning.
+		plaintext = make([]byte, 0, binary.MaxVarintLen64+len(key)+len(value))
+		plaintext = binary.AppendUvarint(plaintext, uint64(len(key)))
+		plaintext = append(plaintext, key...)
+		plaintext = append(plaintext, value...)
+	}
+	return e.keyring.seal(plaintext, storedKey)
+}
+
+// open decrypts a stored value, returning the key and value.
+func (e *EncryptedKvtx) open(storedKey, data []byte) (key, value []byte, keyID uint32, err error) {
+	plaintext, keyID, err := e.keyring.open(data, storedKey)
+	if err != nil {
+		return nil, nil, keyID, err
+	}
+	if !e.encryptsKeys() {
+		return storedKey, plaintext, keyID, nil
+	}
+	klen, n := binary.Uvarint(plaintext)
+	if n <= 0 || uint64(len(plaintext)-n) < klen {
+		return nil, nil, keyID, errors.Wrap(ErrDecryptionFailed, "invalid key length")
+	}
+	key = plaintext[n : n+int(klen)]
+	return key, plaintext[n+int(klen):], keyID, nil
+}
+
+// Size returns the number of keys in the store.
+func (e *EncryptedKvtx) Size() (uint64, error) {
+	return e.tx.Size()
+}
+
+// Get returns the decrypted value for a key.
+func (e *EncryptedKvtx) Get(key []byte) (data []byte, found bool, err error) {
+	if len(key) == 0 {
+		return nil, false, ErrEmptyKey
+	}
+	storedKey := e.encodeKey(key, false)
+	sealed, found, err := e.tx.Get(storedKey)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	okey, value, _, err := e.open(storedKey, sealed)
+	if err != nil {
+		return nil, true, err
+	}
+	if !bytes.Equal(okey, key) {
+		return nil, true, errors.Wrap(ErrDecryptionFailed, "key mismatch")
+	}
+	return value, true, nil
+}
+
+// Set encrypts and sets the value of a key.
+// This will not be committed until Commit is called.
+func (e *EncryptedKvtx) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	storedKey := e.encodeKey(key, false)
+	sealed, err := e.seal(key, storedKey, value)
+	if err != nil {
+		return err
+	}
+	return e.tx.Set(storedKey, sealed)
+}
+
+// Delete deletes a key.
+// Not found should not return an error.
+func (e *EncryptedKvtx) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	return e.tx.Delete(e.encodeKey(key, false))
+}
+
+// Exists checks if a key exists.
+func (e *EncryptedKvtx) Exists(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrEmptyKey
+	}
+	return e.tx.Exists(e.encodeKey(key, false))
+}
+
+// ScanPrefix iterates over decrypted keys and values with a prefix.
+func (e *EncryptedKvtx) ScanPrefix(prefix []byte, cb func(key, val []byte) error) error {
+	return e.tx.ScanPrefixBorrowed(e.encodeKey(prefix, true), func(storedKey, sealed []byte) error {
+		key, value, _, err := e.open(storedKey, sealed)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(key, prefix) {
+			return nil
+		}
+		if !e.encryptsKeys() {
+			key = append([]byte(nil), key...)
+		}
+		return cb(key, value)
+	})
+}
+
+// ScanPrefixKeys iterates over decrypted keys with a prefix.
+//
+// If keys are encrypted, the values are read to recover the keys.
+func (e *EncryptedKvtx) ScanPrefixKeys(prefix []byte, cb func(key []byte) error) error {
+	if !e.encryptsKeys() {
+		return e.tx.ScanPrefixKeys(prefix, cb)
+	}
+	return e.ScanPrefix(prefix, func(key, _ []byte) error {
+		return cb(key)
+	})
+}
+
+// ReEncrypt re-encrypts all values not encrypted with the active key.
+//
+// Use this to migrate the store after rotating keys with SetActive, then
+// remove the old key once the transaction is committed.
+// Returns the number of re-encrypted values.
+func (e *EncryptedKvtx) ReEncrypt() (int, error) {
+	active := e.keyring.GetActive()
+	type entry struct{ storedKey, sealed []byte }
+	var pending []entry
+	err := e.tx.ScanPrefixBorrowed(nil, func(storedKey, sealed []byte) error {
+		keyID, err := encryptedValueKeyID(sealed)
+		if err != nil {
+			return err
+		}
+		if keyID == active {
+			return nil
+		}
+		key, value, _, err := e.open(storedKey, sealed)
+		if err != nil {
+			return err
+		}
+		resealed, err := e.seal(key, storedKey, value)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, entry{
+			storedKey: append([]byte(nil), storedKey...),
+			sealed:    resealed,
+		})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, ent := range pending {
+		if err := e.tx.Set(ent.storedKey, ent.sealed); err != nil {
+			return i, err
+		}
+	}
+	return len(pending), nil
+}
+
+// Commit commits the transaction to storage.
+func (e *EncryptedKvtx) Commit() error {
+	return e.tx.Commit()
+}
+
+// Discard cancels the transaction.
+func (e *EncryptedKvtx) Discard() {
+	e.tx.Discard()
+}
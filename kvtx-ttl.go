@@ -0,0 +1,294 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KvtxExpiryIndex is the name of the index on the expiry time of Kvtx values.
+//
+// Create it during an upgrade with DatabaseUpdate.CreateKvtxExpiryIndex.
+const KvtxExpiryIndex = "kvtx-expires"
+
+const (
+	// kvtxExpiresField is the field of an expiring value with the expiry time.
+	kvtxExpiresField = "expires"
+	// kvtxValueField is the field of an expiring value with the value.
+	kvtxValueField = "value"
+)
+
+// DefaultKvtxSweepInterval is the default interval between sweeps of expired keys.
+const DefaultKvtxSweepInterval = time.Minute
+
+// DefaultKvtxSweepBatchSize is the default number of expired keys deleted per transaction.
+const DefaultKvtxSweepBatchSize = 100
+
+// CreateKvtxExpiryIndex creates the expiry index on a Kvtx object store.
+// Does nothing if it already exists.
+//
+// The index is required for Kvtx.SetWithTTL.
+func (d *DatabaseUpdate) CreateKvtxExpiryIndex(storeID string) error {
+	store, err := d.txn.GetObjectStore(storeID)
+	if err != nil {
+		return err
+	}
+	for _, name := range store.IndexNames() {
+		if name == KvtxExpiryIndex {
+			return nil
+		}
+	}
+	_, err = store.CreateIndex(KvtxExpiryIndex, NewKeyPath(kvtxExpiresField), nil)
+	return err
+}
+
+// jsNow returns the current time in ms since the epoch.
+func jsNow() float64 {
+	return js.Global().Get("Date").Call("now").Float()
+}
+
+// kvtxLiveValue unwraps a stored Kvtx value, checking the expiry time.
+//
+// Expiring values are stored as {value, expires} objects, other values as is.
+// Returns false if the value has expired.
+func kvtxLiveValue(val js.Value, now float64) (js.Value, bool) {
+	if val.Type() != js.TypeObject || val.InstanceOf(jsUint8Array) {
+		return val, true
+	}
+	expires := val.Get(kvtxExpiresField)
+	if expires.Type() != js.TypeNumber {
+		return val, true
+	}
+	if expires.Float() <= now {
+		return js.Undefined(), false
+	}
+	return val.Get(kvtxValueField), true
+}
+
+// expiryIndexExists checks if the store has the expiry index.
+func (t *Kvtx) expiryIndexExists() (bool, error) {
+	if t.expiryChecked {
+		return t.hasExpiryIndex, nil
+	}
+	names, err := t.objStore.IndexNames()
+	if err != nil {
+		return false, err
+	}
+	for _, name := range names {
+		if name == KvtxExpiryIndex {
+			t.hasExpiryIndex = true
+		}
+	}
+	t.expiryChecked = true
+	return t.hasExpiryIndex, nil
+}
+
+// SetWithTTL sets the value of a key which expires after the ttl.
+//
+// Expired keys are hidden from Get, Exists, and ScanPrefix, and deleted by
+// Database.SweepExpired. The store must have the expiry index created with
+// DatabaseUpdate.CreateKvtxExpiryIndex. Set clears the expiry of a key.
+// This will not be committed until Commit is called.
+func (t *Kvtx) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if ttl <= 0 {
+		return errors.Errorf("ttl must be positive: %v", ttl)
+	}
+	hasExpiry, err := t.expiryIndexExists()
+	if err != nil {
+		return err
+	}
+	if !hasExpiry {
+		return errors.Errorf("object store %s has no %s index", t.objStore.GetName(), KvtxExpiryIndex)
+	}
+	if t.codec != nil {
+		value, err = t.codec.Encode(nil, value)
+		if err != nil {
+			return err
+		}
+	}
+	wrapped := js.Global().Get("Object").New()
+	wrapped.Set(kvtxValueField, CopyByteSliceToJs(value))
+	wrapped.Set(kvtxExpiresField, jsNow()+float64(ttl.Milliseconds()))
+	_, err = t.objStore.Put(wrapped, key)
+	return err
+}
+
+// SweepExpired deletes up to batchSize expired keys from a Kvtx object store.
+//
+// The expired keys are found with the expiry index and deleted in a single
+// transaction, so keys set again concurrently are not deleted. Deletes are
+// applied to the read cache and published to the change feed, if enabled, and
+// recorded in the changelog if clog is set. A batchSize of zero uses
+// DefaultKvtxSweepBatchSize. Returns the number of deleted keys.
+func (d *Database) SweepExpired(storeID string, batchSize int, clog *ChangelogOptions) (n int, e error) {
+	if batchSize <= 0 {
+		batchSize = DefaultKvtxSweepBatchSize
+	}
+	scope := []string{storeID}
+	cl := newChangelog(clog)
+	if cl != nil {
+		if err := d.checkChangelogObjectStore(); err != nil {
+			return 0, err
+		}
+		scope = append(scope, ChangelogObjectStoreID)
+	}
+	txn, err := d.Transaction(scope, READWRITE)
+	if err != nil {
+		return 0, err
+	}
+	store, err := txn.GetObjectStore(storeID)
+	if err != nil {
+		return 0, err
+	}
+	idx, err := store.Index(KvtxExpiryIndex)
+	if err != nil {
+		txn.Abort()
+		return 0, err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+			txn.Abort()
+		}
+	}()
+
+	// the deletes are issued from the cursor callback to keep the transaction active.
+	var changes []durableChange
+	var cbErr error
+	req := idx.val.Call("openCursor", UpperBound(jsNow(), false))
+	onSuccess := js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+		defer func() {
+			if rerr := recover(); rerr != nil {
+				cbErr, _ = recoveredError(rerr)
+				if cbErr == nil {
+					cbErr = errors.New("sweep paniced")
+				}
+				txn.Abort()
+			}
+		}()
+		cursor := req.Get("result")
+		if !cursor.Truthy() || n >= batchSize {
+			return nil
+		}
+		op := newDurableOp(durableOpDelete, cursor.Get("primaryKey"), nil)
+		delReq := cursor.Call("delete")
+		if cl != nil {
+			cl.logRequest(delReq, storeID, op)
+		}
+		changes = append(changes, durableChange{store: storeID, op: op})
+		n++
+		cursor.Call("continue")
+		return nil
+	})
+	defer onSuccess.Release()
+	req.Set("onsuccess", onSuccess)
+	if err := txn.WaitComplete(); err != nil {
+		return 0, err
+	}
+	if cbErr != nil {
+		return 0, cbErr
+	}
+	if len(changes) != 0 {
+		if cache := d.readCache; cache != nil {
+			cache.applyCommit(changes)
+		}
+		if feed := d.feed; feed != nil {
+			feed.publish(changes)
+		}
+	}
+	return n, nil
+}
+
+// KvtxSweeper periodically deletes expired keys from a Kvtx object store.
+type KvtxSweeper struct {
+	db        *Database
+	storeID   string
+	interval  time.Duration
+	batchSize int
+	clog      *ChangelogOptions
+
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+
+	mtx sync.Mutex
+	err error
+}
+
+// StartKvtxSweeper starts deleting expired keys from a Kvtx object store.
+//
+// Each sweep deletes expired keys in batches of batchSize per transaction
+// until none remain, then waits for the interval. Zero values use
+// DefaultKvtxSweepInterval and DefaultKvtxSweepBatchSize. The deletes are
+// recorded in the changelog if clog is set. Call Close to stop.
+func (d *Database) StartKvtxSweeper(storeID string, interval time.Duration, batchSize int, clog *ChangelogOptions) *KvtxSweeper {
+	if interval <= 0 {
+		interval = DefaultKvtxSweepInterval
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultKvtxSweepBatchSize
+	}
+	s := &KvtxSweeper{
+		db:        d,
+		storeID:   storeID,
+		interval:  interval,
+		batchSize: batchSize,
+		clog:      clog,
+		closed:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Err returns the error from the most recent sweep, if any.
+func (s *KvtxSweeper) Err() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.err
+}
+
+// Close stops the sweeper and waits for any sweep in progress.
+func (s *KvtxSweeper) Close() {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+	<-s.done
+}
+
+// run sweeps until closed.
+func (s *KvtxSweeper) run() {
+	defer close(s.done)
+	for {
+		var err error
+		for {
+			var n int
+			n, err = s.db.SweepExpired(s.storeID, s.batchSize, s.clog)
+			if err != nil || n < s.batchSize {
+				break
+			}
+			select {
+			case <-s.closed:
+				return
+			default:
+			}
+		}
+		s.mtx.Lock()
+		s.err = err
+		s.mtx.Unlock()
+
+		select {
+		case <-s.closed:
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
@@ -12,22 +12,44 @@ type Kvtx struct {
 	txn         *DurableTransaction
 	objStore    *DurableObjectStore
 	discardOnce sync.Once
+	// codec is the value codec, if any.
+	codec KvtxValueCodec
+	// expiryChecked indicates hasExpiryIndex has been resolved.
+	expiryChecked bool
+	// hasExpiryIndex indicates the store has the KvtxExpiryIndex.
+	hasExpiryIndex bool
 }
 
 // NewKvtxTx constructs a new tranasction, opening the object store.
-func NewKvtxTx(txn *DurableTransaction, objStoreID string) (*Kvtx, error) {
+func NewKvtxTx(txn *DurableTransaction, objStoreID string, opts ...KvtxOption) (*Kvtx, error) {
 	objStore, err := txn.GetObjectStore(objStoreID)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Kvtx{
+	t := &Kvtx{
 		txn:      txn,
 		objStore: objStore,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t, nil
+}
+
+// decodeValue copies a value from js into dst, decoding it with the codec.
+// scratch is used as the buffer for the encoded value, and is returned.
+func (t *Kvtx) decodeValue(dst, scratch []byte, val js.Value) (data, nscratch []byte, err error) {
+	if t.codec == nil {
+		return CopyByteSliceFromJsInto(dst, val), scratch, nil
+	}
+	scratch = CopyByteSliceFromJsInto(scratch, val)
+	data, err = t.codec.Decode(dst[:0], scratch)
+	return data, scratch, err
 }
 
 // Size returns the number of keys in the store.
+// Includes expired keys which have not been swept yet.
 func (t *Kvtx) Size() (uint64, error) {
 	c, err := t.objStore.Count(nil)
 	return uint64(c), err
@@ -35,29 +57,77 @@ func (t *Kvtx) Size() (uint64, error) {
 
 // Get returns values for a key.
 func (t *Kvtx) Get(key []byte) (data []byte, found bool, err error) {
+	return t.GetInto(key, nil)
+}
+
+// GetInto returns the value for a key, copying it into dst.
+//
+// Reuses the capacity of dst if possible, otherwise allocates a new slice.
+// Returns the slice containing the value.
+func (t *Kvtx) GetInto(key, dst []byte) (data []byte, found bool, err error) {
 	if len(key) == 0 {
 		return nil, false, ErrEmptyKey
 	}
+	// reads in a READWRITE transaction bypass the read cache, as they may
+	// follow a write in the transaction.
+	cache := t.txn.d.readCache
+	if t.txn.GetMode() == READWRITE {
+		cache = nil
+	}
+	var gen uint64
+	if cache != nil {
+		var cached []byte
+		var found bool
+		cached, gen, found = cache.lookup(t.objStore.id, key)
+		if found {
+			data, err = t.decodeBytes(dst, cached)
+			return data, true, err
+		}
+	}
 	jsObj, err := t.objStore.Get(key)
 	if err != nil {
 		return nil, false, err
 	}
-	if !jsObj.Truthy() {
+	if cache != nil && jsObj.Type() == js.TypeObject && jsObj.InstanceOf(jsUint8Array) {
+		raw := CopyByteSliceFromJs(jsObj)
+		cache.fill(t.objStore.id, key, raw, gen)
+		data, err = t.decodeBytes(dst, raw)
+		return data, true, err
+	}
+	jsObj, live := kvtxLiveValue(jsObj, jsNow())
+	if !live || !jsObj.Truthy() {
 		return nil, false, nil
 	}
-	dlen := jsObj.Length()
-	data = make([]byte, dlen)
-	js.CopyBytesToGo(data, jsObj)
+	data, _, err = t.decodeValue(dst, nil, jsObj)
+	if err != nil {
+		return nil, true, err
+	}
 	return data, true, nil
 }
 
+// decodeBytes copies a stored value into dst, decoding it with the codec.
+func (t *Kvtx) decodeBytes(dst, raw []byte) ([]byte, error) {
+	if t.codec == nil {
+		return append(dst[:0], raw...), nil
+	}
+	return t.codec.Decode(dst[:0], raw)
+}
+
 // Set sets the value of a key.
 // This will not be committed until Commit is called.
 func (t *Kvtx) Set(key, value []byte) error {
 	if len(key) == 0 {
 		return ErrEmptyKey
 	}
-	return t.objStore.Put(value, key)
+	if t.codec != nil {
+		var err error
+		value, err = t.codec.Encode(nil, value)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := t.objStore.Put(value, key)
+	return err
 }
 
 // Delete deletes a key.
@@ -70,25 +140,50 @@ func (t *Kvtx) Delete(key []byte) error {
 	return t.objStore.Delete(key)
 }
 
-// scanPrefix iterates over items with a prefix.
-func (t *Kvtx) scanPrefix(prefix []byte, cb func(v *CursorValue) error) error {
+// scanPrefix iterates over items with a prefix, skipping expired items.
+// If keysOnly is set, the values are not read unless needed to check expiry.
+func (t *Kvtx) scanPrefix(prefix []byte, keysOnly bool, cb func(v *CursorValue) error) error {
+	if keysOnly {
+		hasExpiry, err := t.expiryIndexExists()
+		if err != nil {
+			return err
+		}
+		keysOnly = !hasExpiry
+	}
+
 	krv := js.Undefined()
 	if len(prefix) != 0 {
-		prefixGreater := make([]byte, len(prefix)+1)
-		copy(prefixGreater, prefix)
-		prefixGreater[len(prefixGreater)-1] = ^byte(0)
-		krv = Bound(prefix, prefixGreater, false, false)
+		if prefixEnd := prefixUpperBound(prefix); prefixEnd != nil {
+			krv = Bound(prefix, prefixEnd, false, true)
+		} else {
+			krv = LowerBound(prefix, false)
+		}
+	}
+	var cursor *Cursor
+	var err error
+	if keysOnly {
+		cursor, err = t.objStore.OpenKeyCursor(krv)
+	} else {
+		cursor, err = t.objStore.OpenCursor(krv)
 	}
-	cursor, err := t.objStore.OpenCursor(krv)
 	if err != nil {
 		return err
 	}
+	now := jsNow()
 	for {
 		val := cursor.WaitValue()
 		if val == nil {
 			return nil
 		}
 
+		if !keysOnly {
+			var live bool
+			val.Value, live = kvtxLiveValue(val.Value, now)
+			if !live {
+				cursor.ContinueCursor()
+				continue
+			}
+		}
 		if err := cb(val); err != nil {
 			return err
 		}
@@ -97,30 +192,87 @@ func (t *Kvtx) scanPrefix(prefix []byte, cb func(v *CursorValue) error) error {
 	}
 }
 
+// prefixUpperBound returns the first key after all keys with the prefix.
+// Returns nil if there is no such key (the prefix is all 0xff).
+func prefixUpperBound(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := make([]byte, i+1)
+			copy(end, prefix)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
 // ScanPrefixKeys iterates over keys with a prefix.
 func (t *Kvtx) ScanPrefixKeys(prefix []byte, cb func(key []byte) error) error {
-	return t.scanPrefix(prefix, func(val *CursorValue) error {
+	return t.scanPrefix(prefix, true, func(val *CursorValue) error {
 		return cb(
 			CopyByteSliceFromJs(val.Key),
 		)
 	})
 }
 
+// ScanPrefixKeysBorrowed iterates over keys with a prefix.
+//
+// The key buffer is reused between calls: it is only valid during the
+// callback and must be copied to be retained.
+func (t *Kvtx) ScanPrefixKeysBorrowed(prefix []byte, cb func(key []byte) error) error {
+	var keyBuf []byte
+	return t.scanPrefix(prefix, true, func(val *CursorValue) error {
+		keyBuf = CopyByteSliceFromJsInto(keyBuf, val.Key)
+		return cb(keyBuf)
+	})
+}
+
 // ScanPrefix iterates over keys with a prefix.
 func (t *Kvtx) ScanPrefix(prefix []byte, cb func(key, val []byte) error) error {
-	return t.scanPrefix(prefix, func(val *CursorValue) error {
-		return cb(
-			CopyByteSliceFromJs(val.Key),
-			CopyByteSliceFromJs(val.Value),
-		)
+	return t.scanPrefix(prefix, false, func(val *CursorValue) error {
+		data, _, err := t.decodeValue(nil, nil, val.Value)
+		if err != nil {
+			return err
+		}
+		return cb(CopyByteSliceFromJs(val.Key), data)
+	})
+}
+
+// ScanPrefixBorrowed iterates over keys with a prefix.
+//
+// The key and value buffers are reused between calls: they are only valid
+// during the callback and must be copied to be retained.
+func (t *Kvtx) ScanPrefixBorrowed(prefix []byte, cb func(key, val []byte) error) error {
+	var keyBuf, valBuf, scratch []byte
+	return t.scanPrefix(prefix, false, func(val *CursorValue) error {
+		var err error
+		keyBuf = CopyByteSliceFromJsInto(keyBuf, val.Key)
+		valBuf, scratch, err = t.decodeValue(valBuf, scratch, val.Value)
+		if err != nil {
+			return err
+		}
+		return cb(keyBuf, valBuf)
 	})
 }
 
-// Exists checks if a key exists.
+// Exists checks if a key exists and has not expired.
 func (t *Kvtx) Exists(key []byte) (bool, error) {
 	if len(key) == 0 {
 		return false, ErrEmptyKey
 	}
+	hasExpiry, err := t.expiryIndexExists()
+	if err != nil {
+		return false, err
+	}
+	if hasExpiry {
+		// read the value to check the expiry.
+		val, err := t.objStore.Get(key)
+		if err != nil {
+			return false, err
+		}
+		val, live := kvtxLiveValue(val, jsNow())
+		return live && !val.IsUndefined(), nil
+	}
 	i, err := t.objStore.Count(key)
 	if err != nil {
 		return false, err
@@ -130,6 +282,7 @@ func (t *Kvtx) Exists(key []byte) (bool, error) {
 
 // Commit commits the transaction to storage.
 // Can return an error to indicate tx failure.
+// If the storage quota was exceeded, the error matches ErrQuotaExceeded.
 func (t *Kvtx) Commit() error {
 	if t.txn == nil {
 		return nil
@@ -0,0 +1,353 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"syscall/js"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LockObjectStoreID is the id of the object store used for fallback lock leases.
+//
+// Create it during an upgrade with DatabaseUpdate.CreateLockObjectStore.
+const LockObjectStoreID = "indexeddb-locks"
+
+// lockNamePrefix is the prefix for lock names, followed by the database name.
+const lockNamePrefix = "indexeddb/"
+
+// LockLeaseTTL is the duration of a fallback lock lease.
+//
+// The lease is renewed while the lock is held. If the tab holding the lease
+// closes, the lease expires and the lock can be acquired by another tab.
+const LockLeaseTTL = 10 * time.Second
+
+// LockMode is the mode of a lock.
+type LockMode string
+
+var (
+	// LockExclusive can be held by only one holder at a time.
+	LockExclusive LockMode = "exclusive"
+	// LockShared can be held by many holders at a time.
+	LockShared LockMode = "shared"
+)
+
+// Validate checks the lock mode.
+func (m LockMode) Validate() error {
+	switch m {
+	case LockExclusive, LockShared:
+		return nil
+	default:
+		return errors.Errorf("invalid lock mode: %q", string(m))
+	}
+}
+
+// CreateLockObjectStore creates the object store for fallback lock leases.
+// Does nothing if it already exists.
+func (d *DatabaseUpdate) CreateLockObjectStore() error {
+	if d.ContainsObjectStore(LockObjectStoreID) {
+		return nil
+	}
+	return d.CreateObjectStore(LockObjectStoreID, nil)
+}
+
+// WithLock calls fn while holding a lock shared by all tabs of the origin.
+//
+// The lock name is scoped to the database. IndexedDB transactions cannot be
+// held across async work: use the lock to serialize read-modify-write
+// sequences, starting a DurableTransaction within fn.
+//
+// Uses the Web Locks API (navigator.locks). If unavailable, falls back to a
+// lease record in the LockObjectStoreID object store, which must have been
+// created with DatabaseUpdate.CreateLockObjectStore.
+//
+// Returns the context error if ctx is canceled before the lock is acquired.
+// If the fallback lease expires or is taken over while fn runs, for example
+// because renewals failed for LockLeaseTTL, returns ErrLockLost once fn
+// returns: another holder may have run concurrently with fn.
+func (d *Database) WithLock(ctx context.Context, name string, mode LockMode, fn func() error) error {
+	if mode == "" {
+		mode = LockExclusive
+	}
+	if err := mode.Validate(); err != nil {
+		return err
+	}
+	lockName := lockNamePrefix + d.GetName() + "/" + name
+	if locks := webLocks(); locks.Truthy() {
+		return withWebLock(ctx, locks, lockName, mode, fn)
+	}
+	return d.withLeaseLock(ctx, lockName, mode, fn)
+}
+
+// webLocks returns navigator.locks, or undefined if the Web Locks API is unavailable.
+func webLocks() js.Value {
+	nav := js.Global().Get("navigator")
+	if !nav.Truthy() {
+		return js.Undefined()
+	}
+	return nav.Get("locks")
+}
+
+// withWebLock calls fn while holding a lock from the Web Locks API.
+func withWebLock(ctx context.Context, locks js.Value, name string, mode LockMode, fn func() error) error {
+	global := js.Global()
+
+	// held is returned to the lock manager: the lock is held until it resolves.
+	var release js.Value
+	executor := js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+		release = dats[0]
+		return nil
+	})
+	defer executor.Release()
+	held := global.Get("Promise").New(executor)
+
+	acquired := make(chan struct{}, 1)
+	onAcquire := js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+		acquired <- struct{}{}
+		return held
+	})
+	defer onAcquire.Release()
+
+	abort := global.Get("AbortController").New()
+	opts := global.Get("Object").New()
+	opts.Set("mode", string(mode))
+	opts.Set("signal", abort.Get("signal"))
+	reqPromise := locks.Call("request", name, opts, onAcquire)
+
+	reqDone := make(chan error, 1)
+	go func() {
+		_, err := awaitPromise(reqPromise)
+		reqDone <- err
+	}()
+
+	select {
+	case <-acquired:
+	case err := <-reqDone:
+		// rejected before the lock was acquired.
+		return err
+	case <-ctx.Done():
+		abort.Call("abort")
+		for {
+			select {
+			case <-acquired:
+				// acquired before the abort: release it.
+				release.Invoke()
+			case <-reqDone:
+				return ctx.Err()
+			}
+		}
+	}
+
+	err := callLocked(fn)
+	release.Invoke()
+	<-reqDone
+	return err
+}
+
+// holdWebLock acquires an exclusive lock from the Web Locks API.
+//
+// The lock is held until release is called or the page closes.
+func holdWebLock(locks js.Value, name string) (release func(), err error) {
+	global := js.Global()
+
+	var resolve js.Value
+	executor := js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+		resolve = dats[0]
+		return nil
+	})
+	held := global.Get("Promise").New(executor)
+	executor.Release()
+
+	acquired := make(chan struct{}, 1)
+	var onAcquire js.Func
+	onAcquire = js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+		onAcquire.Release()
+		acquired <- struct{}{}
+		return held
+	})
+	reqPromise := locks.Call("request", name, onAcquire)
+
+	reqDone := make(chan error, 1)
+	go func() {
+		_, err := awaitPromise(reqPromise)
+		reqDone <- err
+	}()
+	select {
+	case <-acquired:
+	case err := <-reqDone:
+		// rejected before the lock was acquired.
+		onAcquire.Release()
+		return nil, err
+	}
+	return func() { resolve.Invoke() }, nil
+}
+
+// callLocked calls fn, converting a panic to an error so the lock is released.
+func callLocked(fn func() error) (e error) {
+	defer func() {
+		if rerr := recover(); rerr != nil {
+			var ok bool
+			e, ok = recoveredError(rerr)
+			if !ok {
+				e = errors.Errorf("panic while holding lock: %v", rerr)
+			}
+		}
+	}()
+	return fn()
+}
+
+// withLeaseLock calls fn while holding a lease in the lock object store.
+func (d *Database) withLeaseLock(ctx context.Context, name string, mode LockMode, fn func() error) error {
+	if !d.ContainsObjectStore(LockObjectStoreID) {
+		return errors.Errorf("Web Locks API unavailable and object store %s not found", LockObjectStoreID)
+	}
+	var ownerBuf [16]byte
+	if _, err := rand.Read(ownerBuf[:]); err != nil {
+		return err
+	}
+	owner := hex.EncodeToString(ownerBuf[:])
+
+	backoff := 25 * time.Millisecond
+	for {
+		ok, err := d.updateLease(name, owner, mode, false)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff < 500*time.Millisecond {
+			backoff *= 2
+		}
+	}
+
+	// renew the lease while fn runs.
+	done := make(chan struct{})
+	renewed := make(chan struct{})
+	// lost is set if the lease was taken over or could not be renewed in time.
+	var lost bool
+	go func() {
+		defer close(renewed)
+		lastRenewed := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(LockLeaseTTL / 3):
+			}
+			held, err := d.updateLease(name, owner, mode, false)
+			switch {
+			case err == nil && !held:
+				lost = true
+				return
+			case err == nil:
+				lastRenewed = time.Now()
+			case time.Since(lastRenewed) >= LockLeaseTTL:
+				lost = true
+				return
+			}
+			// other errors: the next renewal retries.
+		}
+	}()
+
+	err := callLocked(fn)
+	close(done)
+	<-renewed
+	held, rerr := d.updateLease(name, owner, mode, true)
+	switch {
+	case err != nil:
+	case rerr != nil:
+		err = rerr
+	case lost || !held:
+		err = ErrLockLost
+	}
+	return err
+}
+
+// updateLease acquires, renews, or releases a lease in a single transaction.
+//
+// The lease record maps holder ids to expiration times in ms since the epoch.
+// Returns if the lease is held after the update, or was held before the
+// release.
+func (d *Database) updateLease(name, owner string, mode LockMode, releaseLease bool) (bool, error) {
+	txn, err := d.Transaction([]string{LockObjectStoreID}, READWRITE)
+	if err != nil {
+		return false, err
+	}
+	store, err := txn.GetObjectStore(LockObjectStoreID)
+	if err != nil {
+		return false, err
+	}
+
+	// the put is issued from the get callback to keep the transaction active.
+	var held bool
+	var cbErr error
+	req := store.val.Call("get", name)
+	onSuccess := js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+		defer func() {
+			if rerr := recover(); rerr != nil {
+				cbErr, _ = recoveredError(rerr)
+				if cbErr == nil {
+					cbErr = errors.New("lease update paniced")
+				}
+			}
+		}()
+		global := js.Global()
+		now := global.Get("Date").Call("now").Float()
+		rec := req.Get("result")
+		holders := global.Get("Object").New()
+		recMode := ""
+		if rec.Type() == js.TypeObject {
+			recMode = rec.Get("mode").String()
+			prev := rec.Get("holders")
+			keys := global.Get("Object").Call("keys", prev)
+			for i := 0; i < keys.Length(); i++ {
+				k := keys.Index(i).String()
+				if exp := prev.Get(k).Float(); exp > now {
+					holders.Set(k, exp)
+				}
+			}
+		}
+		holding := holders.Get(owner).Truthy()
+		live := global.Get("Object").Call("keys", holders).Length()
+		switch {
+		case releaseLease:
+			holders.Delete(owner)
+			held = holding
+		case holding:
+			holders.Set(owner, now+float64(LockLeaseTTL.Milliseconds()))
+			held = true
+		case live == 0 || (mode == LockShared && recMode == string(LockShared)):
+			holders.Set(owner, now+float64(LockLeaseTTL.Milliseconds()))
+			recMode = string(mode)
+			held = true
+		default:
+			return nil
+		}
+		if global.Get("Object").Call("keys", holders).Length() == 0 {
+			store.val.Call("delete", name)
+			return nil
+		}
+		out := global.Get("Object").New()
+		out.Set("mode", recMode)
+		out.Set("holders", holders)
+		store.val.Call("put", out, name)
+		return nil
+	})
+	defer onSuccess.Release()
+	req.Set("onsuccess", onSuccess)
+	if err := txn.WaitComplete(); err != nil {
+		return false, err
+	}
+	return held, cbErr
+}
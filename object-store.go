@@ -16,37 +16,76 @@ func (s *ObjectStore) GetName() string {
 	return s.val.Get("name").String()
 }
 
+// KeyPath returns the key path, nil if the store uses out-of-line keys.
+func (s *ObjectStore) KeyPath() *KeyPath {
+	return keyPathFromJs(s.val.Get("keyPath"))
+}
+
+// AutoIncrement checks if the store has a key generator.
+func (s *ObjectStore) AutoIncrement() bool {
+	return s.val.Get("autoIncrement").Bool()
+}
+
+// IndexNames returns the names of the indexes sorted by name.
+func (s *ObjectStore) IndexNames() []string {
+	return stringListFromJs(s.val.Get("indexNames"))
+}
+
+// writeArgs builds the arguments for put or add.
+// Omits the key if it is nil, null, or undefined.
+func writeArgs(value, key interface{}) []interface{} {
+	switch k := key.(type) {
+	case nil:
+		return []interface{}{value}
+	case js.Value:
+		if k.IsNull() || k.IsUndefined() {
+			return []interface{}{value}
+		}
+	}
+	return []interface{}{value, key}
+}
+
 // Put puts data into the store.
-func (s *ObjectStore) Put(value interface{}, key interface{}) (e error) {
+//
+// The key can be nil for stores with a key path or key generator.
+// Returns the key of the record, which is generated for autoIncrement stores.
+func (s *ObjectStore) Put(value interface{}, key interface{}) (_ js.Value, e error) {
 	defer func() {
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e, _ = recoveredError(err)
 		}
 	}()
-	value = MaybeConvertValueToJs(value)
+	value, err := ConvertValueToJs(value)
+	if err != nil {
+		return js.Undefined(), err
+	}
 	key = MaybeConvertValueToJs(key)
-	_, err := WaitRequest(s.val.Call("put", value, key))
-	return err
+	return WaitRequest(s.val.Call("put", writeArgs(value, key)...))
 }
 
 // Add adds data to the store.
-func (s *ObjectStore) Add(value interface{}, key interface{}) (e error) {
+//
+// The key can be nil for stores with a key path or key generator.
+// Returns the key of the record, which is generated for autoIncrement stores.
+func (s *ObjectStore) Add(value interface{}, key interface{}) (_ js.Value, e error) {
 	defer func() {
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e, _ = recoveredError(err)
 		}
 	}()
-	value = MaybeConvertValueToJs(value)
+	value, err := ConvertValueToJs(value)
+	if err != nil {
+		return js.Undefined(), err
+	}
 	key = MaybeConvertValueToJs(key)
-	_, err := WaitRequest(s.val.Call("add", value, key))
-	return err
+	return WaitRequest(s.val.Call("add", writeArgs(value, key)...))
 }
 
 // Delete deletes data from the store.
 func (s *ObjectStore) Delete(query interface{}) (e error) {
 	defer func() {
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e, _ = recoveredError(err)
 		}
 	}()
 	query = MaybeConvertValueToJs(query)
@@ -58,7 +97,7 @@ func (s *ObjectStore) Delete(query interface{}) (e error) {
 func (s *ObjectStore) Clear() (e error) {
 	defer func() {
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e, _ = recoveredError(err)
 		}
 	}()
 	_, err := WaitRequest(s.val.Call("clear"))
@@ -69,18 +108,28 @@ func (s *ObjectStore) Clear() (e error) {
 func (s *ObjectStore) Get(query interface{}) (rv js.Value, e error) {
 	defer func() {
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e, _ = recoveredError(err)
 		}
 	}()
 	query = MaybeConvertValueToJs(query)
 	return WaitRequest(s.val.Call("get", query))
 }
 
+// GetBlob gets a Blob or File from the store.
+// Returns nil if not found.
+func (s *ObjectStore) GetBlob(query interface{}) (*Blob, error) {
+	val, err := s.Get(query)
+	if err != nil || val.IsUndefined() {
+		return nil, err
+	}
+	return NewBlob(val)
+}
+
 // GetKey gets data from the store by key.
 func (s *ObjectStore) GetKey(query interface{}) (_ js.Value, e error) {
 	defer func() {
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e, _ = recoveredError(err)
 		}
 	}()
 	query = MaybeConvertValueToJs(query)
@@ -88,27 +137,94 @@ func (s *ObjectStore) GetKey(query interface{}) (_ js.Value, e error) {
 }
 
 // GetAll gets all values matching an optional query with an optional count.
-func (s *ObjectStore) GetAll(query interface{}) (_ js.Value, e error) {
+// A count of zero returns all matching values.
+func (s *ObjectStore) GetAll(query interface{}, count int) (_ []js.Value, e error) {
 	defer func() {
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e, _ = recoveredError(err)
 		}
 	}()
 	query = MaybeConvertValueToJs(query)
-	return WaitRequest(s.val.Call("getAll", query))
+	vals, err := WaitRequest(s.val.Call("getAll", countArgs(query, count)...))
+	if err != nil {
+		return nil, err
+	}
+	return jsArrayToSlice(vals), nil
+}
+
+// GetAllBytes gets all values matching an optional query with an optional count.
+// A count of zero returns all matching values.
+// Returns an error if any of the values is not binary.
+func (s *ObjectStore) GetAllBytes(query interface{}, count int) ([][]byte, error) {
+	vals, err := s.GetAll(query, count)
+	if err != nil {
+		return nil, err
+	}
+	return bytesSliceFromJs(vals)
 }
 
 // GetAllKeys gets all keys matching an optional query with an optional count.
-func (s *ObjectStore) GetAllKeys(query interface{}) (_ js.Value, e error) {
+// A count of zero returns all matching keys.
+func (s *ObjectStore) GetAllKeys(query interface{}, count int) (_ []js.Value, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
 	query = MaybeConvertValueToJs(query)
-	return WaitRequest(s.val.Call("getAllKeys", query))
+	keys, err := WaitRequest(s.val.Call("getAllKeys", countArgs(query, count)...))
+	if err != nil {
+		return nil, err
+	}
+	return jsArrayToSlice(keys), nil
+}
+
+// GetAllKeysBytes gets all keys matching an optional query with an optional count.
+// A count of zero returns all matching keys.
+// Returns an error if any of the keys is not binary.
+func (s *ObjectStore) GetAllKeysBytes(query interface{}, count int) ([][]byte, error) {
+	keys, err := s.GetAllKeys(query, count)
+	if err != nil {
+		return nil, err
+	}
+	return bytesSliceFromJs(keys)
+}
+
+// getPage gets the keys and values matching a key range with a count.
+//
+// Issues both requests before waiting so they see the same snapshot.
+func (s *ObjectStore) getPage(krv js.Value, count int) (keys, vals []js.Value, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	keysReq := s.val.Call("getAllKeys", countArgs(krv, count)...)
+	valsReq := s.val.Call("getAll", countArgs(krv, count)...)
+	keysVal, err := WaitRequest(keysReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	valsVal, err := WaitRequest(valsReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	return jsArrayToSlice(keysVal), jsArrayToSlice(valsVal), nil
+}
+
+// ScanPages iterates over the records in an optional IDBKeyRange in pages.
+//
+// Each page after the first starts after the last key of the previous page.
+// Use Bound() to build a key range.
+func (s *ObjectStore) ScanPages(krv js.Value, pageSize int, cb func(keys, vals []js.Value) error) error {
+	return scanPages(krv, pageSize, s.getPage, cb)
 }
 
 // Count counts keys matching the optional query.
 func (s *ObjectStore) Count(query interface{}) (_ int, e error) {
 	defer func() {
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e, _ = recoveredError(err)
 		}
 	}()
 	query = MaybeConvertValueToJs(query)
@@ -124,10 +240,23 @@ func (s *ObjectStore) Count(query interface{}) (_ int, e error) {
 func (s *ObjectStore) OpenCursor(krv js.Value) (c *Cursor, e error) {
 	defer func() {
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e, _ = recoveredError(err)
 		}
 	}()
 
 	req := s.val.Call("openCursor", krv)
 	return NewCursor(req), nil
 }
+
+// OpenKeyCursor opens a cursor over keys only with a optional IDBKeyRange.
+// The cursor values have an undefined Value.
+func (s *ObjectStore) OpenKeyCursor(krv js.Value) (c *Cursor, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+
+	req := s.val.Call("openKeyCursor", krv)
+	return NewCursor(req), nil
+}
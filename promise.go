@@ -0,0 +1,58 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"errors"
+	"syscall/js"
+)
+
+// awaitPromise waits for a js Promise to settle.
+//
+// Note: waiting yields to the event loop, which commits any active transaction.
+func awaitPromise(p js.Value) (js.Value, error) {
+	if inAtomicCallback {
+		return js.Undefined(), ErrBlockingInAtomicUpdate
+	}
+	type result struct {
+		val js.Value
+		err error
+	}
+	// buffered: the callbacks must not block.
+	ch := make(chan result, 1)
+	onResolve := js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+		val := js.Undefined()
+		if len(dats) != 0 {
+			val = dats[0]
+		}
+		ch <- result{val: val}
+		return nil
+	})
+	defer onResolve.Release()
+	onReject := js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+		reason := js.Undefined()
+		if len(dats) != 0 {
+			reason = dats[0]
+		}
+		ch <- result{err: jsError(reason)}
+		return nil
+	})
+	defer onReject.Release()
+	p.Call("then", onResolve, onReject)
+	res := <-ch
+	return res.val, res.err
+}
+
+// jsError converts a js error or rejection reason to an error.
+func jsError(val js.Value) error {
+	if val.Type() == js.TypeObject {
+		if msg := val.Get("message"); msg.Type() == js.TypeString {
+			return domError(val)
+		}
+	}
+	if val.IsUndefined() || val.IsNull() {
+		return errors.New("promise rejected")
+	}
+	return errors.New(val.Call("toString").String())
+}
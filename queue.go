@@ -0,0 +1,291 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"syscall/js"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultQueueMaxAttempts is the default number of times a job is leased
+// before it is dead-lettered.
+const DefaultQueueMaxAttempts = 5
+
+// QueueVisibleAtIndex is the index of queued jobs by the time they become visible.
+const QueueVisibleAtIndex = "visibleAt"
+
+// CreateQueueObjectStore creates an object store for a Queue or its dead letters.
+// Does nothing if it already exists.
+//
+// Jobs are keyed by a key generator, so they are ordered by enqueue time.
+// Also creates the QueueVisibleAtIndex index, if missing.
+func (d *DatabaseUpdate) CreateQueueObjectStore(storeID string) error {
+	if !d.ContainsObjectStore(storeID) {
+		if err := d.CreateObjectStore(storeID, NewCreateObjectStoreOpts("", true)); err != nil {
+			return err
+		}
+	}
+	store, err := d.txn.GetObjectStore(storeID)
+	if err != nil {
+		return err
+	}
+	for _, name := range store.IndexNames() {
+		if name == QueueVisibleAtIndex {
+			return nil
+		}
+	}
+	_, err = store.CreateIndex(QueueVisibleAtIndex, NewKeyPath("visibleAt"), nil)
+	return err
+}
+
+// QueueOpts are options for a Queue.
+type QueueOpts struct {
+	// MaxAttempts is the number of times a job is leased before it is moved
+	// to the dead letter store. If zero, uses DefaultQueueMaxAttempts.
+	MaxAttempts int
+	// DeadLetterStoreID is the object store for jobs which exceeded
+	// MaxAttempts. If empty, the jobs are deleted.
+	DeadLetterStoreID string
+}
+
+// Job is a job in a Queue.
+type Job struct {
+	// ID is the job id, increasing in enqueue order.
+	ID int64
+	// Payload is the job payload.
+	Payload []byte
+	// Attempts is the number of times the job has been leased.
+	Attempts int
+	// EnqueuedAt is the time the job was enqueued.
+	EnqueuedAt time.Time
+	// LeaseExpires is the time the lease expires and the job becomes visible again.
+	// Zero if the job is not leased.
+	LeaseExpires time.Time
+	// LastError is the reason passed to the most recent Nack, if any.
+	LastError string
+
+	// leaseID identifies the lease held on the job.
+	leaseID string
+}
+
+// jobFromJs converts a stored job record.
+func jobFromJs(id, rec js.Value) *Job {
+	job := &Job{
+		ID:         int64(id.Float()),
+		Payload:    CopyByteSliceFromJs(rec.Get("payload")),
+		Attempts:   rec.Get("attempts").Int(),
+		EnqueuedAt: time.UnixMilli(int64(rec.Get("enqueuedAt").Float())).UTC(),
+	}
+	if lease := rec.Get("lease"); lease.Type() == js.TypeString {
+		job.leaseID = lease.String()
+		job.LeaseExpires = time.UnixMilli(int64(rec.Get("visibleAt").Float())).UTC()
+	}
+	if lastErr := rec.Get("lastError"); lastErr.Type() == js.TypeString {
+		job.LastError = lastErr.String()
+	}
+	return job
+}
+
+// Queue is a durable job queue on top of a DurableTransaction.
+//
+// Jobs are leased in the order they become visible, then in enqueue order:
+// new jobs are leased before retried jobs. A leased job is hidden from other
+// consumers, in this and other tabs, until it is acknowledged with Ack, or
+// returned with Nack, or the lease expires. Leases are committed with the
+// transaction: commit after Lease before processing the jobs, then Ack or
+// Nack them in a later transaction. A job leased MaxAttempts times without
+// an Ack is moved to the dead letter store.
+//
+// The transaction must include the queue object store and the dead letter
+// object store, if any, created with DatabaseUpdate.CreateQueueObjectStore.
+type Queue struct {
+	tx    *DurableTransaction
+	store *DurableObjectStore
+	opts  QueueOpts
+}
+
+// NewQueue constructs a Queue with a transaction and object store.
+//
+// opts is optional.
+func NewQueue(tx *DurableTransaction, storeID string, opts *QueueOpts) (*Queue, error) {
+	store, err := tx.GetObjectStore(storeID)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{tx: tx, store: store}
+	if opts != nil {
+		q.opts = *opts
+	}
+	if q.opts.MaxAttempts <= 0 {
+		q.opts.MaxAttempts = DefaultQueueMaxAttempts
+	}
+	return q, nil
+}
+
+// Enqueue adds a job to the end of the queue.
+//
+// The result resolves to the job id once the write is applied.
+func (q *Queue) Enqueue(payload []byte) (*DurableResult, error) {
+	rec := js.Global().Get("Object").New()
+	rec.Set("payload", CopyByteSliceToJs(payload))
+	rec.Set("attempts", 0)
+	rec.Set("enqueuedAt", jsNow())
+	rec.Set("visibleAt", 0)
+	return q.store.Add(rec, nil)
+}
+
+// Len returns the number of jobs in the queue, including leased jobs.
+func (q *Queue) Len() (int, error) {
+	return q.store.Count(nil)
+}
+
+// Lease leases up to n visible jobs.
+//
+// Scans the QueueVisibleAtIndex index up to the current time. The jobs are
+// hidden from other consumers until the visibility timeout elapses. Jobs
+// which already reached MaxAttempts are moved to the dead letter store
+// instead of being leased.
+func (q *Queue) Lease(n int, visibilityTimeout time.Duration) ([]*Job, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if visibilityTimeout <= 0 {
+		return nil, errors.Errorf("visibility timeout must be positive: %v", visibilityTimeout)
+	}
+	var leaseBuf [16]byte
+	if _, err := rand.Read(leaseBuf[:]); err != nil {
+		return nil, err
+	}
+	leaseID := hex.EncodeToString(leaseBuf[:])
+
+	var jobs []*Job
+	var now float64
+	err := q.store.runAtomic(func(stor *ObjectStore) js.Value {
+		jobs = nil
+		now = jsNow()
+		// leased jobs are moved past now, so the cursor does not visit them again.
+		index := stor.val.Call("index", QueueVisibleAtIndex)
+		return index.Call("openCursor", UpperBound(now, false))
+	}, func(req js.Value, w *atomicWriter) (bool, error) {
+		cursor := req.Get("result")
+		if !cursor.Truthy() {
+			return false, nil
+		}
+		id, rec := cursor.Get("primaryKey"), cursor.Get("value")
+		switch {
+		case rec.Get("attempts").Int() >= q.opts.MaxAttempts:
+			if err := q.deadLetter(w, id, rec); err != nil {
+				return false, err
+			}
+		default:
+			rec.Set("attempts", rec.Get("attempts").Int()+1)
+			rec.Set("visibleAt", now+float64(visibilityTimeout.Milliseconds()))
+			rec.Set("lease", leaseID)
+			if err := w.write(q.store.id, newDurableOp(durableOpPut, id, rec)); err != nil {
+				return false, err
+			}
+			jobs = append(jobs, jobFromJs(id, rec))
+			if len(jobs) >= n {
+				return false, nil
+			}
+		}
+		cursor.Call("continue")
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// deadLetter moves a job to the dead letter store, or deletes it if there is none.
+func (q *Queue) deadLetter(w *atomicWriter, id, rec js.Value) error {
+	if q.opts.DeadLetterStoreID != "" {
+		rec.Delete("lease")
+		rec.Set("visibleAt", 0)
+		if err := w.write(q.opts.DeadLetterStoreID, newDurableOp(durableOpPut, id, rec)); err != nil {
+			return err
+		}
+	}
+	return w.write(q.store.id, newDurableOp(durableOpDelete, id, nil))
+}
+
+// updateLeased reads a leased job and calls update if the lease is still held.
+//
+// Returns ErrLeaseLost if the job was deleted or leased by another consumer.
+func (q *Queue) updateLeased(job *Job, update func(w *atomicWriter, id, rec js.Value) error) error {
+	if job.leaseID == "" {
+		return ErrLeaseLost
+	}
+	id := js.ValueOf(float64(job.ID))
+	return q.store.runAtomic(func(stor *ObjectStore) js.Value {
+		return stor.val.Call("get", id)
+	}, func(req js.Value, w *atomicWriter) (bool, error) {
+		rec := req.Get("result")
+		if rec.Type() != js.TypeObject || rec.Get("lease").Type() != js.TypeString ||
+			rec.Get("lease").String() != job.leaseID {
+			return false, ErrLeaseLost
+		}
+		return false, update(w, id, rec)
+	})
+}
+
+// Ack removes a leased job from the queue after it was processed.
+//
+// Returns ErrLeaseLost if the lease expired and the job was leased again.
+func (q *Queue) Ack(job *Job) error {
+	return q.updateLeased(job, func(w *atomicWriter, id, rec js.Value) error {
+		return w.write(q.store.id, newDurableOp(durableOpDelete, id, nil))
+	})
+}
+
+// Nack returns a leased job to the queue after processing failed.
+//
+// The job becomes visible again after retryDelay. If the job reached
+// MaxAttempts, it is moved to the dead letter store. reason is recorded as
+// the LastError of the job. Returns ErrLeaseLost if the lease expired and the
+// job was leased again.
+func (q *Queue) Nack(job *Job, retryDelay time.Duration, reason string) error {
+	return q.updateLeased(job, func(w *atomicWriter, id, rec js.Value) error {
+		if reason != "" {
+			rec.Set("lastError", reason)
+		}
+		if rec.Get("attempts").Int() >= q.opts.MaxAttempts {
+			return q.deadLetter(w, id, rec)
+		}
+		rec.Delete("lease")
+		rec.Set("visibleAt", jsNow()+float64(retryDelay.Milliseconds()))
+		return w.write(q.store.id, newDurableOp(durableOpPut, id, rec))
+	})
+}
+
+// DeadLetters returns up to n jobs from the dead letter store in enqueue order.
+// A count of zero returns all jobs.
+func (q *Queue) DeadLetters(n int) ([]*Job, error) {
+	if q.opts.DeadLetterStoreID == "" {
+		return nil, nil
+	}
+	store, err := q.tx.GetObjectStore(q.opts.DeadLetterStoreID)
+	if err != nil {
+		return nil, err
+	}
+	var keys, vals []js.Value
+	_, err = store.durableRead(func(stor *ObjectStore) (js.Value, error) {
+		var rerr error
+		keys, vals, rerr = stor.getPage(js.Undefined(), n)
+		return js.Undefined(), rerr
+	})
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, len(keys))
+	for i, key := range keys {
+		jobs[i] = jobFromJs(key, vals[i])
+	}
+	return jobs, nil
+}
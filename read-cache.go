@@ -0,0 +1,290 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"container/list"
+	"sync"
+	"syscall/js"
+)
+
+// DefaultReadCacheMaxBytes is the default size limit of the read cache.
+const DefaultReadCacheMaxBytes = 16 * 1024 * 1024
+
+// ReadCacheOpts are the options for the in-memory read cache.
+type ReadCacheOpts struct {
+	// MaxBytes is the maximum total size of the cached values.
+	// If zero, uses DefaultReadCacheMaxBytes.
+	MaxBytes uint64
+	// MaxEntries is the maximum number of cached values, zero for no limit.
+	MaxEntries int
+}
+
+// ReadCacheStats are the counters and usage of the read cache.
+type ReadCacheStats struct {
+	// Hits is the number of reads served from the cache.
+	Hits uint64
+	// Misses is the number of reads not found in the cache.
+	Misses uint64
+	// Evictions is the number of values evicted to stay within the limits.
+	Evictions uint64
+	// Invalidations is the number of values dropped due to changes in other tabs.
+	Invalidations uint64
+	// Bytes is the total size of the cached values.
+	Bytes uint64
+	// Entries is the number of cached values.
+	Entries int
+}
+
+// readCache is a process-local cache of Kvtx values with LRU eviction.
+type readCache struct {
+	opts ReadCacheOpts
+
+	mtx    sync.Mutex
+	stores map[string]*readCacheStore
+	// lru is the list of *readCacheEntry, most recently used first.
+	lru   *list.List
+	stats ReadCacheStats
+	// ownsFeed indicates the change feed was enabled by EnableReadCache.
+	ownsFeed bool
+}
+
+// readCacheStore contains the cached values for an object store.
+type readCacheStore struct {
+	// gen is incremented when values in the store change.
+	// A value read from IndexedDB is cached only if gen did not change.
+	gen     uint64
+	entries map[string]*list.Element
+}
+
+// readCacheEntry is a cached value.
+type readCacheEntry struct {
+	store string
+	key   string
+	val   []byte
+}
+
+// EnableReadCache caches values read with Kvtx in memory.
+//
+// Values are cached by object store and key for this Database handle. Values
+// written by committed durable transactions on this handle replace the cached
+// values. Changes from other tabs invalidate cached values through the change
+// feed, which is enabled if needed: every tab writing to the database must
+// enable the change feed, and the invalidation arrives shortly after the
+// other tab commits. Reads within a READWRITE transaction bypass the cache.
+// Values with an expiry are not cached.
+//
+// opts is optional.
+func (d *Database) EnableReadCache(opts *ReadCacheOpts) error {
+	if d.readCache != nil {
+		return nil
+	}
+	ownsFeed := d.feed == nil
+	if err := d.EnableChangeFeed(); err != nil {
+		return err
+	}
+	c := &readCache{
+		stores:   make(map[string]*readCacheStore),
+		lru:      list.New(),
+		ownsFeed: ownsFeed,
+	}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.MaxBytes == 0 {
+		c.opts.MaxBytes = DefaultReadCacheMaxBytes
+	}
+	d.feed.mtx.Lock()
+	d.feed.readCache = c
+	d.feed.mtx.Unlock()
+	d.readCache = c
+	return nil
+}
+
+// DisableReadCache drops the read cache.
+//
+// Also disables the change feed if EnableReadCache enabled it and there are
+// no Watchers.
+func (d *Database) DisableReadCache() {
+	c := d.readCache
+	if c == nil {
+		return
+	}
+	d.readCache = nil
+	f := d.feed
+	if f == nil {
+		return
+	}
+	f.mtx.Lock()
+	f.readCache = nil
+	watching := len(f.watchers) != 0
+	f.mtx.Unlock()
+	if c.ownsFeed && !watching {
+		d.DisableChangeFeed()
+	}
+}
+
+// GetReadCacheStats returns the counters and usage of the read cache.
+// Returns zero values if the read cache is not enabled.
+func (d *Database) GetReadCacheStats() ReadCacheStats {
+	c := d.readCache
+	if c == nil {
+		return ReadCacheStats{}
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.stats
+}
+
+// getStore returns the entries for a store, creating it if needed.
+// Expects mtx to be locked.
+func (c *readCache) getStore(store string) *readCacheStore {
+	s, ok := c.stores[store]
+	if !ok {
+		s = &readCacheStore{entries: make(map[string]*list.Element)}
+		c.stores[store] = s
+	}
+	return s
+}
+
+// lookup returns a cached value.
+//
+// The value must not be modified. If not found, returns the generation of
+// the store to pass to fill.
+func (c *readCache) lookup(store string, key []byte) (val []byte, gen uint64, found bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	s := c.getStore(store)
+	if elem, ok := s.entries[string(key)]; ok {
+		c.lru.MoveToFront(elem)
+		c.stats.Hits++
+		return elem.Value.(*readCacheEntry).val, s.gen, true
+	}
+	c.stats.Misses++
+	return nil, s.gen, false
+}
+
+// fill caches a value read from IndexedDB.
+//
+// Does nothing if the store changed since the generation was returned by lookup.
+func (c *readCache) fill(store string, key, val []byte, gen uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	s := c.getStore(store)
+	if s.gen != gen {
+		return
+	}
+	c.set(s, store, string(key), val)
+}
+
+// set caches a value, evicting the least recently used values if needed.
+// Expects mtx to be locked.
+func (c *readCache) set(s *readCacheStore, store, key string, val []byte) {
+	if uint64(len(val)) > c.opts.MaxBytes {
+		c.remove(s, key)
+		return
+	}
+	if elem, ok := s.entries[key]; ok {
+		ent := elem.Value.(*readCacheEntry)
+		c.stats.Bytes -= uint64(len(ent.val))
+		ent.val = val
+		c.stats.Bytes += uint64(len(val))
+		c.lru.MoveToFront(elem)
+	} else {
+		s.entries[key] = c.lru.PushFront(&readCacheEntry{store: store, key: key, val: val})
+		c.stats.Bytes += uint64(len(val))
+		c.stats.Entries++
+	}
+	for c.stats.Bytes > c.opts.MaxBytes || (c.opts.MaxEntries != 0 && c.stats.Entries > c.opts.MaxEntries) {
+		ent := c.lru.Back().Value.(*readCacheEntry)
+		c.remove(c.stores[ent.store], ent.key)
+		c.stats.Evictions++
+	}
+}
+
+// remove removes a cached value, returning if it was cached.
+// Expects mtx to be locked.
+func (c *readCache) remove(s *readCacheStore, key string) bool {
+	elem, ok := s.entries[key]
+	if !ok {
+		return false
+	}
+	ent := c.lru.Remove(elem).(*readCacheEntry)
+	delete(s.entries, key)
+	c.stats.Bytes -= uint64(len(ent.val))
+	c.stats.Entries--
+	return true
+}
+
+// removeStore removes all cached values for a store, returning the number removed.
+// Expects mtx to be locked.
+func (c *readCache) removeStore(s *readCacheStore) int {
+	n := len(s.entries)
+	for key := range s.entries {
+		c.remove(s, key)
+	}
+	return n
+}
+
+// applyCommit updates the cache with the ops of a committed transaction.
+func (c *readCache) applyCommit(changes []durableChange) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, change := range changes {
+		s := c.getStore(change.store)
+		s.gen++
+		op := change.op
+		if op.kind == durableOpClear {
+			c.removeStore(s)
+			continue
+		}
+		key, ok := op.key.(js.Value)
+		if !ok {
+			key = js.ValueOf(op.key)
+		}
+		if key.IsUndefined() || key.IsNull() {
+			// the key was generated or extracted from the value.
+			c.removeStore(s)
+			continue
+		}
+		if key.Type() != js.TypeObject {
+			// only binary keys are cached.
+			continue
+		}
+		if key.InstanceOf(js.Global().Get("IDBKeyRange")) {
+			c.removeStore(s)
+			continue
+		}
+		keyBytes, err := CopyBinaryFromJs(key)
+		if err != nil {
+			continue
+		}
+		val, ok := op.value.(js.Value)
+		if op.kind != durableOpDelete && ok && val.Type() == js.TypeObject && val.InstanceOf(jsUint8Array) {
+			c.set(s, change.store, string(keyBytes), CopyByteSliceFromJs(val))
+		} else {
+			c.remove(s, string(keyBytes))
+		}
+	}
+}
+
+// invalidate drops cached values changed in another tab.
+func (c *readCache) invalidate(store string, keys []js.Value, allKeys bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	s := c.getStore(store)
+	s.gen++
+	if allKeys {
+		c.stats.Invalidations += uint64(c.removeStore(s))
+		return
+	}
+	for _, key := range keys {
+		if key.Type() != js.TypeObject || !key.InstanceOf(jsUint8Array) {
+			continue
+		}
+		if c.remove(s, string(CopyByteSliceFromJs(key))) {
+			c.stats.Invalidations++
+		}
+	}
+}
@@ -3,23 +3,27 @@
 package indexeddb
 
 import (
-	"errors"
 	"syscall/js"
 )
 
 // WaitRequest waits for an IDBRequest.
 // Registers onsuccess and onerror
+//
+// Returns ErrBlockingInAtomicUpdate if called from an atomic update callback.
 func WaitRequest(obj js.Value) (js.Value, error) {
 	ret := func() (js.Value, error) {
 		var err error
 		if o := obj.Get("error"); o.Truthy() {
-			err = errors.New(o.Get("message").String())
+			err = domError(o)
 		}
 		return obj.Get("result"), err
 	}
 	if obj.Get("readyState").String() == "done" {
 		return ret()
 	}
+	if inAtomicCallback {
+		return js.Undefined(), ErrBlockingInAtomicUpdate
+	}
 	errCh := make(chan struct{}, 1)
 	rerr := func() {
 		select {
@@ -0,0 +1,91 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+// Schema is a serializable snapshot of the database schema.
+type Schema struct {
+	// Name is the database name.
+	Name string `json:"name"`
+	// Version is the database version.
+	Version int `json:"version"`
+	// ObjectStores is the list of object stores sorted by name.
+	ObjectStores []*ObjectStoreSchema `json:"objectStores"`
+}
+
+// ObjectStoreSchema is the schema of an object store.
+type ObjectStoreSchema struct {
+	// Name is the object store name.
+	Name string `json:"name"`
+	// KeyPath is the key path, nil if the store uses out-of-line keys.
+	KeyPath *KeyPath `json:"keyPath,omitempty"`
+	// AutoIncrement indicates the store has a key generator.
+	AutoIncrement bool `json:"autoIncrement,omitempty"`
+	// Indexes is the list of indexes sorted by name.
+	Indexes []*IndexSchema `json:"indexes,omitempty"`
+}
+
+// IndexSchema is the schema of an index.
+type IndexSchema struct {
+	// Name is the index name.
+	Name string `json:"name"`
+	// KeyPath is the index key path.
+	KeyPath *KeyPath `json:"keyPath"`
+	// Unique indicates the index disallows duplicate keys.
+	Unique bool `json:"unique,omitempty"`
+	// MultiEntry indicates the index adds an entry for each element of array keys.
+	MultiEntry bool `json:"multiEntry,omitempty"`
+}
+
+// DescribeSchema returns a snapshot of the database schema.
+func (d *Database) DescribeSchema() (*Schema, error) {
+	schema := &Schema{
+		Name:    d.GetName(),
+		Version: d.GetVersion(),
+	}
+	storeNames := d.ObjectStoreNames()
+	if len(storeNames) == 0 {
+		return schema, nil
+	}
+
+	txn, err := d.Transaction(storeNames, READONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Abort()
+
+	schema.ObjectStores = make([]*ObjectStoreSchema, len(storeNames))
+	for i, storeName := range storeNames {
+		store, err := txn.GetObjectStore(storeName)
+		if err != nil {
+			return nil, err
+		}
+		schema.ObjectStores[i], err = store.DescribeSchema()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+// DescribeSchema returns a snapshot of the object store schema.
+func (s *ObjectStore) DescribeSchema() (*ObjectStoreSchema, error) {
+	schema := &ObjectStoreSchema{
+		Name:          s.GetName(),
+		KeyPath:       s.KeyPath(),
+		AutoIncrement: s.AutoIncrement(),
+	}
+	for _, indexName := range s.IndexNames() {
+		index, err := s.Index(indexName)
+		if err != nil {
+			return nil, err
+		}
+		schema.Indexes = append(schema.Indexes, &IndexSchema{
+			Name:       indexName,
+			KeyPath:    index.KeyPath(),
+			Unique:     index.Unique(),
+			MultiEntry: index.MultiEntry(),
+		})
+	}
+	return schema, nil
+}
@@ -0,0 +1,245 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"sort"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultQuotaWatchInterval is the default interval between storage estimates.
+const DefaultQuotaWatchInterval = 30 * time.Second
+
+// Storage manages the storage quota and persistence of the origin.
+//
+// Wraps the StorageManager API (navigator.storage).
+type Storage struct {
+	val js.Value
+}
+
+// GlobalStorage returns the global StorageManager object.
+// Returns nil if the StorageManager API is not available.
+func GlobalStorage() *Storage {
+	nav := js.Global().Get("navigator")
+	if !nav.Truthy() {
+		return nil
+	}
+	val := nav.Get("storage")
+	if !val.Truthy() {
+		return nil
+	}
+	return &Storage{val: val}
+}
+
+// StorageEstimate is an estimate of the storage used by the origin.
+type StorageEstimate struct {
+	// Usage is the estimated number of bytes used.
+	Usage uint64
+	// Quota is the estimated number of bytes available.
+	Quota uint64
+}
+
+// Ratio returns the fraction of the quota in use.
+// Returns 0 if the quota is unknown.
+func (e *StorageEstimate) Ratio() float64 {
+	if e.Quota == 0 {
+		return 0
+	}
+	return float64(e.Usage) / float64(e.Quota)
+}
+
+// Estimate returns an estimate of the storage usage and quota.
+//
+// The estimate is deliberately imprecise and may lag recent writes.
+func (s *Storage) Estimate() (*StorageEstimate, error) {
+	val, err := s.call("estimate")
+	if err != nil {
+		return nil, err
+	}
+	est := &StorageEstimate{}
+	if usage := val.Get("usage"); usage.Type() == js.TypeNumber {
+		est.Usage = uint64(usage.Float())
+	}
+	if quota := val.Get("quota"); quota.Type() == js.TypeNumber {
+		est.Quota = uint64(quota.Float())
+	}
+	return est, nil
+}
+
+// Persist requests permission to make the storage of the origin persistent.
+//
+// Persistent storage is not evicted by the browser under storage pressure.
+// Returns if the storage is persistent after the request.
+func (s *Storage) Persist() (bool, error) {
+	val, err := s.call("persist")
+	if err != nil {
+		return false, err
+	}
+	return val.Truthy(), nil
+}
+
+// Persisted checks if the storage of the origin is persistent.
+func (s *Storage) Persisted() (bool, error) {
+	val, err := s.call("persisted")
+	if err != nil {
+		return false, err
+	}
+	return val.Truthy(), nil
+}
+
+// call calls a StorageManager method and waits for the returned promise.
+func (s *Storage) call(method string) (v js.Value, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	if s.val.Get(method).Type() != js.TypeFunction {
+		return js.Undefined(), errors.Errorf("StorageManager.%s is not available", method)
+	}
+	return awaitPromise(s.val.Call(method))
+}
+
+// QuotaEvent is emitted when the storage usage crosses a threshold.
+type QuotaEvent struct {
+	// Estimate is the storage estimate.
+	Estimate *StorageEstimate
+	// Threshold is the highest threshold the usage ratio is at or above.
+	// Zero if the usage is below all thresholds.
+	Threshold float64
+	// Rising indicates the usage rose above the threshold.
+	// If false, the usage fell below the previous threshold.
+	Rising bool
+}
+
+// QuotaWatcher emits events when the storage usage crosses thresholds.
+type QuotaWatcher struct {
+	estimate   func() (*StorageEstimate, error)
+	thresholds []float64
+	interval   time.Duration
+
+	ch     chan *QuotaEvent
+	check  chan struct{}
+	closed chan struct{}
+	once   sync.Once
+
+	mtx sync.Mutex
+	err error
+}
+
+// WatchQuota polls the storage estimate and emits events when the usage
+// ratio crosses one of the thresholds.
+//
+// Thresholds are fractions of the quota in (0, 1], for example 0.8 and 0.95.
+// If the usage is already above a threshold, an event is emitted after the
+// first estimate. An interval of zero uses DefaultQuotaWatchInterval. Call
+// Check after large writes to estimate again without waiting for the interval.
+// Call Close to stop watching.
+func (s *Storage) WatchQuota(interval time.Duration, thresholds ...float64) (*QuotaWatcher, error) {
+	return newQuotaWatcher(s.Estimate, interval, thresholds)
+}
+
+// newQuotaWatcher constructs and starts a QuotaWatcher.
+func newQuotaWatcher(
+	estimate func() (*StorageEstimate, error),
+	interval time.Duration,
+	thresholds []float64,
+) (*QuotaWatcher, error) {
+	if len(thresholds) == 0 {
+		return nil, errors.New("at least one quota threshold is required")
+	}
+	sorted := make([]float64, len(thresholds))
+	copy(sorted, thresholds)
+	sort.Float64s(sorted)
+	for _, th := range sorted {
+		if th <= 0 || th > 1 {
+			return nil, errors.Errorf("quota threshold must be in (0, 1]: %v", th)
+		}
+	}
+	if interval <= 0 {
+		interval = DefaultQuotaWatchInterval
+	}
+	w := &QuotaWatcher{
+		estimate:   estimate,
+		thresholds: sorted,
+		interval:   interval,
+		ch:         make(chan *QuotaEvent),
+		check:      make(chan struct{}, 1),
+		closed:     make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Events returns the channel of quota events.
+// The channel is closed when the QuotaWatcher is closed.
+func (w *QuotaWatcher) Events() <-chan *QuotaEvent {
+	return w.ch
+}
+
+// Check requests an estimate without waiting for the interval.
+func (w *QuotaWatcher) Check() {
+	select {
+	case w.check <- struct{}{}:
+	default:
+	}
+}
+
+// Err returns the error from the most recent estimate, if any.
+func (w *QuotaWatcher) Err() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.err
+}
+
+// Close stops watching the storage usage.
+func (w *QuotaWatcher) Close() {
+	w.once.Do(func() {
+		close(w.closed)
+	})
+}
+
+// level returns the number of thresholds at or below the usage ratio.
+func (w *QuotaWatcher) level(ratio float64) int {
+	return sort.Search(len(w.thresholds), func(i int) bool {
+		return w.thresholds[i] > ratio
+	})
+}
+
+// run polls the estimate until closed.
+func (w *QuotaWatcher) run() {
+	defer close(w.ch)
+	var prev int
+	for {
+		est, err := w.estimate()
+		w.mtx.Lock()
+		w.err = err
+		w.mtx.Unlock()
+		if err == nil {
+			if lvl := w.level(est.Ratio()); lvl != prev {
+				ev := &QuotaEvent{Estimate: est, Rising: lvl > prev}
+				if lvl != 0 {
+					ev.Threshold = w.thresholds[lvl-1]
+				}
+				prev = lvl
+				select {
+				case <-w.closed:
+					return
+				case w.ch <- ev:
+				}
+			}
+		}
+
+		select {
+		case <-w.closed:
+			return
+		case <-w.check:
+		case <-time.After(w.interval):
+		}
+	}
+}
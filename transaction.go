@@ -13,35 +13,171 @@ import (
 type Transaction struct {
 	val       js.Value
 	abortOnce sync.Once
+	// done is closed when the transaction completes or aborts.
+	done chan struct{}
+
+	// mtx guards below fields
+	mtx sync.Mutex
+	// err is the error the transaction aborted with, if any.
+	err error
+	// onComplete, onAbort, onError are the registered callbacks.
+	onComplete []func()
+	onAbort    []func(err error)
+	onError    []func(err error)
+	// funcs are the registered event listeners.
+	funcs []js.Func
+}
+
+// ErrTransactionAborted is returned if the transaction was aborted without an error.
+var ErrTransactionAborted = errors.New("transaction aborted")
+
+// NewTransaction constructs a Transaction with an IDBTransaction js object.
+//
+// Registers listeners for the complete, abort, and error events.
+func NewTransaction(val js.Value) *Transaction {
+	t := &Transaction{val: val, done: make(chan struct{})}
+	t.addEventListener("complete", func(event js.Value) {
+		t.finish(nil)
+	})
+	t.addEventListener("abort", func(event js.Value) {
+		err := ErrTransactionAborted
+		if o := t.val.Get("error"); o.Truthy() {
+			err = domError(o)
+		}
+		t.finish(err)
+	})
+	t.addEventListener("error", func(event js.Value) {
+		err := errors.New("transaction error")
+		if o := event.Get("target").Get("error"); o.Truthy() {
+			err = domError(o)
+		}
+		t.mtx.Lock()
+		cbs := t.onError
+		t.mtx.Unlock()
+		for _, cb := range cbs {
+			cb(err)
+		}
+	})
+	return t
+}
+
+// addEventListener registers an event listener on the transaction.
+func (t *Transaction) addEventListener(event string, cb func(event js.Value)) {
+	fn := js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+		cb(dats[0])
+		return nil
+	})
+	t.funcs = append(t.funcs, fn)
+	t.val.Call("addEventListener", event, fn)
+}
+
+// finish marks the transaction as done and calls the callbacks.
+func (t *Transaction) finish(err error) {
+	t.mtx.Lock()
+	select {
+	case <-t.done:
+		t.mtx.Unlock()
+		return
+	default:
+	}
+	t.err = err
+	close(t.done)
+	onComplete, onAbort := t.onComplete, t.onAbort
+	t.onComplete, t.onAbort, t.onError = nil, nil, nil
+	funcs := t.funcs
+	t.funcs = nil
+	t.mtx.Unlock()
+
+	if err == nil {
+		for _, cb := range onComplete {
+			cb()
+		}
+	} else {
+		for _, cb := range onAbort {
+			cb(err)
+		}
+	}
+	// release the listeners once the current event has been dispatched.
+	go func() {
+		for _, fn := range funcs {
+			fn.Release()
+		}
+	}()
 }
 
 // WaitTransactionComplete waits for oncomplete on a transaction.
-// Registers onsuccess and onerror.
+// Registers listeners for the complete, abort, and error events.
 // Returns transaction.error if set, or nil.
 // Call commit before calling this.
 func WaitTransactionComplete(obj js.Value) error {
-	ret := func() error {
-		var err error
-		if o := obj.Get("error"); o.Truthy() {
-			err = errors.New(o.Get("message").String())
+	return NewTransaction(obj).WaitComplete()
+}
+
+// OnComplete registers a callback called when the transaction completes.
+//
+// The callback is called from the js event handler and must not block.
+// If the transaction already completed, calls the callback immediately.
+func (t *Transaction) OnComplete(cb func()) {
+	t.mtx.Lock()
+	select {
+	case <-t.done:
+		err := t.err
+		t.mtx.Unlock()
+		if err == nil {
+			cb()
 		}
-		return err
+		return
+	default:
 	}
-	errCh := make(chan struct{}, 1)
-	rerr := func() {
-		select {
-		case errCh <- struct{}{}:
-		default:
+	t.onComplete = append(t.onComplete, cb)
+	t.mtx.Unlock()
+}
+
+// OnAbort registers a callback called when the transaction aborts.
+//
+// The callback is called from the js event handler and must not block.
+// If the transaction already aborted, calls the callback immediately.
+func (t *Transaction) OnAbort(cb func(err error)) {
+	t.mtx.Lock()
+	select {
+	case <-t.done:
+		err := t.err
+		t.mtx.Unlock()
+		if err != nil {
+			cb(err)
 		}
+		return
+	default:
 	}
-	cb := js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
-		go rerr()
-		return nil
-	})
-	obj.Set("onerror", cb)
-	obj.Set("oncomplete", cb)
-	<-errCh
-	return ret()
+	t.onAbort = append(t.onAbort, cb)
+	t.mtx.Unlock()
+}
+
+// OnError registers a callback called when a request in the transaction fails.
+//
+// An error usually also aborts the transaction.
+// The callback is called from the js event handler and must not block.
+func (t *Transaction) OnError(cb func(err error)) {
+	t.mtx.Lock()
+	select {
+	case <-t.done:
+	default:
+		t.onError = append(t.onError, cb)
+	}
+	t.mtx.Unlock()
+}
+
+// Done returns a channel which is closed when the transaction completes or aborts.
+func (t *Transaction) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns the error the transaction aborted with.
+// Returns nil if the transaction completed or is still active.
+func (t *Transaction) Err() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.err
 }
 
 // GetMode returns the transaction mode.
@@ -49,11 +185,21 @@ func (t *Transaction) GetMode() TransactionMode {
 	return TransactionMode(t.val.Get("mode").String())
 }
 
+// GetDurability returns the transaction durability hint.
+// Returns DurabilityDefault if the browser does not support durability hints.
+func (t *Transaction) GetDurability() TransactionDurability {
+	dur := t.val.Get("durability")
+	if dur.Type() != js.TypeString {
+		return DurabilityDefault
+	}
+	return TransactionDurability(dur.String())
+}
+
 // GetObjectStore returns a object store.
 func (t *Transaction) GetObjectStore(id string) (o *ObjectStore, e error) {
 	defer func() {
 		if err := recover(); err != nil {
-			e, _ = err.(error)
+			e, _ = recoveredError(err)
 		}
 	}()
 
@@ -100,9 +246,10 @@ func (t *Transaction) Commit() {
 	})
 }
 
-// WaitComplete waits for the transaction to complete.
+// WaitComplete waits for the transaction to complete or abort.
 // Call commit() first.
 // Returns any error if set on the transaction.
 func (t *Transaction) WaitComplete() error {
-	return WaitTransactionComplete(t.val)
+	<-t.done
+	return t.Err()
 }
@@ -0,0 +1,375 @@
+// Package tuple implements an order-preserving encoding of tuples to bytes.
+//
+// Packed tuples compare bytewise in the same order as the tuples compare
+// element by element, which makes them suitable as composite keys for
+// IndexedDB binary keys and Kvtx. A packed tuple is also a prefix of every
+// packed tuple which starts with the same elements, so Kvtx.ScanPrefix can
+// be used to iterate over a subspace of keys.
+//
+// Elements are ordered by type first: nil, []byte, string, nested Tuple,
+// integers, floats, then booleans.
+package tuple
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	codeNil         = 0x00
+	codeBytes       = 0x01
+	codeString      = 0x02
+	codeNested      = 0x05
+	codeIntZero     = 0x14
+	codeNegIntStart = 0x0c
+	codePosIntEnd   = 0x1c
+	codeFloat64     = 0x21
+	codeFalse       = 0x26
+	codeTrue        = 0x27
+
+	// escape follows a 0x00 byte within a bytes or string element, and
+	// represents a nil element within a nested tuple.
+	escape = 0xff
+)
+
+// Tuple is a list of elements.
+//
+// Supported element types are nil, []byte, string, bool, all signed and
+// unsigned integer types, float32, float64, and nested Tuple values.
+type Tuple []interface{}
+
+// Pack encodes the tuple to bytes.
+func (t Tuple) Pack() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.encode(&buf, false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MustPack encodes the tuple to bytes, panicking on unsupported elements.
+func (t Tuple) MustPack() []byte {
+	b, err := t.Pack()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// PrefixRange returns the range of keys of tuples starting with t, excluding
+// t itself. begin is inclusive and end is exclusive.
+func (t Tuple) PrefixRange() (begin, end []byte, err error) {
+	p, err := t.Pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	begin = append(append([]byte{}, p...), 0x00)
+	end = append(append([]byte{}, p...), 0xff)
+	return begin, end, nil
+}
+
+// PartialPrefix encodes the tuple leaving the last element unterminated.
+//
+// The last element must be a string or []byte. The result is a prefix of
+// every packed tuple which has the same leading elements and a last element
+// starting with the last element of t. Use with Kvtx.ScanPrefix to search by
+// a partial string.
+func (t Tuple) PartialPrefix() ([]byte, error) {
+	if len(t) == 0 {
+		return nil, errors.New("partial prefix of empty tuple")
+	}
+	last := t[len(t)-1]
+	switch last.(type) {
+	case string, []byte:
+	default:
+		return nil, errors.Errorf("partial prefix: last element must be string or []byte, got %T", last)
+	}
+	var buf bytes.Buffer
+	if err := t[:len(t)-1].encode(&buf, false); err != nil {
+		return nil, err
+	}
+	switch v := last.(type) {
+	case string:
+		buf.WriteByte(codeString)
+		writeEscaped(&buf, []byte(v))
+	case []byte:
+		buf.WriteByte(codeBytes)
+		writeEscaped(&buf, v)
+	}
+	return buf.Bytes(), nil
+}
+
+// HasPrefix checks if the packed key starts with the elements of prefix.
+func HasPrefix(key []byte, prefix Tuple) (bool, error) {
+	p, err := prefix.Pack()
+	if err != nil {
+		return false, err
+	}
+	return bytes.HasPrefix(key, p), nil
+}
+
+// encode writes the elements of the tuple to buf.
+func (t Tuple) encode(buf *bytes.Buffer, nested bool) error {
+	for i, el := range t {
+		if err := encodeElement(buf, el, nested); err != nil {
+			return errors.Wrapf(err, "element %d", i)
+		}
+	}
+	return nil
+}
+
+// encodeElement writes a single element to buf.
+func encodeElement(buf *bytes.Buffer, el interface{}, nested bool) error {
+	switch v := el.(type) {
+	case nil:
+		buf.WriteByte(codeNil)
+		if nested {
+			buf.WriteByte(escape)
+		}
+	case []byte:
+		buf.WriteByte(codeBytes)
+		writeEscaped(buf, v)
+		buf.WriteByte(0x00)
+	case string:
+		buf.WriteByte(codeString)
+		writeEscaped(buf, []byte(v))
+		buf.WriteByte(0x00)
+	case Tuple:
+		buf.WriteByte(codeNested)
+		if err := v.encode(buf, true); err != nil {
+			return err
+		}
+		buf.WriteByte(0x00)
+	case bool:
+		if v {
+			buf.WriteByte(codeTrue)
+		} else {
+			buf.WriteByte(codeFalse)
+		}
+	case int:
+		encodeInt(buf, int64(v))
+	case int8:
+		encodeInt(buf, int64(v))
+	case int16:
+		encodeInt(buf, int64(v))
+	case int32:
+		encodeInt(buf, int64(v))
+	case int64:
+		encodeInt(buf, v)
+	case uint:
+		encodeUint(buf, uint64(v))
+	case uint8:
+		encodeUint(buf, uint64(v))
+	case uint16:
+		encodeUint(buf, uint64(v))
+	case uint32:
+		encodeUint(buf, uint64(v))
+	case uint64:
+		encodeUint(buf, v)
+	case float32:
+		encodeFloat(buf, float64(v))
+	case float64:
+		encodeFloat(buf, v)
+	default:
+		return errors.Errorf("unsupported tuple element type: %T", el)
+	}
+	return nil
+}
+
+// writeEscaped writes b, escaping 0x00 bytes as 0x00 0xff.
+func writeEscaped(buf *bytes.Buffer, b []byte) {
+	for _, c := range b {
+		buf.WriteByte(c)
+		if c == 0x00 {
+			buf.WriteByte(escape)
+		}
+	}
+}
+
+// intByteLen returns the number of bytes needed to represent u.
+func intByteLen(u uint64) int {
+	n := 0
+	for u != 0 {
+		n++
+		u >>= 8
+	}
+	return n
+}
+
+// encodeUint writes a non-negative integer.
+//
+// The type code encodes the length, so larger numbers sort after smaller ones.
+func encodeUint(buf *bytes.Buffer, u uint64) {
+	n := intByteLen(u)
+	buf.WriteByte(byte(codeIntZero + n))
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], u)
+	buf.Write(b[8-n:])
+}
+
+// encodeInt writes a signed integer.
+//
+// Negative numbers are stored as the ones' complement of their magnitude with
+// a type code below zero, so more negative numbers sort first.
+func encodeInt(buf *bytes.Buffer, i int64) {
+	if i >= 0 {
+		encodeUint(buf, uint64(i))
+		return
+	}
+	mag := uint64(-(i + 1)) + 1
+	n := intByteLen(mag)
+	buf.WriteByte(byte(codeIntZero - n))
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], ^mag)
+	buf.Write(b[8-n:])
+}
+
+// encodeFloat writes a float64.
+//
+// Flips the sign bit of positive numbers and all bits of negative numbers,
+// so the big-endian bytes sort in numeric order.
+func encodeFloat(buf *bytes.Buffer, f float64) {
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	buf.WriteByte(codeFloat64)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], bits)
+	buf.Write(b[:])
+}
+
+// Unpack decodes a packed tuple.
+//
+// Integers are decoded as int64, or uint64 if larger than math.MaxInt64.
+// Floats are decoded as float64.
+func Unpack(b []byte) (Tuple, error) {
+	t, rest, err := decodeTuple(b, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("unexpected trailing bytes")
+	}
+	return t, nil
+}
+
+// decodeTuple decodes elements until the end of b or the end of a nested tuple.
+func decodeTuple(b []byte, nested bool) (Tuple, []byte, error) {
+	t := Tuple{}
+	for len(b) != 0 {
+		code := b[0]
+		if nested && code == codeNil {
+			if len(b) > 1 && b[1] == escape {
+				t = append(t, nil)
+				b = b[2:]
+				continue
+			}
+			// end of nested tuple
+			return t, b[1:], nil
+		}
+		el, rest, err := decodeElement(b)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "element %d", len(t))
+		}
+		t = append(t, el)
+		b = rest
+	}
+	if nested {
+		return nil, nil, errors.New("unterminated nested tuple")
+	}
+	return t, b, nil
+}
+
+// decodeElement decodes a single element, returning the remaining bytes.
+func decodeElement(b []byte) (interface{}, []byte, error) {
+	code := b[0]
+	b = b[1:]
+	switch {
+	case code == codeNil:
+		return nil, b, nil
+	case code == codeBytes:
+		v, rest, err := readEscaped(b)
+		return v, rest, err
+	case code == codeString:
+		v, rest, err := readEscaped(b)
+		return string(v), rest, err
+	case code == codeNested:
+		return decodeTuple(b, true)
+	case code >= codeNegIntStart && code <= codePosIntEnd:
+		return decodeInt(code, b)
+	case code == codeFloat64:
+		if len(b) < 8 {
+			return nil, nil, errors.New("truncated float")
+		}
+		bits := binary.BigEndian.Uint64(b[:8])
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return math.Float64frombits(bits), b[8:], nil
+	case code == codeFalse:
+		return false, b, nil
+	case code == codeTrue:
+		return true, b, nil
+	}
+	return nil, nil, errors.Errorf("unknown type code 0x%02x", code)
+}
+
+// readEscaped reads an escaped byte string terminated by an unescaped 0x00.
+func readEscaped(b []byte) ([]byte, []byte, error) {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0x00 {
+			out = append(out, b[i])
+			continue
+		}
+		if i+1 < len(b) && b[i+1] == escape {
+			out = append(out, 0x00)
+			i++
+			continue
+		}
+		return out, b[i+1:], nil
+	}
+	return nil, nil, errors.New("unterminated byte string")
+}
+
+// decodeInt decodes an integer with the given type code.
+func decodeInt(code byte, b []byte) (interface{}, []byte, error) {
+	if code == codeIntZero {
+		return int64(0), b, nil
+	}
+	neg := code < codeIntZero
+	n := int(code) - codeIntZero
+	if neg {
+		n = -n
+	}
+	if len(b) < n {
+		return nil, nil, errors.New("truncated integer")
+	}
+	var buf [8]byte
+	if neg {
+		for i := range buf {
+			buf[i] = 0xff
+		}
+	}
+	copy(buf[8-n:], b[:n])
+	u := binary.BigEndian.Uint64(buf[:])
+	if !neg {
+		if u > math.MaxInt64 {
+			return u, b[n:], nil
+		}
+		return int64(u), b[n:], nil
+	}
+	mag := ^u
+	if mag > 1<<63 {
+		return nil, nil, errors.New("negative integer overflows int64")
+	}
+	return -int64(mag-1) - 1, b[n:], nil
+}
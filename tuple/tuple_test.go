@@ -0,0 +1,118 @@
+package tuple
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPackUnpack(t *testing.T) {
+	tuples := []Tuple{
+		{},
+		{nil},
+		{"tenant", "type", int64(1700000000), []byte{0x00, 0xff, 0x01}},
+		{int64(math.MinInt64), int64(-1), int64(0), int64(1), int64(math.MaxInt64), uint64(math.MaxUint64)},
+		{-1.5, 0.0, math.Inf(1), math.Inf(-1)},
+		{true, false},
+		{Tuple{"nested", nil, Tuple{int64(1)}}, "after"},
+		{"nul\x00byte"},
+	}
+	for _, tup := range tuples {
+		packed, err := tup.Pack()
+		if err != nil {
+			t.Fatalf("pack %v: %v", tup, err)
+		}
+		out, err := Unpack(packed)
+		if err != nil {
+			t.Fatalf("unpack %v: %v", tup, err)
+		}
+		if !reflect.DeepEqual(out, tup) {
+			t.Fatalf("round trip mismatch: %#v != %#v", out, tup)
+		}
+	}
+
+	if _, err := (Tuple{struct{}{}}).Pack(); err == nil {
+		t.Fatal("expected error packing unsupported element")
+	}
+}
+
+func TestOrder(t *testing.T) {
+	// sorted in logical order
+	ordered := []Tuple{
+		{nil},
+		{[]byte{}},
+		{[]byte{0x00}},
+		{[]byte{0x00, 0x00}},
+		{[]byte{0x01}},
+		{""},
+		{"a"},
+		{"a", nil},
+		{"a", int64(-1)},
+		{"a", int64(0)},
+		{"a\x00"},
+		{"ab"},
+		{Tuple{nil}},
+		{Tuple{"a"}},
+		{int64(math.MinInt64)},
+		{int64(-256)},
+		{int64(-255)},
+		{int64(-1)},
+		{int64(0)},
+		{int64(1)},
+		{int64(255)},
+		{int64(256)},
+		{int64(math.MaxInt64)},
+		{uint64(math.MaxUint64)},
+		{math.Inf(-1)},
+		{-2.5},
+		{-0.5},
+		{0.0},
+		{0.5},
+		{math.Inf(1)},
+		{false},
+		{true},
+	}
+	packed := make([][]byte, len(ordered))
+	for i, tup := range ordered {
+		packed[i] = tup.MustPack()
+	}
+	shuffled := make([][]byte, len(packed))
+	copy(shuffled, packed)
+	sort.Slice(shuffled, func(i, j int) bool {
+		return bytes.Compare(shuffled[i], shuffled[j]) < 0
+	})
+	for i := range packed {
+		if !bytes.Equal(packed[i], shuffled[i]) {
+			t.Fatalf("byte order does not match logical order at %d: %v", i, ordered[i])
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	key := Tuple{"tenant", "users", "alice", int64(3)}.MustPack()
+	ok, err := HasPrefix(key, Tuple{"tenant", "users"})
+	if err != nil || !ok {
+		t.Fatalf("expected key to have prefix: %v", err)
+	}
+	// element boundaries are respected
+	if bytes.HasPrefix(key, Tuple{"tenant", "user"}.MustPack()) {
+		t.Fatal("packed prefix matched a partial element")
+	}
+	partial, err := Tuple{"tenant", "users", "al"}.PartialPrefix()
+	if err != nil {
+		t.Fatalf("partial prefix: %v", err)
+	}
+	if !bytes.HasPrefix(key, partial) {
+		t.Fatal("expected partial prefix to match")
+	}
+
+	begin, end, err := Tuple{"tenant"}.PrefixRange()
+	if err != nil {
+		t.Fatalf("prefix range: %v", err)
+	}
+	if bytes.Compare(key, begin) < 0 || bytes.Compare(key, end) >= 0 {
+		t.Fatal("expected key to be within prefix range")
+	}
+}
@@ -0,0 +1,142 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"encoding/json"
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// Codec converts Go values of type T to and from js values.
+type Codec[T any] interface {
+	// Encode converts the value to a js value.
+	Encode(v T) (js.Value, error)
+	// Decode converts a js value to a value.
+	Decode(val js.Value) (T, error)
+}
+
+// BytesCodec stores byte slices as Uint8Array.
+type BytesCodec struct{}
+
+// Encode converts the value to a js value.
+func (BytesCodec) Encode(v []byte) (js.Value, error) {
+	return CopyByteSliceToJs(v), nil
+}
+
+// Decode converts a js value to a value.
+func (BytesCodec) Decode(val js.Value) ([]byte, error) {
+	return CopyBinaryFromJs(val)
+}
+
+// StringCodec stores strings as js strings.
+type StringCodec struct{}
+
+// Encode converts the value to a js value.
+func (StringCodec) Encode(v string) (js.Value, error) {
+	return js.ValueOf(v), nil
+}
+
+// Decode converts a js value to a value.
+func (StringCodec) Decode(val js.Value) (string, error) {
+	if val.Type() != js.TypeString {
+		return "", errors.Errorf("expected string but got %s", val.Type().String())
+	}
+	return val.String(), nil
+}
+
+// JSONCodec stores values as JSON strings.
+type JSONCodec[T any] struct{}
+
+// Encode converts the value to a js value.
+func (JSONCodec[T]) Encode(v T) (js.Value, error) {
+	dat, err := json.Marshal(v)
+	if err != nil {
+		return js.Undefined(), err
+	}
+	return js.ValueOf(string(dat)), nil
+}
+
+// Decode converts a js value to a value.
+func (JSONCodec[T]) Decode(val js.Value) (T, error) {
+	var out T
+	if val.Type() != js.TypeString {
+		return out, errors.Errorf("expected json string but got %s", val.Type().String())
+	}
+	err := json.Unmarshal([]byte(val.String()), &out)
+	return out, err
+}
+
+// VTMessage is a message with protobuf-style MarshalVT and UnmarshalVT methods.
+type VTMessage interface {
+	// MarshalVT marshals the message to binary.
+	MarshalVT() ([]byte, error)
+	// UnmarshalVT unmarshals the message from binary.
+	UnmarshalVT(data []byte) error
+}
+
+// vtCodec stores VTMessage values as Uint8Array.
+type vtCodec[T any, PT interface {
+	*T
+	VTMessage
+}] struct{}
+
+// NewVTCodec constructs a codec which stores *T as binary with MarshalVT.
+func NewVTCodec[T any, PT interface {
+	*T
+	VTMessage
+}]() Codec[PT] {
+	return vtCodec[T, PT]{}
+}
+
+// Encode converts the value to a js value.
+//
+// Returns an error if v is nil.
+func (vtCodec[T, PT]) Encode(v PT) (js.Value, error) {
+	if v == nil {
+		return js.Undefined(), errors.New("cannot encode a nil message")
+	}
+	dat, err := v.MarshalVT()
+	if err != nil {
+		return js.Undefined(), err
+	}
+	return CopyByteSliceToJs(dat), nil
+}
+
+// Decode converts a js value to a value.
+func (vtCodec[T, PT]) Decode(val js.Value) (PT, error) {
+	dat, err := CopyBinaryFromJs(val)
+	if err != nil {
+		return nil, err
+	}
+	out := PT(new(T))
+	if err := out.UnmarshalVT(dat); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ObjectCodec stores values as plain js objects with EncodeValue.
+//
+// Use this codec to make struct fields usable with key paths and indexes.
+type ObjectCodec[T any] struct{}
+
+// Encode converts the value to a js value.
+func (ObjectCodec[T]) Encode(v T) (js.Value, error) {
+	return EncodeValue(v)
+}
+
+// Decode converts a js value to a value.
+func (ObjectCodec[T]) Decode(val js.Value) (T, error) {
+	var out T
+	err := DecodeValue(val, &out)
+	return out, err
+}
+
+// _ is a type assertion
+var (
+	_ Codec[[]byte] = BytesCodec{}
+	_ Codec[string] = StringCodec{}
+)
@@ -0,0 +1,210 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"syscall/js"
+)
+
+// typedStoreScanPageSize is the number of records read per page by Scan.
+const typedStoreScanPageSize = 100
+
+// TypedStore wraps a DurableObjectStore with typed keys and values.
+type TypedStore[K, V any] struct {
+	store *DurableObjectStore
+	keys  Codec[K]
+	vals  Codec[V]
+	// inlineKeys is set if the store has a key path.
+	// nil until checked on the first write.
+	inlineKeys *bool
+}
+
+// NewTypedStore constructs a new TypedStore with key and value codecs.
+func NewTypedStore[K, V any](store *DurableObjectStore, keyCodec Codec[K], valueCodec Codec[V]) *TypedStore[K, V] {
+	return &TypedStore[K, V]{store: store, keys: keyCodec, vals: valueCodec}
+}
+
+// GetObjectStore returns the underlying durable object store.
+func (s *TypedStore[K, V]) GetObjectStore() *DurableObjectStore {
+	return s.store
+}
+
+// Get gets a value by key.
+func (s *TypedStore[K, V]) Get(key K) (V, bool, error) {
+	var empty V
+	keyVal, err := s.keys.Encode(key)
+	if err != nil {
+		return empty, false, err
+	}
+	val, err := s.store.Get(keyVal)
+	if err != nil || val.IsUndefined() {
+		return empty, false, err
+	}
+	out, err := s.vals.Decode(val)
+	if err != nil {
+		return empty, false, err
+	}
+	return out, true, nil
+}
+
+// Put puts a value with a key.
+//
+// If the store has a key path, the key is ignored and read from the value.
+func (s *TypedStore[K, V]) Put(key K, val V) error {
+	valVal, err := s.vals.Encode(val)
+	if err != nil {
+		return err
+	}
+	inline, err := s.hasInlineKeys()
+	if err != nil {
+		return err
+	}
+	if inline {
+		_, err = s.store.Put(valVal, nil)
+		return err
+	}
+	keyVal, err := s.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.store.Put(valVal, keyVal)
+	return err
+}
+
+// hasInlineKeys checks if the store has a key path.
+func (s *TypedStore[K, V]) hasInlineKeys() (bool, error) {
+	if s.inlineKeys == nil {
+		keyPath, err := s.store.KeyPath()
+		if err != nil {
+			return false, err
+		}
+		inline := keyPath != nil
+		s.inlineKeys = &inline
+	}
+	return *s.inlineKeys, nil
+}
+
+// Delete deletes a value by key.
+func (s *TypedStore[K, V]) Delete(key K) error {
+	keyVal, err := s.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	return s.store.Delete(keyVal)
+}
+
+// Bound builds an IDBKeyRange with typed lower and upper keys.
+func (s *TypedStore[K, V]) Bound(lower, upper K, lowerOpen, upperOpen bool) (js.Value, error) {
+	return typedBound(s.keys, lower, upper, lowerOpen, upperOpen)
+}
+
+// Scan iterates over the records in an optional IDBKeyRange in key order.
+//
+// Reads records in pages, so the transaction can be restarted during the scan.
+// Use Bound() to build a key range.
+func (s *TypedStore[K, V]) Scan(krv js.Value, cb func(key K, val V) error) error {
+	return s.store.ScanPages(krv, typedStoreScanPageSize, func(keys, vals []js.Value) error {
+		return s.decodeEntries(keys, vals, cb)
+	})
+}
+
+// decodeEntries decodes lists of keys and values and calls cb for each.
+func (s *TypedStore[K, V]) decodeEntries(keys, vals []js.Value, cb func(key K, val V) error) error {
+	for i := range keys {
+		key, err := s.keys.Decode(keys[i])
+		if err != nil {
+			return err
+		}
+		val, err := s.vals.Decode(vals[i])
+		if err != nil {
+			return err
+		}
+		if err := cb(key, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TypedIndex wraps an index of a TypedStore with typed index keys.
+type TypedIndex[IK, K, V any] struct {
+	store     *TypedStore[K, V]
+	index     *DurableIndex
+	indexKeys Codec[IK]
+}
+
+// NewTypedIndex constructs a TypedIndex for an index of the store.
+func NewTypedIndex[IK, K, V any](store *TypedStore[K, V], name string, indexKeyCodec Codec[IK]) *TypedIndex[IK, K, V] {
+	return &TypedIndex[IK, K, V]{
+		store:     store,
+		index:     store.store.Index(name),
+		indexKeys: indexKeyCodec,
+	}
+}
+
+// Get gets the first value with the index key.
+func (i *TypedIndex[IK, K, V]) Get(indexKey IK) (V, bool, error) {
+	var empty V
+	query, err := i.indexKeys.Encode(indexKey)
+	if err != nil {
+		return empty, false, err
+	}
+	val, err := i.index.Get(query)
+	if err != nil || val.IsUndefined() {
+		return empty, false, err
+	}
+	out, err := i.store.vals.Decode(val)
+	if err != nil {
+		return empty, false, err
+	}
+	return out, true, nil
+}
+
+// Bound builds an IDBKeyRange with typed lower and upper index keys.
+func (i *TypedIndex[IK, K, V]) Bound(lower, upper IK, lowerOpen, upperOpen bool) (js.Value, error) {
+	return typedBound(i.indexKeys, lower, upper, lowerOpen, upperOpen)
+}
+
+// GetAll calls cb for each record with the index key, with an optional count.
+// A count of zero returns all matching records.
+func (i *TypedIndex[IK, K, V]) GetAll(indexKey IK, count int, cb func(key K, val V) error) error {
+	query, err := i.indexKeys.Encode(indexKey)
+	if err != nil {
+		return err
+	}
+	return i.GetAllRange(query, count, cb)
+}
+
+// GetAllRange calls cb for each record in an optional IDBKeyRange of index
+// keys, with an optional count. A count of zero returns all matching records.
+// Use Bound() to build a key range.
+func (i *TypedIndex[IK, K, V]) GetAllRange(krv js.Value, count int, cb func(key K, val V) error) error {
+	keys, vals, err := i.index.GetAllEntries(krv, count)
+	if err != nil {
+		return err
+	}
+	return i.store.decodeEntries(keys, vals, cb)
+}
+
+// Count counts the records with the index key.
+func (i *TypedIndex[IK, K, V]) Count(indexKey IK) (int, error) {
+	query, err := i.indexKeys.Encode(indexKey)
+	if err != nil {
+		return 0, err
+	}
+	return i.index.Count(query)
+}
+
+// typedBound builds an IDBKeyRange from typed keys.
+func typedBound[T any](codec Codec[T], lower, upper T, lowerOpen, upperOpen bool) (js.Value, error) {
+	lowerVal, err := codec.Encode(lower)
+	if err != nil {
+		return js.Undefined(), err
+	}
+	upperVal, err := codec.Encode(upper)
+	if err != nil {
+		return js.Undefined(), err
+	}
+	return Bound(lowerVal, upperVal, lowerOpen, upperOpen), nil
+}
@@ -0,0 +1,539 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxSafeInteger is the largest integer a js number can represent exactly.
+const maxSafeInteger = 1<<53 - 1
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	jsValueType = reflect.TypeOf(js.Value{})
+	blobType    = reflect.TypeOf(Blob{})
+)
+
+// EncodeValue converts a Go value to a plain js object usable with IndexedDB.
+//
+// Structs are converted to objects with a property for each exported field.
+// The property name can be set with the `idb:"name"` tag, a field with the tag
+// `idb:"-"` is skipped, and `idb:"name,omitempty"` skips zero values. Embedded
+// structs without a tag are flattened into the parent object.
+//
+// Maps with string keys are converted to objects, other maps to Map objects.
+// []byte is converted to Uint8Array, time.Time to Date (millisecond precision),
+// Blob to the underlying js Blob, and other slices and arrays to Array.
+// Integers outside of the range a js number can represent exactly return an
+// error.
+func EncodeValue(v interface{}) (js.Value, error) {
+	if v == nil {
+		return js.Null(), nil
+	}
+	return encodeValue(reflect.ValueOf(v))
+}
+
+// DecodeValue decodes a js value into the Go value pointed to by out.
+//
+// This is the inverse of EncodeValue. When decoding into an interface{},
+// numbers are decoded as float64, objects as map[string]interface{}, Map
+// objects as map[interface{}]interface{}, arrays as []interface{}, binary
+// values as []byte, Blob objects as *Blob, and Date objects as time.Time.
+func DecodeValue(val js.Value, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("decode value: out must be a non-nil pointer")
+	}
+	return decodeValue(val, rv.Elem())
+}
+
+// ConvertValueToJs converts val to a value which can be passed to javascript.
+//
+// []byte is copied to a Uint8Array, js.Value and primitive values are
+// returned as-is, and any other values are converted with EncodeValue.
+func ConvertValueToJs(val interface{}) (interface{}, error) {
+	switch vb := val.(type) {
+	case []byte:
+		return CopyByteSliceToJs(vb), nil
+	case nil, js.Value, bool, string,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, uintptr,
+		float32, float64:
+		return val, nil
+	}
+	return EncodeValue(val)
+}
+
+// encodeValue encodes a reflect value.
+func encodeValue(rv reflect.Value) (js.Value, error) {
+	global := js.Global()
+	switch rv.Type() {
+	case jsValueType:
+		return rv.Interface().(js.Value), nil
+	case timeType:
+		t := rv.Interface().(time.Time)
+		return global.Get("Date").New(float64(t.UnixMilli())), nil
+	case blobType:
+		b := rv.Interface().(Blob)
+		if b.val.IsUndefined() {
+			return js.Null(), nil
+		}
+		return b.val, nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return js.Null(), nil
+		}
+		return encodeValue(rv.Elem())
+	case reflect.Bool:
+		return js.ValueOf(rv.Bool()), nil
+	case reflect.String:
+		return js.ValueOf(rv.String()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		if i > maxSafeInteger || i < -maxSafeInteger {
+			return js.Undefined(), errors.Errorf("integer %d cannot be represented exactly as a js number", i)
+		}
+		return js.ValueOf(i), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > maxSafeInteger {
+			return js.Undefined(), errors.Errorf("integer %d cannot be represented exactly as a js number", u)
+		}
+		return js.ValueOf(u), nil
+	case reflect.Float32, reflect.Float64:
+		return js.ValueOf(rv.Float()), nil
+	case reflect.Slice:
+		if rv.IsNil() {
+			return js.Null(), nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return CopyByteSliceToJs(rv.Bytes()), nil
+		}
+		fallthrough
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return CopyByteSliceToJs(b), nil
+		}
+		arr := global.Get("Array").New(rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			ev, err := encodeValue(rv.Index(i))
+			if err != nil {
+				return js.Undefined(), errors.Wrapf(err, "index %d", i)
+			}
+			arr.SetIndex(i, ev)
+		}
+		return arr, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return js.Null(), nil
+		}
+		if rv.Type().Key().Kind() == reflect.String {
+			obj := global.Get("Object").New()
+			iter := rv.MapRange()
+			for iter.Next() {
+				ev, err := encodeValue(iter.Value())
+				if err != nil {
+					return js.Undefined(), errors.Wrap(err, iter.Key().String())
+				}
+				obj.Set(iter.Key().String(), ev)
+			}
+			return obj, nil
+		}
+		m := global.Get("Map").New()
+		iter := rv.MapRange()
+		for iter.Next() {
+			kv, err := encodeValue(iter.Key())
+			if err != nil {
+				return js.Undefined(), errors.Wrap(err, "map key")
+			}
+			ev, err := encodeValue(iter.Value())
+			if err != nil {
+				return js.Undefined(), errors.Wrap(err, "map value")
+			}
+			m.Call("set", kv, ev)
+		}
+		return m, nil
+	case reflect.Struct:
+		obj := global.Get("Object").New()
+		for _, f := range cachedStructFields(rv.Type()) {
+			fv, ok := fieldByIndex(rv, f.index)
+			if !ok || (f.omitEmpty && fv.IsZero()) {
+				continue
+			}
+			ev, err := encodeValue(fv)
+			if err != nil {
+				return js.Undefined(), errors.Wrap(err, f.name)
+			}
+			obj.Set(f.name, ev)
+		}
+		return obj, nil
+	}
+	return js.Undefined(), errors.Errorf("cannot encode value of type %s", rv.Type().String())
+}
+
+// decodeValue decodes a js value into a settable reflect value.
+func decodeValue(val js.Value, rv reflect.Value) error {
+	global := js.Global()
+	switch rv.Type() {
+	case jsValueType:
+		rv.Set(reflect.ValueOf(val))
+		return nil
+	case timeType:
+		if val.IsNull() || val.IsUndefined() {
+			rv.Set(reflect.Zero(timeType))
+			return nil
+		}
+		if val.Type() != js.TypeObject || !val.InstanceOf(global.Get("Date")) {
+			return errors.Errorf("cannot decode js %s into time.Time", val.Type().String())
+		}
+		ms := val.Call("getTime").Float()
+		rv.Set(reflect.ValueOf(time.UnixMilli(int64(ms)).UTC()))
+		return nil
+	case blobType:
+		if val.IsNull() || val.IsUndefined() {
+			rv.Set(reflect.Zero(blobType))
+			return nil
+		}
+		b, err := NewBlob(val)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(*b))
+		return nil
+	}
+
+	if val.IsNull() || val.IsUndefined() {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(rv.Type().Elem())
+		if err := decodeValue(val, elem.Elem()); err != nil {
+			return err
+		}
+		rv.Set(elem)
+		return nil
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return errors.Errorf("cannot decode into non-empty interface %s", rv.Type().String())
+		}
+		gv, err := decodeGeneric(val)
+		if err != nil {
+			return err
+		}
+		if gv == nil {
+			rv.Set(reflect.Zero(rv.Type()))
+		} else {
+			rv.Set(reflect.ValueOf(gv))
+		}
+		return nil
+	case reflect.Bool:
+		if val.Type() != js.TypeBoolean {
+			return errors.Errorf("cannot decode js %s into bool", val.Type().String())
+		}
+		rv.SetBool(val.Bool())
+		return nil
+	case reflect.String:
+		if val.Type() != js.TypeString {
+			return errors.Errorf("cannot decode js %s into string", val.Type().String())
+		}
+		rv.SetString(val.String())
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f, err := decodeInteger(val)
+		if err != nil {
+			return err
+		}
+		if rv.OverflowInt(int64(f)) {
+			return errors.Errorf("number %v overflows %s", f, rv.Type().String())
+		}
+		rv.SetInt(int64(f))
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		f, err := decodeInteger(val)
+		if err != nil {
+			return err
+		}
+		if f < 0 || rv.OverflowUint(uint64(f)) {
+			return errors.Errorf("number %v overflows %s", f, rv.Type().String())
+		}
+		rv.SetUint(uint64(f))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if val.Type() != js.TypeNumber {
+			return errors.Errorf("cannot decode js %s into %s", val.Type().String(), rv.Type().String())
+		}
+		rv.SetFloat(val.Float())
+		return nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := CopyBinaryFromJs(val)
+			if err != nil {
+				return err
+			}
+			rv.SetBytes(b)
+			return nil
+		}
+		if !global.Get("Array").Call("isArray", val).Bool() {
+			return errors.Errorf("cannot decode non-array js value into %s", rv.Type().String())
+		}
+		n := val.Length()
+		sl := reflect.MakeSlice(rv.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := decodeValue(val.Index(i), sl.Index(i)); err != nil {
+				return errors.Wrapf(err, "index %d", i)
+			}
+		}
+		rv.Set(sl)
+		return nil
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := CopyBinaryFromJs(val)
+			if err != nil {
+				return err
+			}
+			if len(b) != rv.Len() {
+				return errors.Errorf("cannot decode %d bytes into %s", len(b), rv.Type().String())
+			}
+			reflect.Copy(rv, reflect.ValueOf(b))
+			return nil
+		}
+		if !global.Get("Array").Call("isArray", val).Bool() || val.Length() != rv.Len() {
+			return errors.Errorf("cannot decode js value into %s", rv.Type().String())
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := decodeValue(val.Index(i), rv.Index(i)); err != nil {
+				return errors.Wrapf(err, "index %d", i)
+			}
+		}
+		return nil
+	case reflect.Map:
+		if val.Type() != js.TypeObject {
+			return errors.Errorf("cannot decode js %s into %s", val.Type().String(), rv.Type().String())
+		}
+		m := reflect.MakeMap(rv.Type())
+		keyType, elemType := rv.Type().Key(), rv.Type().Elem()
+		var entries js.Value
+		if val.InstanceOf(global.Get("Map")) {
+			entries = global.Get("Array").Call("from", val.Call("entries"))
+		} else {
+			entries = global.Get("Object").Call("entries", val)
+		}
+		for i := 0; i < entries.Length(); i++ {
+			ent := entries.Index(i)
+			k, v := reflect.New(keyType).Elem(), reflect.New(elemType).Elem()
+			if err := decodeValue(ent.Index(0), k); err != nil {
+				return errors.Wrap(err, "map key")
+			}
+			if err := decodeValue(ent.Index(1), v); err != nil {
+				return errors.Wrap(err, "map value")
+			}
+			m.SetMapIndex(k, v)
+		}
+		rv.Set(m)
+		return nil
+	case reflect.Struct:
+		if val.Type() != js.TypeObject {
+			return errors.Errorf("cannot decode js %s into %s", val.Type().String(), rv.Type().String())
+		}
+		for _, f := range cachedStructFields(rv.Type()) {
+			fval := val.Get(f.name)
+			fv, err := fieldByIndexAlloc(rv, f.index)
+			if err != nil {
+				if fval.IsUndefined() {
+					// nothing to decode into the nil embedded pointer.
+					continue
+				}
+				return errors.Wrap(err, f.name)
+			}
+			if err := decodeValue(fval, fv); err != nil {
+				return errors.Wrap(err, f.name)
+			}
+		}
+		return nil
+	}
+	return errors.Errorf("cannot decode into value of type %s", rv.Type().String())
+}
+
+// decodeInteger decodes an integral js number.
+func decodeInteger(val js.Value) (float64, error) {
+	if val.Type() != js.TypeNumber {
+		return 0, errors.Errorf("cannot decode js %s into integer", val.Type().String())
+	}
+	f := val.Float()
+	if f != math.Trunc(f) || math.IsInf(f, 0) {
+		return 0, errors.Errorf("cannot decode non-integral number %v into integer", f)
+	}
+	return f, nil
+}
+
+// decodeGeneric decodes a js value into a generic Go value.
+func decodeGeneric(val js.Value) (interface{}, error) {
+	var out interface{}
+	global := js.Global()
+	switch val.Type() {
+	case js.TypeNull, js.TypeUndefined:
+		return nil, nil
+	case js.TypeBoolean:
+		return val.Bool(), nil
+	case js.TypeNumber:
+		return val.Float(), nil
+	case js.TypeString:
+		return val.String(), nil
+	case js.TypeObject:
+	default:
+		return nil, errors.Errorf("cannot decode js %s", val.Type().String())
+	}
+
+	var err error
+	switch {
+	case val.InstanceOf(global.Get("Date")):
+		var t time.Time
+		err = decodeValue(val, reflect.ValueOf(&t).Elem())
+		out = t
+	case val.InstanceOf(global.Get("ArrayBuffer")) || global.Get("ArrayBuffer").Call("isView", val).Bool():
+		out, err = CopyBinaryFromJs(val)
+	case val.InstanceOf(global.Get("Blob")):
+		out = &Blob{val: val}
+	case global.Get("Array").Call("isArray", val).Bool():
+		var sl []interface{}
+		err = decodeValue(val, reflect.ValueOf(&sl).Elem())
+		out = sl
+	case val.InstanceOf(global.Get("Map")):
+		var m map[interface{}]interface{}
+		err = decodeValue(val, reflect.ValueOf(&m).Elem())
+		out = m
+	default:
+		var m map[string]interface{}
+		err = decodeValue(val, reflect.ValueOf(&m).Elem())
+		out = m
+	}
+	return out, err
+}
+
+// structField is an encoded field of a struct.
+type structField struct {
+	// name is the js property name.
+	name string
+	// index is the field index sequence for reflect.
+	index []int
+	// omitEmpty skips the field if it is the zero value.
+	omitEmpty bool
+}
+
+// structFieldsCache caches the fields of struct types.
+var structFieldsCache sync.Map // map[reflect.Type][]*structField
+
+// cachedStructFields returns the encoded fields of a struct type.
+func cachedStructFields(t reflect.Type) []*structField {
+	if f, ok := structFieldsCache.Load(t); ok {
+		return f.([]*structField)
+	}
+	fields := buildStructFields(t, nil, map[reflect.Type]struct{}{t: {}})
+	f, _ := structFieldsCache.LoadOrStore(t, fields)
+	return f.([]*structField)
+}
+
+// buildStructFields builds the list of encoded fields of a struct type.
+//
+// Fields declared on the parent take precedence over embedded fields.
+// visited contains the struct types being built, so that a type which embeds
+// itself is not expanded again.
+func buildStructFields(t reflect.Type, index []int, visited map[reflect.Type]struct{}) []*structField {
+	var fields, embedded []*structField
+	seen := make(map[string]struct{})
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("idb")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct && ft != timeType {
+			if _, ok := visited[ft]; ok {
+				continue
+			}
+			visited[ft] = struct{}{}
+			embedded = append(embedded, buildStructFields(ft, fieldIndex, visited)...)
+			delete(visited, ft)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		seen[name] = struct{}{}
+		fields = append(fields, &structField{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+	for _, f := range embedded {
+		if _, ok := seen[f.name]; ok {
+			continue
+		}
+		seen[f.name] = struct{}{}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// fieldByIndex returns the field at the index, following embedded pointers.
+// Returns false if an embedded pointer is nil.
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i != 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+// fieldByIndexAlloc returns the field at the index, allocating embedded pointers.
+// Returns an error if a nil embedded pointer to an unexported struct cannot be set.
+func fieldByIndexAlloc(rv reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i != 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				if !rv.CanSet() {
+					return reflect.Value{}, errors.Errorf("cannot set embedded pointer to unexported struct %s", rv.Type().Elem().String())
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, nil
+}
@@ -0,0 +1,617 @@
+//go:build js
+// +build js
+
+package indexeddb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// WALObjectStoreID is the id of the object store used for the persistent write-ahead-log.
+//
+// Create it during an upgrade with DatabaseUpdate.CreateWALObjectStore.
+const WALObjectStoreID = "indexeddb-wal"
+
+// walLocalStoragePrefix is the prefix for localStorage write-ahead-log keys.
+const walLocalStoragePrefix = "indexeddb-wal/"
+
+// walEntry is an operation stored in the persistent write-ahead-log.
+type walEntry struct {
+	// seq is the sequence number of the entry.
+	seq int
+	// store is the object store id.
+	store string
+	// op is the operation.
+	op *durableOp
+	// changelog is the changelog settings, if the op is recorded.
+	changelog *changelog
+	// owner is the id of the Database handle which wrote the entry, if any.
+	owner string
+}
+
+// writeAheadLog persists buffered DurableTransaction operations.
+type writeAheadLog interface {
+	// append persists an entry, returning the assigned sequence number.
+	//
+	// clog is the changelog settings of the transaction, if any.
+	append(store string, op *durableOp, clog *changelog) (int, error)
+	// objectStoreID returns the object store holding the log, if any.
+	//
+	// If set, entries are removed in the same transaction that applies them.
+	objectStoreID() string
+	// remove removes entries by sequence number.
+	remove(seqs []int) error
+	// load loads all entries in sequence order.
+	load() ([]*walEntry, error)
+}
+
+// CreateWALObjectStore creates the object store for the persistent write-ahead-log.
+// Does nothing if it already exists.
+func (d *DatabaseUpdate) CreateWALObjectStore() (err error) {
+	if d.ContainsObjectStore(WALObjectStoreID) {
+		return nil
+	}
+	defer func() {
+		if rerr := recover(); rerr != nil {
+			var ok bool
+			err, ok = recoveredError(rerr)
+			if !ok {
+				err = errors.New("create wal object store paniced")
+			}
+		}
+	}()
+
+	opts := js.Global().Get("Object").New()
+	opts.Set("autoIncrement", true)
+	d.Database.val.Call("createObjectStore", WALObjectStoreID, opts)
+	return nil
+}
+
+// EnableWAL enables the persistent write-ahead-log for durable transactions.
+//
+// Operations buffered by a DurableTransaction while its transaction is
+// inactive are persisted before the write call returns. Entries left behind
+// by a closed handle, for example when the page closed before Commit, are
+// replayed by the next call to EnableWAL.
+//
+// Uses the WALObjectStoreID object store if it exists, otherwise falls back to
+// localStorage. The localStorage fallback only removes entries after the
+// transaction completes, so an entry may be replayed twice after a crash.
+//
+// Each handle holds a Web Lock while the WAL is enabled, so that entries of
+// open handles in other tabs are not replayed. If the Web Locks API is
+// unavailable, replay applies entries left behind by any tab: only enable the
+// WAL if a single tab writes to the database at a time. Entries which fail to
+// apply, for example to an object store deleted by an upgrade, are dropped
+// and logged.
+func (d *Database) EnableWAL() error {
+	var wal writeAheadLog
+	ls := js.Global().Get("localStorage")
+	if !d.ContainsObjectStore(WALObjectStoreID) && !ls.Truthy() {
+		return errors.Errorf("object store %s not found and localStorage is unavailable", WALObjectStoreID)
+	}
+	d.DisableWAL()
+
+	var ownerBuf [8]byte
+	if _, err := rand.Read(ownerBuf[:]); err != nil {
+		return err
+	}
+	owner := hex.EncodeToString(ownerBuf[:])
+	if locks := webLocks(); locks.Truthy() {
+		release, err := holdWebLock(locks, d.walOwnerLockName(owner))
+		if err != nil {
+			return err
+		}
+		d.walRelease = release
+	}
+
+	if d.ContainsObjectStore(WALObjectStoreID) {
+		wal = &idbWAL{db: d, owner: owner}
+	} else {
+		wal = &localStorageWAL{ls: ls, prefix: walLocalStoragePrefix + d.GetName() + "/", owner: owner}
+	}
+	d.replayWAL()
+	d.wal = wal
+	return nil
+}
+
+// DisableWAL disables the persistent write-ahead-log.
+//
+// Entries written by this handle may be replayed by other handles afterwards.
+func (d *Database) DisableWAL() {
+	d.wal = nil
+	if d.walRelease != nil {
+		d.walRelease()
+		d.walRelease = nil
+	}
+}
+
+// clearLocalStorageWAL removes the localStorage write-ahead-log of a database, if any.
+func clearLocalStorageWAL(name string) (e error) {
+	ls := js.Global().Get("localStorage")
+	if !ls.Truthy() {
+		return nil
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+
+	prefix := walLocalStoragePrefix + name + "/"
+	var keys []string
+	n := ls.Get("length").Int()
+	for i := 0; i < n; i++ {
+		if lsKey := ls.Call("key", i).String(); strings.HasPrefix(lsKey, prefix) {
+			keys = append(keys, lsKey)
+		}
+	}
+	for _, lsKey := range keys {
+		ls.Call("removeItem", lsKey)
+	}
+	return nil
+}
+
+// walOwnerLockName returns the name of the Web Lock held by a WAL owner.
+func (d *Database) walOwnerLockName(owner string) string {
+	return lockNamePrefix + d.GetName() + "/wal/" + owner
+}
+
+// replayWAL applies and removes any entries left in the write-ahead-logs.
+//
+// Replays are serialized across tabs with a Web Lock, if available. Errors
+// are logged and do not prevent enabling the WAL: the entries are kept and
+// replayed by the next EnableWAL.
+func (d *Database) replayWAL() {
+	replay := func() error {
+		if d.ContainsObjectStore(WALObjectStoreID) {
+			if err := replayWriteAheadLog(d, &idbWAL{db: d}); err != nil {
+				log.Printf("indexeddb: replay write-ahead-log: %v", err)
+			}
+		}
+		if ls := js.Global().Get("localStorage"); ls.Truthy() {
+			wal := &localStorageWAL{ls: ls, prefix: walLocalStoragePrefix + d.GetName() + "/"}
+			if err := replayWriteAheadLog(d, wal); err != nil {
+				log.Printf("indexeddb: replay write-ahead-log: %v", err)
+			}
+		}
+		return nil
+	}
+	if locks := webLocks(); locks.Truthy() {
+		lockName := lockNamePrefix + d.GetName() + "/wal-replay"
+		if err := withWebLock(context.Background(), locks, lockName, LockExclusive, replay); err != nil {
+			log.Printf("indexeddb: replay write-ahead-log: %v", err)
+		}
+		return
+	}
+	_ = replay()
+}
+
+// liveWALOwners returns the owners of write-ahead-log entries with open handles.
+//
+// Returns nil if the Web Locks API is unavailable.
+func (d *Database) liveWALOwners() (map[string]struct{}, error) {
+	locks := webLocks()
+	if !locks.Truthy() {
+		return nil, nil
+	}
+	snapshot, err := awaitPromise(locks.Call("query"))
+	if err != nil {
+		return nil, err
+	}
+	prefix := d.walOwnerLockName("")
+	owners := make(map[string]struct{})
+	held := snapshot.Get("held")
+	for i := 0; i < held.Length(); i++ {
+		name := held.Index(i).Get("name").String()
+		if strings.HasPrefix(name, prefix) {
+			owners[name[len(prefix):]] = struct{}{}
+		}
+	}
+	return owners, nil
+}
+
+// replayWriteAheadLog applies all entries in a wal in a single transaction.
+//
+// Entries which fail to apply are dropped and logged, so that they cannot
+// prevent the WAL from being enabled. Add operations which fail with a
+// constraint error are dropped silently, as they were most likely already
+// applied. Entries owned by open handles are skipped.
+func replayWriteAheadLog(d *Database, wal writeAheadLog) (e error) {
+	loaded, err := wal.load()
+	if err != nil || len(loaded) == 0 {
+		return err
+	}
+	live, err := d.liveWALOwners()
+	if err != nil {
+		return err
+	}
+	var entries []*walEntry
+	for _, ent := range loaded {
+		if _, ok := live[ent.owner]; !ok {
+			entries = append(entries, ent)
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// entries for object stores that no longer exist are dropped.
+	var scope []string
+	var orphans []int
+	seen := make(map[string]struct{})
+	for _, ent := range entries {
+		if _, ok := seen[ent.store]; ok {
+			continue
+		}
+		if !d.ContainsObjectStore(ent.store) {
+			logDroppedWALEntry(ent, errors.Errorf("object store %s not found", ent.store))
+			orphans = append(orphans, ent.seq)
+			continue
+		}
+		seen[ent.store] = struct{}{}
+		scope = append(scope, ent.store)
+	}
+	walStoreID := wal.objectStoreID()
+	if walStoreID != "" {
+		scope = append(scope, walStoreID)
+	}
+	// writes are recorded in the changelog if their transaction recorded them.
+	var logChanges bool
+	if d.ContainsObjectStore(ChangelogObjectStoreID) {
+		for _, ent := range entries {
+			if ent.changelog != nil {
+				logChanges = true
+				scope = append(scope, ChangelogObjectStoreID)
+				break
+			}
+		}
+	}
+
+	txn, err := d.Transaction(scope, READWRITE)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+			txn.Abort()
+		}
+	}()
+
+	var onErrorFuncs []js.Func
+	defer func() {
+		for _, fn := range onErrorFuncs {
+			fn.Release()
+		}
+	}()
+
+	stores := make(map[string]*ObjectStore, len(scope))
+	var applied []int
+	for _, ent := range entries {
+		if !d.ContainsObjectStore(ent.store) {
+			continue
+		}
+		stor, ok := stores[ent.store]
+		if !ok {
+			stor, err = txn.GetObjectStore(ent.store)
+			if err != nil {
+				txn.Abort()
+				return err
+			}
+			stores[ent.store] = stor
+		}
+		req, err := ent.op.issue(stor)
+		if err != nil {
+			logDroppedWALEntry(ent, err)
+		} else {
+			ent := ent
+			onError := js.FuncOf(func(th js.Value, dats []js.Value) interface{} {
+				// prevent the error from aborting the replay.
+				dats[0].Call("preventDefault")
+				err := domError(req.Get("error"))
+				if ent.op.kind != durableOpAdd || err.(*DOMError).Name != "ConstraintError" {
+					logDroppedWALEntry(ent, err)
+				}
+				return nil
+			})
+			onErrorFuncs = append(onErrorFuncs, onError)
+			req.Set("onerror", onError)
+			if logChanges && ent.changelog != nil {
+				ent.changelog.logRequest(req, ent.store, ent.op)
+			}
+		}
+		if walStoreID != "" {
+			stor.val.Get("transaction").Call("objectStore", walStoreID).Call("delete", ent.seq)
+		} else {
+			applied = append(applied, ent.seq)
+		}
+	}
+	if walStoreID != "" {
+		walStore := txn.val.Call("objectStore", walStoreID)
+		for _, seq := range orphans {
+			walStore.Call("delete", seq)
+		}
+	} else {
+		applied = append(applied, orphans...)
+	}
+
+	// the transaction commits automatically: after an explicit commit, a
+	// failed request aborts the transaction even if the error is prevented.
+	if err := txn.WaitComplete(); err != nil {
+		return err
+	}
+	if len(applied) != 0 {
+		return wal.remove(applied)
+	}
+	return nil
+}
+
+// logDroppedWALEntry logs a write-ahead-log entry which failed to replay.
+func logDroppedWALEntry(ent *walEntry, err error) {
+	log.Printf("indexeddb: dropped write-ahead-log entry %d (%s %s): %v", ent.seq, ent.op.kind, ent.store, err)
+}
+
+// idbWAL stores the write-ahead-log in the WALObjectStoreID object store.
+type idbWAL struct {
+	db *Database
+	// owner is the id stored with appended entries.
+	owner string
+}
+
+// append persists an entry, returning the assigned sequence number.
+func (w *idbWAL) append(store string, op *durableOp, clog *changelog) (seq int, e error) {
+	txn, err := w.db.Transaction([]string{WALObjectStoreID}, READWRITE)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+			txn.Abort()
+		}
+	}()
+
+	obj := js.Global().Get("Object").New()
+	obj.Set("store", store)
+	obj.Set("op", string(op.kind))
+	obj.Set("key", op.key)
+	obj.Set("value", op.value)
+	if w.owner != "" {
+		obj.Set("owner", w.owner)
+	}
+	if clog != nil {
+		obj.Set("changelogValues", clog.values)
+	}
+	req := txn.val.Call("objectStore", WALObjectStoreID).Call("add", obj)
+	txn.Commit()
+	if err := txn.WaitComplete(); err != nil {
+		return 0, err
+	}
+	return req.Get("result").Int(), nil
+}
+
+// objectStoreID returns the object store holding the log.
+func (w *idbWAL) objectStoreID() string {
+	return WALObjectStoreID
+}
+
+// remove removes entries by sequence number.
+func (w *idbWAL) remove(seqs []int) (e error) {
+	if len(seqs) == 0 {
+		return nil
+	}
+	txn, err := w.db.Transaction([]string{WALObjectStoreID}, READWRITE)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+			txn.Abort()
+		}
+	}()
+
+	store := txn.val.Call("objectStore", WALObjectStoreID)
+	for _, seq := range seqs {
+		store.Call("delete", seq)
+	}
+	txn.Commit()
+	return txn.WaitComplete()
+}
+
+// load loads all entries in sequence order.
+func (w *idbWAL) load() ([]*walEntry, error) {
+	txn, err := w.db.Transaction([]string{WALObjectStoreID}, READONLY)
+	if err != nil {
+		return nil, err
+	}
+	store, err := txn.GetObjectStore(WALObjectStoreID)
+	if err != nil {
+		return nil, err
+	}
+	// issue both requests before waiting so the transaction stays active.
+	keysReq := store.val.Call("getAllKeys")
+	valsReq := store.val.Call("getAll")
+	keys, err := WaitRequest(keysReq)
+	if err != nil {
+		return nil, err
+	}
+	vals, err := WaitRequest(valsReq)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*walEntry, keys.Length())
+	for i := range entries {
+		val := vals.Index(i)
+		entries[i] = &walEntry{
+			seq:   keys.Index(i).Int(),
+			store: val.Get("store").String(),
+			op: &durableOp{
+				kind:  durableOpKind(val.Get("op").String()),
+				key:   val.Get("key"),
+				value: val.Get("value"),
+			},
+		}
+		if owner := val.Get("owner"); owner.Type() == js.TypeString {
+			entries[i].owner = owner.String()
+		}
+		if clogValues := val.Get("changelogValues"); clogValues.Type() == js.TypeBoolean {
+			entries[i].changelog = &changelog{values: clogValues.Bool()}
+		}
+	}
+	return entries, nil
+}
+
+// _ is a type assertion
+var _ writeAheadLog = ((*idbWAL)(nil))
+
+// localStorageWAL stores the write-ahead-log in localStorage.
+//
+// Keys and values are encoded with the tagged json encoding.
+type localStorageWAL struct {
+	ls     js.Value
+	prefix string
+	// owner is the id stored with appended entries.
+	owner string
+}
+
+// localStorageWALEntry is the json form of a walEntry.
+type localStorageWALEntry struct {
+	Store string          `json:"store"`
+	Op    string          `json:"op"`
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+	// Owner is the id of the Database handle which wrote the entry, if any.
+	Owner string `json:"owner,omitempty"`
+	// ChangelogValues is set if the op is recorded in the changelog.
+	ChangelogValues *bool `json:"changelogValues,omitempty"`
+}
+
+// seqKey is the localStorage key holding the last sequence number.
+func (w *localStorageWAL) seqKey() string {
+	return w.prefix + "seq"
+}
+
+// append persists an entry, returning the assigned sequence number.
+func (w *localStorageWAL) append(store string, op *durableOp, clog *changelog) (seq int, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+
+	ent := &localStorageWALEntry{Store: store, Op: string(op.kind), Owner: w.owner}
+	if clog != nil {
+		ent.ChangelogValues = &clog.values
+	}
+	var err error
+	ent.Key, err = marshalTaggedJs(js.ValueOf(op.key))
+	if err != nil {
+		return 0, err
+	}
+	ent.Value, err = marshalTaggedJs(js.ValueOf(op.value))
+	if err != nil {
+		return 0, err
+	}
+	dat, err := json.Marshal(ent)
+	if err != nil {
+		return 0, err
+	}
+
+	if last := w.ls.Call("getItem", w.seqKey()); last.Truthy() {
+		seq, err = strconv.Atoi(last.String())
+		if err != nil {
+			return 0, err
+		}
+	}
+	seq++
+	w.ls.Call("setItem", w.seqKey(), strconv.Itoa(seq))
+	w.ls.Call("setItem", w.prefix+strconv.Itoa(seq), string(dat))
+	return seq, nil
+}
+
+// objectStoreID returns an empty string: localStorage is not transactional.
+func (w *localStorageWAL) objectStoreID() string {
+	return ""
+}
+
+// remove removes entries by sequence number.
+func (w *localStorageWAL) remove(seqs []int) (e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+	for _, seq := range seqs {
+		w.ls.Call("removeItem", w.prefix+strconv.Itoa(seq))
+	}
+	return nil
+}
+
+// load loads all entries in sequence order.
+func (w *localStorageWAL) load() (_ []*walEntry, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, _ = recoveredError(err)
+		}
+	}()
+
+	var entries []*walEntry
+	n := w.ls.Get("length").Int()
+	for i := 0; i < n; i++ {
+		lsKey := w.ls.Call("key", i).String()
+		if !strings.HasPrefix(lsKey, w.prefix) {
+			continue
+		}
+		seq, err := strconv.Atoi(lsKey[len(w.prefix):])
+		if err != nil {
+			// not an entry (the sequence counter)
+			continue
+		}
+		ent := &localStorageWALEntry{}
+		if err := json.Unmarshal([]byte(w.ls.Call("getItem", lsKey).String()), ent); err != nil {
+			return nil, errors.Wrap(err, lsKey)
+		}
+		key, err := unmarshalTaggedJs(ent.Key)
+		if err != nil {
+			return nil, errors.Wrap(err, lsKey)
+		}
+		value, err := unmarshalTaggedJs(ent.Value)
+		if err != nil {
+			return nil, errors.Wrap(err, lsKey)
+		}
+		walEnt := &walEntry{
+			seq:   seq,
+			store: ent.Store,
+			owner: ent.Owner,
+			op: &durableOp{
+				kind:  durableOpKind(ent.Op),
+				key:   key,
+				value: value,
+			},
+		}
+		if ent.ChangelogValues != nil {
+			walEnt.changelog = &changelog{values: *ent.ChangelogValues}
+		}
+		entries = append(entries, walEnt)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+	return entries, nil
+}
+
+// _ is a type assertion
+var _ writeAheadLog = ((*localStorageWAL)(nil))